@@ -0,0 +1,98 @@
+package aggregate
+
+import (
+	"time"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+// Merge combines two samples of the same source into one covering the frequency and time
+// ranges of both. DBAvg is weighted by the sample counts.
+func Merge(a, b sdr.Sample) sdr.Sample {
+	merged := a
+	merged.FreqLow = min(a.FreqLow, b.FreqLow)
+	merged.FreqHigh = max(a.FreqHigh, b.FreqHigh)
+	merged.FreqCenter = (merged.FreqLow + merged.FreqHigh) / 2
+	if b.Start.Before(a.Start) {
+		merged.Start = b.Start
+	}
+	if b.End.After(a.End) {
+		merged.End = b.End
+	}
+	merged.DBLow = min(a.DBLow, b.DBLow)
+	merged.DBHigh = max(a.DBHigh, b.DBHigh)
+	merged.SampleCount = a.SampleCount + b.SampleCount
+	if merged.SampleCount > 0 {
+		merged.DBAvg = (a.DBAvg*float64(a.SampleCount) + b.DBAvg*float64(b.SampleCount)) / float64(merged.SampleCount)
+	}
+	return merged
+}
+
+// Decimator thins out samples by merging adjacent frequency bins and consecutive
+// integration intervals.
+type Decimator struct {
+	// FreqFactor is the number of adjacent frequency bins merged into one, 1 to disable.
+	FreqFactor int
+	// TimeFactor is the number of consecutive integration intervals merged into one, 1 to disable.
+	TimeFactor int
+	// Interval is the integration interval of the samples.
+	Interval time.Duration
+}
+
+type decimationKey struct {
+	source     string
+	identifier string
+	// freq is the index of the merged frequency bin.
+	freq int64
+}
+
+type decimationBucket struct {
+	// window is the index of the merged time window.
+	window int64
+	sample sdr.Sample
+}
+
+// Decimate merges the samples from input and writes the merged samples to output. A merged
+// sample is written once a sample of the next time window arrives for the same frequencies
+// or once the input is closed.
+func (d *Decimator) Decimate(input <-chan sdr.Sample, output chan<- sdr.Sample) error {
+	freqFactor := int64(max(d.FreqFactor, 1))
+	windowSize := time.Duration(max(d.TimeFactor, 1)) * d.Interval
+
+	var ref time.Time
+	buckets := map[decimationKey]decimationBucket{}
+	for s := range input {
+		key := decimationKey{
+			source:     s.Source,
+			identifier: s.Identifier,
+		}
+		if width := (s.FreqHigh - s.FreqLow) * freqFactor; width > 0 {
+			key.freq = s.FreqCenter / width
+		}
+		if ref.IsZero() {
+			// Center the windows around the start of the intervals so jitter in the
+			// sample timestamps doesn't move samples into the neighbouring window.
+			ref = s.Start.Add(-d.Interval / 2)
+		}
+		var window int64
+		if windowSize > 0 {
+			window = int64(s.Start.Sub(ref) / windowSize)
+		}
+
+		bucket, ok := buckets[key]
+		switch {
+		case !ok:
+			buckets[key] = decimationBucket{window: window, sample: s}
+		case window != bucket.window:
+			output <- bucket.sample
+			buckets[key] = decimationBucket{window: window, sample: s}
+		default:
+			bucket.sample = Merge(bucket.sample, s)
+			buckets[key] = bucket
+		}
+	}
+	for _, bucket := range buckets {
+		output <- bucket.sample
+	}
+	return nil
+}
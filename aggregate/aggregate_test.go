@@ -0,0 +1,132 @@
+package aggregate
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+func TestMerge(t *testing.T) {
+	start := time.Unix(100, 0)
+	tests := []struct {
+		name string
+		a, b sdr.Sample
+		want sdr.Sample
+	}{
+		{
+			name: "adjacent bins",
+			a:    sdr.Sample{Source: "s", FreqLow: 100, FreqHigh: 110, DBLow: -50, DBHigh: -40, DBAvg: -45, SampleCount: 1, Start: start, End: start.Add(time.Second)},
+			b:    sdr.Sample{Source: "s", FreqLow: 110, FreqHigh: 120, DBLow: -60, DBHigh: -30, DBAvg: -30, SampleCount: 2, Start: start, End: start.Add(time.Second)},
+			want: sdr.Sample{Source: "s", FreqLow: 100, FreqHigh: 120, FreqCenter: 110, DBLow: -60, DBHigh: -30, DBAvg: -35, SampleCount: 3, Start: start, End: start.Add(time.Second)},
+		},
+		{
+			name: "consecutive intervals in reverse order",
+			a:    sdr.Sample{FreqLow: 100, FreqHigh: 110, DBAvg: -40, SampleCount: 1, Start: start.Add(time.Second), End: start.Add(2 * time.Second)},
+			b:    sdr.Sample{FreqLow: 100, FreqHigh: 110, DBAvg: -50, SampleCount: 1, Start: start, End: start.Add(time.Second)},
+			want: sdr.Sample{FreqLow: 100, FreqHigh: 110, FreqCenter: 105, DBAvg: -45, SampleCount: 2, Start: start, End: start.Add(2 * time.Second)},
+		},
+		{
+			name: "without sample counts",
+			a:    sdr.Sample{FreqLow: 100, FreqHigh: 110, DBAvg: -40},
+			b:    sdr.Sample{FreqLow: 110, FreqHigh: 120, DBAvg: -50},
+			want: sdr.Sample{FreqLow: 100, FreqHigh: 120, FreqCenter: 110, DBAvg: -40},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Merge(tc.a, tc.b); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Merge() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecimate(t *testing.T) {
+	start := time.Unix(100, 0)
+	// sample returns the sample of the 10 Hz bin starting at freq in the integration interval i.
+	sample := func(freq int64, i int, db float64) sdr.Sample {
+		return sdr.Sample{
+			Source:      "s",
+			Identifier:  "id",
+			FreqLow:     freq,
+			FreqHigh:    freq + 10,
+			FreqCenter:  freq + 5,
+			DBLow:       db,
+			DBHigh:      db,
+			DBAvg:       db,
+			SampleCount: 1,
+			// Some jitter which must not move the sample to another window.
+			Start: start.Add(time.Duration(i)*time.Second + time.Duration(i%2)*100*time.Millisecond),
+			End:   start.Add(time.Duration(i+1) * time.Second),
+		}
+	}
+	input := []sdr.Sample{
+		sample(100, 0, -10), sample(110, 0, -20), sample(120, 0, -30), sample(130, 0, -40),
+		sample(100, 1, -30), sample(110, 1, -40), sample(120, 1, -50), sample(130, 1, -60),
+		sample(100, 2, -50), sample(110, 2, -60), sample(120, 2, -70), sample(130, 2, -80),
+	}
+	tests := []struct {
+		name       string
+		decimator  Decimator
+		wantCount  int
+		wantDBAvgs []float64 // sorted
+	}{
+		{
+			name:       "disabled",
+			decimator:  Decimator{FreqFactor: 1, TimeFactor: 1, Interval: time.Second},
+			wantCount:  12,
+			wantDBAvgs: []float64{-80, -70, -60, -60, -50, -50, -40, -40, -30, -30, -20, -10},
+		},
+		{
+			name:       "frequency",
+			decimator:  Decimator{FreqFactor: 2, TimeFactor: 1, Interval: time.Second},
+			wantCount:  6,
+			wantDBAvgs: []float64{-75, -55, -55, -35, -35, -15},
+		},
+		{
+			name:       "time",
+			decimator:  Decimator{FreqFactor: 1, TimeFactor: 2, Interval: time.Second},
+			wantCount:  8,
+			wantDBAvgs: []float64{-80, -70, -60, -50, -50, -40, -30, -20},
+		},
+		{
+			name:       "frequency and time",
+			decimator:  Decimator{FreqFactor: 2, TimeFactor: 2, Interval: time.Second},
+			wantCount:  4,
+			wantDBAvgs: []float64{-75, -55, -45, -25},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			in := make(chan sdr.Sample, len(input))
+			for _, s := range input {
+				in <- s
+			}
+			close(in)
+			out := make(chan sdr.Sample, len(input))
+			if err := tc.decimator.Decimate(in, out); err != nil {
+				t.Fatalf("Decimate() failed: %s", err)
+			}
+			close(out)
+			var dbAvgs []float64
+			var count int64
+			for s := range out {
+				dbAvgs = append(dbAvgs, s.DBAvg)
+				count += s.SampleCount
+			}
+			if len(dbAvgs) != tc.wantCount {
+				t.Fatalf("Decimate() returned %d samples, want %d", len(dbAvgs), tc.wantCount)
+			}
+			if count != int64(len(input)) {
+				t.Errorf("merged samples have a sample count of %d, want %d", count, len(input))
+			}
+			sort.Float64s(dbAvgs)
+			if !reflect.DeepEqual(dbAvgs, tc.wantDBAvgs) {
+				t.Errorf("Decimate() returned samples with average %v dB, want %v dB", dbAvgs, tc.wantDBAvgs)
+			}
+		})
+	}
+}
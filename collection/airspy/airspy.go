@@ -0,0 +1,46 @@
+package airspy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hb9tf/spectre/collection/powerscan"
+	"github.com/hb9tf/spectre/sdr"
+)
+
+const (
+	SourceName = "airspy"
+	// soapy_power supports Airspy devices through SoapySDR and outputs samples
+	// in the same format as rtl_power.
+	sweepAlias   = "soapy_power"
+	deviceDriver = "driver=airspy"
+)
+
+type SDR struct {
+	Identifier string
+}
+
+func (s SDR) Name() string {
+	return SourceName
+}
+
+func (s *SDR) Sweep(ctx context.Context, opts *sdr.Options, samples chan<- sdr.Sample) error {
+	defer close(samples)
+
+	args := []string{
+		fmt.Sprintf("--device=%s", deviceDriver),
+		fmt.Sprintf("--freq=%d:%d", opts.LowFreq, opts.HighFreq),
+		fmt.Sprintf("--bin-size=%d", opts.BinSize),
+		fmt.Sprintf("--time=%f", opts.IntegrationInterval.Seconds()),
+		"--continue",         // sweep until terminated
+		"--format=rtl_power", // output format compatible with rtl_power, dumped to stdout
+	}
+	sweep := &powerscan.Sweep{
+		Source:     SourceName,
+		Identifier: s.Identifier,
+		Command:    sweepAlias,
+		Args:       args,
+		SkipDCBin:  opts.SkipDCBin,
+	}
+	return sweep.Run(ctx, samples)
+}
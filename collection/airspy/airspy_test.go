@@ -0,0 +1,52 @@
+package airspy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+// TestSweep runs a fake soapy_power which records its arguments and prints one row in the
+// rtl_power format.
+func TestSweep(t *testing.T) {
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	script := `#!/bin/sh
+echo "$@" > ` + argsFile + `
+echo "2024-03-04, 05:06:07, 100000000, 100020000, 10000.00, 5, -10, -20"
+`
+	if err := os.WriteFile(filepath.Join(dir, sweepAlias), []byte(script), 0o755); err != nil {
+		t.Fatalf("unable to write fake %s: %s", sweepAlias, err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	opts := &sdr.Options{LowFreq: 100000000, HighFreq: 100020000, BinSize: 10000, IntegrationInterval: 2 * time.Second}
+	samples := make(chan sdr.Sample, 2)
+	if err := (&SDR{Identifier: "roof"}).Sweep(context.Background(), opts, samples); err != nil {
+		t.Fatalf("Sweep() failed: %s", err)
+	}
+	var count int
+	for s := range samples {
+		count++
+		if s.Source != SourceName || s.Identifier != "roof" {
+			t.Errorf("sample has source %q and identifier %q, want %q and roof", s.Source, s.Identifier, SourceName)
+		}
+	}
+	if count != 2 {
+		t.Errorf("Sweep() returned %d samples, want 2", count)
+	}
+
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("unable to read arguments: %s", err)
+	}
+	want := "--device=driver=airspy --freq=100000000:100020000 --bin-size=10000 --time=2.000000 --continue --format=rtl_power"
+	if got := strings.TrimSpace(string(args)); got != want {
+		t.Errorf("%s ran with %q, want %q", sweepAlias, got, want)
+	}
+}
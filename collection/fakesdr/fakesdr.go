@@ -0,0 +1,137 @@
+package fakesdr
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+const (
+	SourceName = "fake"
+
+	// sweepsPerInterval is the number of simulated sweeps aggregated into one sample per bin.
+	sweepsPerInterval = 10
+
+	noiseFloor  = -70.0 // dB
+	noiseSpread = 2.0   // standard deviation of the noise in dB
+)
+
+// carrier is a synthetic signal moving back and forth across the frequency range.
+type carrier struct {
+	// power is the peak power of the carrier in dB.
+	power float64
+	// width is the bandwidth of the carrier relative to the frequency range.
+	width float64
+	// period is the number of integration intervals it takes the carrier to move
+	// across the frequency range and back.
+	period float64
+	// phase is the initial position of the carrier in radians.
+	phase float64
+}
+
+var carriers = []carrier{
+	{power: -20, width: 0.01, period: 120, phase: 0},
+	{power: -35, width: 0.03, period: 300, phase: math.Pi / 2},
+}
+
+// SDR generates synthetic samples without any hardware which is useful for development
+// and demos. The signal only depends on the seed and the number of integration intervals
+// since the sweep started, only the timestamps of the samples depend on the wall clock.
+type SDR struct {
+	Identifier string
+	// Seed initializes the random noise.
+	Seed int64
+}
+
+func (s SDR) Name() string {
+	return SourceName
+}
+
+func (s *SDR) Sweep(ctx context.Context, opts *sdr.Options, samples chan<- sdr.Sample) error {
+	defer close(samples)
+
+	if opts.HighFreq <= opts.LowFreq {
+		return fmt.Errorf("high frequency (%d) must be above low frequency (%d)", opts.HighFreq, opts.LowFreq)
+	}
+	if opts.BinSize <= 0 {
+		return fmt.Errorf("bin size must be positive, got %d", opts.BinSize)
+	}
+	if opts.IntegrationInterval <= 0 {
+		return fmt.Errorf("integration interval must be positive, got %s", opts.IntegrationInterval)
+	}
+
+	rng := rand.New(rand.NewSource(s.Seed))
+	numBins := (opts.HighFreq - opts.LowFreq + opts.BinSize - 1) / opts.BinSize
+	slog.Info("generating synthetic bins", "source", SourceName, "identifier", s.Identifier, "count", numBins, "freqLow", opts.LowFreq, "freqHigh", opts.HighFreq)
+
+	ticker := time.NewTicker(opts.IntegrationInterval)
+	defer ticker.Stop()
+	start := time.Now().UTC()
+	for interval := 0; ; interval++ {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			end := now.UTC()
+			for bin := int64(0); bin < numBins; bin++ {
+				low := opts.LowFreq + bin*opts.BinSize
+				high := min(low+opts.BinSize, opts.HighFreq)
+				sample := s.sample(rng, opts, interval, low, high)
+				sample.Start = start
+				sample.End = end
+				select {
+				case samples <- sample:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+			start = end
+		}
+	}
+}
+
+// sample aggregates the simulated sweeps of one interval for the bin between low and high.
+func (s *SDR) sample(rng *rand.Rand, opts *sdr.Options, interval int, low, high int64) sdr.Sample {
+	center := (low + high) / 2
+	// Position of the bin relative to the frequency range, 0 to 1.
+	pos := float64(center-opts.LowFreq) / float64(opts.HighFreq-opts.LowFreq)
+
+	sample := sdr.Sample{
+		Identifier:  s.Identifier,
+		Source:      SourceName,
+		FreqCenter:  center,
+		FreqLow:     low,
+		FreqHigh:    high,
+		DBHigh:      math.Inf(-1),
+		DBLow:       math.Inf(1),
+		SampleCount: sweepsPerInterval,
+	}
+	var sum float64
+	for i := 0; i < sweepsPerInterval; i++ {
+		t := float64(interval) + float64(i)/sweepsPerInterval
+		db := level(pos, t, noiseFloor+rng.NormFloat64()*noiseSpread)
+		sum += db
+		sample.DBHigh = max(sample.DBHigh, db)
+		sample.DBLow = min(sample.DBLow, db)
+	}
+	sample.DBAvg = sum / sweepsPerInterval
+	return sample
+}
+
+// level returns the power in dB at the relative position pos and time t (in intervals)
+// by adding the power of all carriers to the noise.
+func level(pos, t, noise float64) float64 {
+	power := math.Pow(10, noise/10)
+	for _, c := range carriers {
+		// Keep the carriers within the inner 80% of the frequency range.
+		center := 0.5 + 0.4*math.Sin(2*math.Pi*t/c.period+c.phase)
+		d := (pos - center) / c.width
+		power += math.Pow(10, c.power/10) * math.Exp(-d*d)
+	}
+	return 10 * math.Log10(power)
+}
@@ -0,0 +1,106 @@
+package fakesdr
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+// sweep returns the samples of the first intervals of a sweep with the seed.
+func sweep(t *testing.T, seed int64, opts *sdr.Options, intervals int) []sdr.Sample {
+	t.Helper()
+	numBins := int((opts.HighFreq - opts.LowFreq + opts.BinSize - 1) / opts.BinSize)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	samples := make(chan sdr.Sample)
+	done := make(chan error, 1)
+	go func() { done <- (&SDR{Identifier: "fake", Seed: seed}).Sweep(ctx, opts, samples) }()
+
+	var got []sdr.Sample
+	for sample := range samples {
+		got = append(got, sample)
+		if len(got) == intervals*numBins {
+			cancel()
+			break
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Sweep() failed: %s", err)
+	}
+	return got
+}
+
+func TestSweep(t *testing.T) {
+	opts := &sdr.Options{LowFreq: 100, HighFreq: 1050, BinSize: 100, IntegrationInterval: time.Millisecond}
+	a := sweep(t, 1, opts, 3)
+	b := sweep(t, 1, opts, 3)
+	c := sweep(t, 2, opts, 3)
+
+	// The last bin is cut at the high frequency.
+	if got, want := a[9].FreqHigh, int64(1050); got != want {
+		t.Errorf("last bin ends at %d Hz, want %d Hz", got, want)
+	}
+	var differs bool
+	for i := range a {
+		s := a[i]
+		if s.Source != SourceName || s.Identifier != "fake" || s.SampleCount != sweepsPerInterval {
+			t.Errorf("sample %d has source %q, identifier %q and %d sweeps", i, s.Source, s.Identifier, s.SampleCount)
+		}
+		if !(s.DBLow <= s.DBAvg && s.DBAvg <= s.DBHigh) {
+			t.Errorf("sample %d has average %g dB outside of %g - %g dB", i, s.DBAvg, s.DBLow, s.DBHigh)
+		}
+		if !s.End.After(s.Start) {
+			t.Errorf("sample %d ends at %s, before its start at %s", i, s.End, s.Start)
+		}
+		// The signal only depends on the seed, not the timing.
+		if s.DBAvg != b[i].DBAvg || s.DBLow != b[i].DBLow || s.DBHigh != b[i].DBHigh {
+			t.Errorf("sample %d differs between sweeps with the same seed: %+v, %+v", i, s, b[i])
+		}
+		if s.DBAvg != c[i].DBAvg {
+			differs = true
+		}
+	}
+	if !differs {
+		t.Error("sweeps with different seeds generated the same samples")
+	}
+}
+
+func TestSweepInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts sdr.Options
+	}{
+		{name: "high below low", opts: sdr.Options{LowFreq: 200, HighFreq: 100, BinSize: 10, IntegrationInterval: time.Second}},
+		{name: "zero bin size", opts: sdr.Options{LowFreq: 100, HighFreq: 200, IntegrationInterval: time.Second}},
+		{name: "zero interval", opts: sdr.Options{LowFreq: 100, HighFreq: 200, BinSize: 10}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			samples := make(chan sdr.Sample)
+			if err := (&SDR{}).Sweep(context.Background(), &tc.opts, samples); err == nil {
+				t.Error("Sweep() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestLevel(t *testing.T) {
+	tests := []struct {
+		name     string
+		pos, t   float64
+		min, max float64
+	}{
+		// The first carrier is at the center of the range at t=0 and the second one at 90%.
+		{name: "first carrier", pos: 0.5, t: 0, min: -20, max: -19.9},
+		{name: "second carrier", pos: 0.9, t: 0, min: -35, max: -34.9},
+		{name: "noise", pos: 0.05, t: 0, min: noiseFloor, max: noiseFloor + 0.1},
+	}
+	for _, tc := range tests {
+		if got := level(tc.pos, tc.t, noiseFloor); got < tc.min || got > tc.max || math.IsNaN(got) {
+			t.Errorf("level(%g, %g) = %g dB, want %g - %g dB", tc.pos, tc.t, got, tc.min, tc.max)
+		}
+	}
+}
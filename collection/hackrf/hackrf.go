@@ -2,46 +2,80 @@ package hackrf
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"log/slog"
+	"os"
 	"os/exec"
-	"strconv"
-	"strings"
-	"sync"
 	"time"
 
-	"github.com/golang/glog"
-
+	"github.com/hb9tf/spectre/collection/powerscan"
 	"github.com/hb9tf/spectre/sdr"
 )
 
 const (
 	SourceName = "hackrf"
 	sweepAlias = "hackrf_sweep"
+
+	maxLNAGain  = 40
+	lnaGainStep = 8
+	maxVGAGain  = 62
+	vgaGainStep = 2
+
+	// stopTimeout is how long to wait for the command to exit after interrupting it.
+	stopTimeout = 5 * time.Second
 )
 
 type SDR struct {
 	Identifier string
-
-	buckets   map[int64]sdr.Sample
-	bucketsMu *sync.Mutex
+	// Serial selects the HackRF to use by its serial number, the first device found is
+	// used if empty.
+	Serial string
+
+	// buckets holds the samples aggregated since the last flush. It is only accessed
+	// from the select loop in Sweep which both aggregates and flushes, so no locking
+	// is needed.
+	buckets map[int64]sdr.Sample
 }
 
 func (s SDR) Name() string {
 	return SourceName
 }
 
-func (s *SDR) Sweep(opts *sdr.Options, samples chan<- sdr.Sample) error {
+func (s *SDR) Sweep(ctx context.Context, opts *sdr.Options, samples chan<- sdr.Sample) error {
+	defer close(samples)
+
+	if err := validateGains(opts); err != nil {
+		return err
+	}
+
 	s.buckets = map[int64]sdr.Sample{}
-	s.bucketsMu = &sync.Mutex{}
 
+	amp := 0
+	if opts.AmpEnable {
+		amp = 1
+	}
 	args := []string{
 		fmt.Sprintf("-f %d:%d", opts.LowFreq/1000000, opts.HighFreq/1000000),
 		fmt.Sprintf("-w %d", opts.BinSize),
-		"-a 1",  // RX RF amplifier 1=Enable, 0=Disable
-		"-l 16", // RX LNA (IF) gain, 0-40dB, 8dB steps
-		"-g 20", // RX VGA (baseband) gain, 0-62dB, 2dB steps
+		fmt.Sprintf("-a %d", amp),          // RX RF amplifier 1=Enable, 0=Disable
+		fmt.Sprintf("-l %d", opts.LNAGain), // RX LNA (IF) gain, 0-40dB, 8dB steps
+		fmt.Sprintf("-g %d", opts.VGAGain), // RX VGA (baseband) gain, 0-62dB, 2dB steps
 	}
-	cmd := exec.Command(sweepAlias, args...)
+	if s.Serial != "" {
+		// Passed as separate arguments, the serial would otherwise include the leading space.
+		args = append(args, "-d", s.Serial)
+	}
+	// cmdCtx stops the command if Sweep returns early, e.g. when its output can't be read.
+	cmdCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(cmdCtx, sweepAlias, args...)
+	// Interrupt the command when the context is cancelled so it can exit cleanly,
+	// only kill it if it doesn't exit in time.
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = stopTimeout
 	out, err := cmd.StdoutPipe()
 	if err != nil {
 		return err
@@ -49,133 +83,118 @@ func (s *SDR) Sweep(opts *sdr.Options, samples chan<- sdr.Sample) error {
 
 	scanner := bufio.NewScanner(out)
 	// Start() executes command asynchronically.
-	fmt.Printf("Running HackRF sweep: %q\n", cmd)
+	slog.Info("running sweep", "source", SourceName, "identifier", s.Identifier, "command", cmd.String())
 	if err := cmd.Start(); err != nil {
-		glog.Fatalf("unable to start sweep: %s\n", err)
+		return fmt.Errorf("unable to start sweep: %s", err)
 	}
-	go func() {
-		if err := cmd.Wait(); err != nil {
-			glog.Exitf("sweep command ended with error: %s\n", err)
-		} else {
-			glog.Exit("sweep command ended successfully")
-		}
-	}()
 
 	rawSamples := make(chan sdr.Sample)
-	// Start raw sample processing.
+	scanErr := make(chan error, 1)
+	// Start raw sample processing. The goroutine ends once the output is closed or
+	// can't be read anymore which also happens when the command is stopped.
 	go func() {
+		defer close(rawSamples)
 		for scanner.Scan() {
-			if err := s.scanRow(scanner, rawSamples); err != nil {
-				glog.Warningf("error parsing line: %s\n", err)
+			slog.Debug("sweep output", "line", scanner.Text())
+			rowSamples, err := s.parseRow(scanner.Text())
+			if err != nil {
+				slog.Warn("error parsing line", "source", SourceName, "error", err)
 				continue
 			}
-		}
-	}()
-
-	// Output aggregated samples in regular ticks.
-	ticker := time.NewTicker(opts.IntegrationInterval)
-	go func() {
-		for range ticker.C {
-			// This is not concurrency friendly... Buuut it's ok:
-			// We're creating a new bucket to store new records in
-			// and operate on the old one afterwards. Since we aggregate,
-			// we won't miss much ¯\_(ツ)_/¯
-			old := s.buckets
-			s.bucketsMu.Lock()
-			s.buckets = map[int64]sdr.Sample{}
-			s.bucketsMu.Unlock()
-
-			for _, sample := range old {
-				samples <- sample
+			for _, sample := range rowSamples {
+				rawSamples <- sample
 			}
 		}
+		scanErr <- scanner.Err()
 	}()
 
-	// Aggregate samples in frequency buckets.
-	for sample := range rawSamples {
-		stored, ok := s.buckets[sample.FreqCenter]
-		if !ok {
-			s.buckets[sample.FreqCenter] = sample
-			continue
-		}
-		stored.End = sample.End
-		stored.DBAvg = (stored.DBAvg*float64(stored.SampleCount) + sample.DBAvg*float64(sample.SampleCount)) / float64(stored.SampleCount+sample.SampleCount)
-		if sample.DBLow < stored.DBLow {
-			stored.DBLow = sample.DBLow
-		}
-		if sample.DBHigh > stored.DBHigh {
-			stored.DBHigh = sample.DBHigh
+	// Aggregate samples in frequency buckets and output them in regular ticks. With interval
+	// overrides, the ticks are at the shortest interval and each bucket is output every
+	// multiple of it its interval corresponds to.
+	ticker := time.NewTicker(opts.MinInterval())
+	defer ticker.Stop()
+	tick := 0
+	for {
+		select {
+		case sample, ok := <-rawSamples:
+			if !ok {
+				// The output is closed, flush what has been aggregated so far
+				// and wait for the command to exit.
+				s.flush(samples)
+				if err := <-scanErr; err != nil {
+					cancel()
+					cmd.Wait()
+					return fmt.Errorf("unable to read sweep output: %s", err)
+				}
+				if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+					return fmt.Errorf("sweep command ended with error: %s", err)
+				}
+				slog.Info("sweep command ended successfully", "source", SourceName, "identifier", s.Identifier)
+				return nil
+			}
+			s.aggregate(sample)
+		case <-ticker.C:
+			tick++
+			s.flushDue(samples, opts, tick)
 		}
-		stored.SampleCount += sample.SampleCount
-		s.bucketsMu.Lock()
-		s.buckets[sample.FreqCenter] = stored
-		s.bucketsMu.Unlock()
 	}
-
-	return nil
 }
 
-func parseInt(num string) (int64, error) {
-	return strconv.ParseInt(strings.Split(num, ".")[0], 10, 64)
+// aggregate adds the sample to its frequency bucket.
+func (s *SDR) aggregate(sample sdr.Sample) {
+	stored, ok := s.buckets[sample.FreqCenter]
+	if !ok {
+		s.buckets[sample.FreqCenter] = sample
+		return
+	}
+	stored.End = sample.End
+	stored.DBAvg = (stored.DBAvg*float64(stored.SampleCount) + sample.DBAvg*float64(sample.SampleCount)) / float64(stored.SampleCount+sample.SampleCount)
+	if sample.DBLow < stored.DBLow {
+		stored.DBLow = sample.DBLow
+	}
+	if sample.DBHigh > stored.DBHigh {
+		stored.DBHigh = sample.DBHigh
+	}
+	stored.SampleCount += sample.SampleCount
+	s.buckets[sample.FreqCenter] = stored
 }
 
-// calculateBinRange calculates the highest and lowest frequencies in a bin
-func calculateBinRange(freqLow, freqHigh, binWidth, binNum int64) (int64, int64) {
-	low := freqLow + (binNum * binWidth)
-	high := low + binWidth
-	if high > freqHigh {
-		high = freqHigh
+// flush outputs all aggregated samples and starts with empty buckets.
+func (s *SDR) flush(samples chan<- sdr.Sample) {
+	for _, sample := range s.buckets {
+		samples <- sample
 	}
-	return low, high
+	s.buckets = map[int64]sdr.Sample{}
 }
-func (s *SDR) scanRow(scanner *bufio.Scanner, samples chan<- sdr.Sample) error {
-	glog.V(3).Info(scanner.Text())
-	row := strings.Split(scanner.Text(), ", ")
-	numBins := len(row) - 6
 
-	sampleCount, err := parseInt(row[5])
-	if err != nil {
-		return err
-	}
-	freqLow, err := parseInt(row[2])
-	if err != nil {
-		return err
-	}
-	freqHigh, err := parseInt(row[3])
-	if err != nil {
-		return err
+// flushDue outputs the aggregated samples whose integration interval ends with the tick.
+func (s *SDR) flushDue(samples chan<- sdr.Sample, opts *sdr.Options, tick int) {
+	if len(opts.IntervalOverrides) == 0 {
+		s.flush(samples)
+		return
 	}
-	binWidth, err := parseInt(row[4])
-	if err != nil {
-		return err
-	}
-
-	for i := 0; i < numBins; i++ {
-		low, high := calculateBinRange(freqLow, freqHigh, binWidth, int64(i))
-		binRowIndex := i + 6
-		parsedTime, err := time.Parse(time.RFC3339, row[0]+"T"+row[1]+"Z")
-		if err != nil {
-			return err
-		}
-
-		decibels, err := strconv.ParseFloat(row[binRowIndex], 64)
-		if err != nil {
-			return err
+	for freq, sample := range s.buckets {
+		if tick%opts.IntervalMultiple(freq) == 0 {
+			samples <- sample
+			delete(s.buckets, freq)
 		}
+	}
+}
 
-		samples <- sdr.Sample{
-			Identifier:  s.Identifier,
-			Source:      s.Name(),
-			FreqCenter:  (low + high) / 2,
-			FreqLow:     low,
-			FreqHigh:    high,
-			DBLow:       decibels,
-			DBHigh:      decibels,
-			DBAvg:       decibels,
-			SampleCount: sampleCount,
-			Start:       parsedTime,
-			End:         parsedTime,
-		}
+// validateGains checks that the gains are within the ranges and steps supported by hackrf_sweep.
+func validateGains(opts *sdr.Options) error {
+	if opts.LNAGain < 0 || opts.LNAGain > maxLNAGain || opts.LNAGain%lnaGainStep != 0 {
+		return fmt.Errorf("LNA gain must be between 0 and %d dB in %d dB steps, got %d", maxLNAGain, lnaGainStep, opts.LNAGain)
+	}
+	if opts.VGAGain < 0 || opts.VGAGain > maxVGAGain || opts.VGAGain%vgaGainStep != 0 {
+		return fmt.Errorf("VGA gain must be between 0 and %d dB in %d dB steps, got %d", maxVGAGain, vgaGainStep, opts.VGAGain)
 	}
 	return nil
 }
+
+// parseRow parses one line of the hackrf_sweep output into samples. SkipDCBin doesn't apply:
+// hackrf_sweep tunes with an offset so that each row is a slice of the band next to the
+// tuned frequency, i.e. no row contains the DC spike and the center bin is a real signal.
+func (s *SDR) parseRow(line string) ([]sdr.Sample, error) {
+	return powerscan.ParseRow(line, SourceName, s.Identifier, false)
+}
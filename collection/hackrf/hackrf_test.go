@@ -0,0 +1,191 @@
+package hackrf
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+func TestParseRowKeepsCenterBin(t *testing.T) {
+	s := &SDR{Identifier: "id"}
+	// A quarter bandwidth slice next to the tuned frequency, the strong center bin is a real signal.
+	samples, err := s.parseRow("2024-03-04, 05:06:07.123, 2400000000, 2405000000, 1000000.00, 20, -60, -58, -10, -57, -61")
+	if err != nil {
+		t.Fatalf("parseRow() failed: %s", err)
+	}
+	want := []float64{-60, -58, -10, -57, -61}
+	if len(samples) != len(want) {
+		t.Fatalf("parseRow() returned %d samples, want %d", len(samples), len(want))
+	}
+	for i, s := range samples {
+		if s.DBAvg != want[i] {
+			t.Errorf("bin %d has %g dB, want %g", i, s.DBAvg, want[i])
+		}
+		if s.Source != SourceName || s.Identifier != "id" {
+			t.Errorf("bin %d has source %q and identifier %q, want %q and id", i, s.Source, s.Identifier, SourceName)
+		}
+	}
+	if got, want := samples[2].FreqCenter, int64(2402500000); got != want {
+		t.Errorf("center bin is at %d Hz, want %d Hz", got, want)
+	}
+}
+
+func TestValidateGains(t *testing.T) {
+	tests := []struct {
+		name    string
+		lna     int
+		vga     int
+		wantErr bool
+	}{
+		{name: "defaults", lna: 16, vga: 20},
+		{name: "maximum", lna: maxLNAGain, vga: maxVGAGain},
+		{name: "minimum", lna: 0, vga: 0},
+		{name: "LNA gain too high", lna: 48, vga: 20, wantErr: true},
+		{name: "LNA gain not in steps", lna: 10, vga: 20, wantErr: true},
+		{name: "negative VGA gain", lna: 16, vga: -2, wantErr: true},
+		{name: "VGA gain not in steps", lna: 16, vga: 21, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateGains(&sdr.Options{LNAGain: tc.lna, VGAGain: tc.vga})
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("validateGains(%d, %d) error = %v, want error: %t", tc.lna, tc.vga, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	start := time.Unix(100, 0)
+	s := &SDR{buckets: map[int64]sdr.Sample{}}
+	s.aggregate(sdr.Sample{FreqCenter: 1, DBLow: -10, DBHigh: -10, DBAvg: -10, SampleCount: 1, Start: start, End: start})
+	s.aggregate(sdr.Sample{FreqCenter: 1, DBLow: -40, DBHigh: -40, DBAvg: -40, SampleCount: 2, Start: start.Add(time.Second), End: start.Add(time.Second)})
+	s.aggregate(sdr.Sample{FreqCenter: 2, DBLow: -5, DBHigh: -5, DBAvg: -5, SampleCount: 1, Start: start, End: start})
+
+	got := s.buckets[1]
+	want := sdr.Sample{FreqCenter: 1, DBLow: -40, DBHigh: -10, DBAvg: -30, SampleCount: 3, Start: start, End: start.Add(time.Second)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("aggregated sample = %+v, want %+v", got, want)
+	}
+	if len(s.buckets) != 2 {
+		t.Errorf("got %d buckets, want 2", len(s.buckets))
+	}
+}
+
+func TestFlushDue(t *testing.T) {
+	opts := &sdr.Options{
+		IntegrationInterval: time.Second,
+		IntervalOverrides:   []sdr.IntervalOverride{{FreqLow: 200, FreqHigh: 300, Interval: 3 * time.Second}},
+	}
+	tests := []struct {
+		tick int
+		want []int64
+	}{
+		{tick: 1, want: []int64{100}},
+		{tick: 2, want: []int64{100}},
+		{tick: 3, want: []int64{100, 250}},
+	}
+	for _, tc := range tests {
+		s := &SDR{buckets: map[int64]sdr.Sample{100: {FreqCenter: 100}, 250: {FreqCenter: 250}}}
+		samples := make(chan sdr.Sample, 2)
+		s.flushDue(samples, opts, tc.tick)
+		close(samples)
+		var got []int64
+		for sample := range samples {
+			got = append(got, sample.FreqCenter)
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+		if len(got) != len(tc.want) || (len(got) > 1 && got[1] != tc.want[1]) || got[0] != tc.want[0] {
+			t.Errorf("tick %d flushed %v, want %v", tc.tick, got, tc.want)
+		}
+		if remaining := 2 - len(got); len(s.buckets) != remaining {
+			t.Errorf("tick %d left %d buckets, want %d", tc.tick, len(s.buckets), remaining)
+		}
+	}
+}
+
+// installFakeSweep puts a hackrf_sweep shell script running the commands first in PATH.
+func installFakeSweep(t *testing.T, commands string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, sweepAlias), []byte("#!/bin/sh\n"+commands+"\n"), 0o755); err != nil {
+		t.Fatalf("unable to write fake %s: %s", sweepAlias, err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestSweepSpreadsDB(t *testing.T) {
+	// Four sweeps of the same bin within one integration interval.
+	installFakeSweep(t, `for db in -10 -20 -30 -15; do
+	echo "2024-03-04, 05:06:07.123, 2400000000, 2401000000, 1000000.00, 1, $db"
+done`)
+
+	s := &SDR{Identifier: "id"}
+	opts := &sdr.Options{
+		LowFreq:             2400000000,
+		HighFreq:            2401000000,
+		BinSize:             1000000,
+		IntegrationInterval: time.Hour,
+	}
+	samples := make(chan sdr.Sample, 10)
+	if err := s.Sweep(context.Background(), opts, samples); err != nil {
+		t.Fatalf("Sweep() failed: %s", err)
+	}
+	var got []sdr.Sample
+	for sample := range samples {
+		got = append(got, sample)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d samples, want 1: %+v", len(got), got)
+	}
+	if got[0].DBLow != -30 || got[0].DBHigh != -10 || got[0].DBAvg != -18.75 || got[0].SampleCount != 4 {
+		t.Errorf("sample has dB low/high/avg %g/%g/%g of %d readings, want -30/-10/-18.75 of 4", got[0].DBLow, got[0].DBHigh, got[0].DBAvg, got[0].SampleCount)
+	}
+}
+
+// TestSweepAggregatesWhileFlushing runs a fake hackrf_sweep whose output is parsed while the
+// ticks flush the buckets, run it with -race to check the buckets aren't shared.
+func TestSweepAggregatesWhileFlushing(t *testing.T) {
+	const rows = 500
+	installFakeSweep(t, fmt.Sprintf(`i=0
+while [ $i -lt %d ]; do
+	echo "2024-03-04, 05:06:07.123, 2400000000, 2405000000, 1000000.00, 1, -60, -58, -10, -57, -61"
+	i=$((i+1))
+done`, rows))
+
+	s := &SDR{Identifier: "id"}
+	opts := &sdr.Options{
+		LowFreq:             2400000000,
+		HighFreq:            2405000000,
+		BinSize:             1000000,
+		LNAGain:             16,
+		VGAGain:             20,
+		IntegrationInterval: time.Millisecond,
+	}
+	samples := make(chan sdr.Sample)
+	done := make(chan error, 1)
+	go func() { done <- s.Sweep(context.Background(), opts, samples) }()
+
+	counts := map[int64]int64{}
+	for sample := range samples {
+		counts[sample.FreqCenter] += sample.SampleCount
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Sweep() failed: %s", err)
+	}
+	if len(counts) != 5 {
+		t.Errorf("got samples of %d bins, want 5", len(counts))
+	}
+	for freq, count := range counts {
+		if count != rows {
+			t.Errorf("bin at %d Hz aggregated %d samples, want %d", freq, count, rows)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Package powerscan runs sweep tools writing the CSV format of rtl_power (e.g. rtl_power
+// itself or soapy_power with --format=rtl_power) and parses their output into samples.
+// hackrf_sweep writes the same format but is run by the hackrf package which aggregates
+// its samples.
+package powerscan
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+// stopTimeout is how long to wait for the command to exit after interrupting it.
+const stopTimeout = 5 * time.Second
+
+// Layouts of the date and time fields, time.Parse accepts fractional seconds for all of them.
+var timeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006/01/02 15:04:05",
+}
+
+// Sweep is a run of a sweep tool writing its samples to stdout.
+type Sweep struct {
+	// Source and Identifier are set on all samples.
+	Source     string
+	Identifier string
+	// Command is the sweep tool to run with Args.
+	Command string
+	Args    []string
+	// SkipDCBin drops the center bin of each row which contains the DC spike.
+	SkipDCBin bool
+}
+
+// Run runs the command and writes the samples parsed from its output to the channel until
+// the command exits or the context is cancelled. The command is interrupted when the context
+// is cancelled so it can exit cleanly. The channel is not closed.
+func (s *Sweep) Run(ctx context.Context, samples chan<- sdr.Sample) error {
+	// cmdCtx stops the command if Run returns early, e.g. when its output can't be read.
+	cmdCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(cmdCtx, s.Command, s.Args...)
+	// Only kill the command if it doesn't exit in time after the interrupt.
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = stopTimeout
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(out)
+	// Start() executes command asynchronically.
+	slog.Info("running sweep", "source", s.Source, "identifier", s.Identifier, "command", cmd.String())
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("unable to start sweep: %s", err)
+	}
+
+	// Start raw sample processing.
+	for scanner.Scan() {
+		slog.Debug("sweep output", "line", scanner.Text())
+		rowSamples, err := ParseRow(scanner.Text(), s.Source, s.Identifier, s.SkipDCBin)
+		if err != nil {
+			slog.Warn("error parsing line", "source", s.Source, "error", err)
+			continue
+		}
+		for _, sample := range rowSamples {
+			samples <- sample
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		// E.g. a line longer than the scanner's buffer, stop the command as its
+		// output isn't read anymore.
+		cancel()
+		cmd.Wait()
+		return fmt.Errorf("unable to read sweep output: %s", err)
+	}
+
+	// The output is closed, wait for the command to exit.
+	if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("sweep command ended with error: %s", err)
+	}
+	slog.Info("sweep command ended successfully", "source", s.Source, "identifier", s.Identifier)
+
+	return nil
+}
+
+// ParseRow parses one line of the sweep output into samples. If skipDC is set, the center
+// bin containing the DC spike is dropped. Dead bins reported as -inf or nan are skipped as
+// they would break scaling the dB values when rendering.
+func ParseRow(line, source, identifier string, skipDC bool) ([]sdr.Sample, error) {
+	row := strings.Split(line, ", ")
+	// A row consists of 6 metadata fields followed by at least one bin.
+	if len(row) < 7 {
+		return nil, fmt.Errorf("malformed line with %d instead of at least 7 fields: %q", len(row), line)
+	}
+	numBins := len(row) - 6
+
+	sampleCount, err := parseInt(row[5])
+	if err != nil {
+		return nil, err
+	}
+	freqLow, err := parseInt(row[2])
+	if err != nil {
+		return nil, err
+	}
+	freqHigh, err := parseInt(row[3])
+	if err != nil {
+		return nil, err
+	}
+	binWidth, err := parseInt(row[4])
+	if err != nil {
+		return nil, err
+	}
+
+	parsedTime, err := parseTime(row[0], row[1])
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]sdr.Sample, 0, numBins)
+	for i := 0; i < numBins; i++ {
+		if skipDC && i == numBins/2 {
+			continue
+		}
+		low, high := calculateBinRange(freqLow, freqHigh, binWidth, int64(i))
+		binRowIndex := i + 6
+
+		decibels, err := parseDB(row[binRowIndex])
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(decibels) || math.IsInf(decibels, 0) {
+			slog.Debug("skipping bin", "freqLow", low, "freqHigh", high, "db", row[binRowIndex])
+			continue
+		}
+
+		samples = append(samples, sdr.Sample{
+			Identifier:  identifier,
+			Source:      source,
+			FreqCenter:  (low + high) / 2,
+			FreqLow:     low,
+			FreqHigh:    high,
+			DBLow:       decibels,
+			DBHigh:      decibels,
+			DBAvg:       decibels,
+			SampleCount: sampleCount,
+			Start:       parsedTime,
+			End:         parsedTime,
+		})
+	}
+	return samples, nil
+}
+
+func parseInt(num string) (int64, error) {
+	return strconv.ParseInt(strings.Split(strings.TrimSpace(num), ".")[0], 10, 64)
+}
+
+// parseTime parses the date and time fields of a row as UTC. Fractional seconds are optional.
+func parseTime(date, clock string) (time.Time, error) {
+	value := strings.TrimSpace(date) + " " + strings.TrimSpace(clock)
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to parse timestamp %q", value)
+}
+
+// parseDB parses a dB value. Signed NaNs as printed by C (e.g. "-nan") are accepted as well.
+func parseDB(db string) (float64, error) {
+	db = strings.TrimSpace(db)
+	v, err := strconv.ParseFloat(db, 64)
+	if err != nil && strings.EqualFold(strings.TrimLeft(db, "+-"), "nan") {
+		return math.NaN(), nil
+	}
+	return v, err
+}
+
+// calculateBinRange calculates the highest and lowest frequencies in a bin
+func calculateBinRange(freqLow, freqHigh, binWidth, binNum int64) (int64, int64) {
+	low := freqLow + (binNum * binWidth)
+	high := low + binWidth
+	if high > freqHigh {
+		high = freqHigh
+	}
+	return low, high
+}
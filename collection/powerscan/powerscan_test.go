@@ -0,0 +1,187 @@
+package powerscan
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+func TestParseRow(t *testing.T) {
+	start := time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC)
+	tests := []struct {
+		name    string
+		line    string
+		skipDC  bool
+		want    []sdr.Sample
+		wantErr bool
+	}{
+		{
+			name: "rtl_power row",
+			line: "2024-03-04, 05:06:07, 100000000, 100030000, 10000.00, 5, -10.5, -20.25, -30",
+			want: []sdr.Sample{
+				{FreqLow: 100000000, FreqHigh: 100010000, DBAvg: -10.5},
+				{FreqLow: 100010000, FreqHigh: 100020000, DBAvg: -20.25},
+				{FreqLow: 100020000, FreqHigh: 100030000, DBAvg: -30},
+			},
+		},
+		{
+			name: "frequencies above 2^31 Hz",
+			line: "2024-03-04, 05:06:07, 2400000000, 2400020000, 10000.00, 5, -10, -20",
+			want: []sdr.Sample{
+				{FreqLow: 2400000000, FreqHigh: 2400010000, DBAvg: -10},
+				{FreqLow: 2400010000, FreqHigh: 2400020000, DBAvg: -20},
+			},
+		},
+		{
+			name: "fractional seconds and slashes",
+			line: "2024/03/04, 05:06:07.250, 100000000, 100010000, 10000, 5, -10",
+			want: []sdr.Sample{
+				{FreqLow: 100000000, FreqHigh: 100010000, DBAvg: -10, Start: start.Add(250 * time.Millisecond)},
+			},
+		},
+		{
+			name: "last bin is cut at the high frequency",
+			line: "2024-03-04, 05:06:07, 100000000, 100015000, 10000, 5, -10, -20",
+			want: []sdr.Sample{
+				{FreqLow: 100000000, FreqHigh: 100010000, DBAvg: -10},
+				{FreqLow: 100010000, FreqHigh: 100015000, DBAvg: -20},
+			},
+		},
+		{
+			name:   "DC bin is skipped",
+			line:   "2024-03-04, 05:06:07, 100000000, 100030000, 10000, 5, -10, 20, -30",
+			skipDC: true,
+			want: []sdr.Sample{
+				{FreqLow: 100000000, FreqHigh: 100010000, DBAvg: -10},
+				{FreqLow: 100020000, FreqHigh: 100030000, DBAvg: -30},
+			},
+		},
+		{
+			name: "dead bins are skipped",
+			line: "2024-03-04, 05:06:07, 100000000, 100040000, 10000, 5, -nan, -inf, nan, -40",
+			want: []sdr.Sample{
+				{FreqLow: 100030000, FreqHigh: 100040000, DBAvg: -40},
+			},
+		},
+		{
+			name: "trailing whitespace",
+			line: "2024-03-04, 05:06:07, 100000000, 100010000, 10000, 5, -10\r",
+			want: []sdr.Sample{
+				{FreqLow: 100000000, FreqHigh: 100010000, DBAvg: -10},
+			},
+		},
+		{
+			name:    "too few fields",
+			line:    "2024-03-04, 05:06:07, 100000000, 100010000, 10000, 5",
+			wantErr: true,
+		},
+		{
+			name:    "invalid frequency",
+			line:    "2024-03-04, 05:06:07, abc, 100010000, 10000, 5, -10",
+			wantErr: true,
+		},
+		{
+			name:    "invalid timestamp",
+			line:    "04.03.2024, 05:06:07, 100000000, 100010000, 10000, 5, -10",
+			wantErr: true,
+		},
+		{
+			name:    "invalid dB value",
+			line:    "2024-03-04, 05:06:07, 100000000, 100020000, 10000, 5, -10, loud",
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseRow(tc.line, "rtlsdr", "id", tc.skipDC)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("ParseRow() error = %v, want error: %t", err, tc.wantErr)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("ParseRow() returned %d samples, want %d: %+v", len(got), len(tc.want), got)
+			}
+			for i, want := range tc.want {
+				if want.Start.IsZero() {
+					want.Start = start
+				}
+				s := got[i]
+				if s.Source != "rtlsdr" || s.Identifier != "id" || s.SampleCount != 5 {
+					t.Errorf("sample %d has source %q, identifier %q and count %d, want rtlsdr, id and 5", i, s.Source, s.Identifier, s.SampleCount)
+				}
+				if s.FreqLow != want.FreqLow || s.FreqHigh != want.FreqHigh || s.FreqCenter != (want.FreqLow+want.FreqHigh)/2 {
+					t.Errorf("sample %d covers %d-%d Hz (center %d), want %d-%d Hz", i, s.FreqLow, s.FreqHigh, s.FreqCenter, want.FreqLow, want.FreqHigh)
+				}
+				if s.DBAvg != want.DBAvg || s.DBLow != want.DBAvg || s.DBHigh != want.DBAvg {
+					t.Errorf("sample %d has dB %g/%g/%g, want %g", i, s.DBLow, s.DBAvg, s.DBHigh, want.DBAvg)
+				}
+				if !s.Start.Equal(want.Start) || !s.End.Equal(want.Start) {
+					t.Errorf("sample %d is from %s to %s, want %s", i, s.Start, s.End, want.Start)
+				}
+			}
+		})
+	}
+}
+
+func TestParseDB(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{in: "-12.5", want: -12.5},
+		{in: "nan", want: math.NaN()},
+		{in: "-nan", want: math.NaN()},
+		{in: "-inf", want: math.Inf(-1)},
+		{in: "x", wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := parseDB(tc.in)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("parseDB(%q) error = %v, want error: %t", tc.in, err, tc.wantErr)
+			continue
+		}
+		if tc.wantErr {
+			continue
+		}
+		if got != tc.want && !(math.IsNaN(got) && math.IsNaN(tc.want)) {
+			t.Errorf("parseDB(%q) = %g, want %g", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestSweepRun(t *testing.T) {
+	sweep := &Sweep{
+		Source:     "rtlsdr",
+		Identifier: "id",
+		Command:    "sh",
+		Args: []string{"-c", `echo "2024-03-04, 05:06:07, 100000000, 100020000, 10000, 5, -10, -20"
+echo "malformed"
+echo "2024-03-04, 05:06:08, 100000000, 100010000, 10000, 5, -30"`},
+	}
+	samples := make(chan sdr.Sample, 10)
+	if err := sweep.Run(context.Background(), samples); err != nil {
+		t.Fatalf("Run() failed: %s", err)
+	}
+	close(samples)
+	var dbs []float64
+	for s := range samples {
+		dbs = append(dbs, s.DBAvg)
+	}
+	if want := []float64{-10, -20, -30}; len(dbs) != len(want) || dbs[0] != want[0] || dbs[1] != want[1] || dbs[2] != want[2] {
+		t.Errorf("Run() produced samples with dB %v, want %v", dbs, want)
+	}
+
+	// Lines longer than the scanner's buffer end the sweep with an error.
+	sweep.Args = []string{"-c", "head -c 100000 /dev/zero | tr '\\0' 'x'; echo"}
+	if err := sweep.Run(context.Background(), make(chan sdr.Sample)); err == nil {
+		t.Error("Run() succeeded although the output couldn't be read")
+	}
+
+	sweep.Args = []string{"-c", "exit 3"}
+	if err := sweep.Run(context.Background(), make(chan sdr.Sample)); err == nil {
+		t.Error("Run() succeeded although the command failed")
+	}
+}
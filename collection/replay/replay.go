@@ -0,0 +1,79 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/hb9tf/spectre/export"
+	"github.com/hb9tf/spectre/sdr"
+)
+
+const SourceName = "replay"
+
+// SDR replays samples from a file written by the CSV exporter instead of reading them
+// from hardware. The samples keep their original source, identifier and timestamps.
+type SDR struct {
+	Identifier string
+	// File is the path of the CSV file to replay.
+	File string
+	// RealTime replays the samples with the same delays between them as when they were
+	// recorded instead of as fast as possible.
+	RealTime bool
+}
+
+func (s SDR) Name() string {
+	return SourceName
+}
+
+func (s *SDR) Sweep(ctx context.Context, opts *sdr.Options, samples chan<- sdr.Sample) error {
+	defer close(samples)
+
+	f, err := os.Open(s.File)
+	if err != nil {
+		return fmt.Errorf("unable to open replay file %q: %s", s.File, err)
+	}
+	defer f.Close()
+
+	rawSamples := make(chan sdr.Sample)
+	readErr := make(chan error, 1)
+	// The goroutine ends once the whole file has been read or the context is cancelled.
+	go func() {
+		defer close(rawSamples)
+		count, err := export.ReadCSV(ctx, f, rawSamples)
+		slog.Info("replayed samples", "source", SourceName, "identifier", s.Identifier, "count", count, "file", s.File)
+		readErr <- err
+	}()
+
+	var first time.Time
+	var started time.Time
+	for sample := range rawSamples {
+		if s.RealTime {
+			// Samples are emitted at the end of their integration interval.
+			if first.IsZero() {
+				first = sample.End
+				started = time.Now()
+			}
+			if wait := sample.End.Sub(first) - time.Since(started); wait > 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(wait):
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case samples <- sample:
+		}
+	}
+
+	if err := <-readErr; err != nil && ctx.Err() == nil {
+		return fmt.Errorf("unable to replay %q: %s", s.File, err)
+	}
+	return nil
+}
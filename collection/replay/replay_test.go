@@ -0,0 +1,99 @@
+package replay
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hb9tf/spectre/export"
+	"github.com/hb9tf/spectre/sdr"
+)
+
+// writeCSV stores the samples in a CSV file in a temporary directory and returns its path.
+func writeCSV(t *testing.T, samples ...sdr.Sample) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "spectre.csv")
+	ch := make(chan sdr.Sample, len(samples))
+	for _, s := range samples {
+		ch <- s
+	}
+	close(ch)
+	if err := (&export.CSV{File: file}).Write(context.Background(), ch); err != nil {
+		t.Fatalf("unable to write CSV file: %s", err)
+	}
+	return file
+}
+
+func TestSweep(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+	input := []sdr.Sample{
+		{Source: "rtlsdr", Identifier: "roof", FreqCenter: 150, FreqLow: 100, FreqHigh: 200, DBAvg: -50, SampleCount: 1, Start: start, End: start.Add(50 * time.Millisecond)},
+		{Source: "rtlsdr", Identifier: "roof", FreqCenter: 150, FreqLow: 100, FreqHigh: 200, DBAvg: -40, SampleCount: 1, Start: start.Add(50 * time.Millisecond), End: start.Add(100 * time.Millisecond)},
+	}
+	file := writeCSV(t, input...)
+	tests := []struct {
+		name     string
+		realTime bool
+		minTime  time.Duration
+	}{
+		{name: "as fast as possible"},
+		// The second sample ended 50ms after the first one.
+		{name: "real time", realTime: true, minTime: 50 * time.Millisecond},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &SDR{Identifier: "replay", File: file, RealTime: tc.realTime}
+			samples := make(chan sdr.Sample, len(input))
+			started := time.Now()
+			if err := s.Sweep(context.Background(), &sdr.Options{}, samples); err != nil {
+				t.Fatalf("Sweep() failed: %s", err)
+			}
+			if elapsed := time.Since(started); elapsed < tc.minTime {
+				t.Errorf("Sweep() took %s, want at least %s", elapsed, tc.minTime)
+			}
+			var got []sdr.Sample
+			for sample := range samples {
+				got = append(got, sample)
+			}
+			// Samples keep their original source and identifier.
+			if !reflect.DeepEqual(got, input) {
+				t.Errorf("Sweep() = %+v, want %+v", got, input)
+			}
+		})
+	}
+}
+
+func TestSweepCancelled(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+	file := writeCSV(t,
+		sdr.Sample{Source: "rtlsdr", FreqCenter: 150, Start: start, End: start.Add(time.Second)},
+		sdr.Sample{Source: "rtlsdr", FreqCenter: 150, Start: start.Add(time.Hour), End: start.Add(time.Hour + time.Second)},
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	samples := make(chan sdr.Sample, 2)
+	// Replaying in real time would wait an hour for the second sample.
+	if err := (&SDR{File: file, RealTime: true}).Sweep(ctx, &sdr.Options{}, samples); err != nil {
+		t.Fatalf("Sweep() failed: %s", err)
+	}
+	var count int
+	for range samples {
+		count++
+	}
+	if count != 1 {
+		t.Errorf("Sweep() replayed %d samples before it was cancelled, want 1", count)
+	}
+}
+
+func TestSweepMissingFile(t *testing.T) {
+	samples := make(chan sdr.Sample)
+	s := &SDR{File: filepath.Join(t.TempDir(), "missing.csv")}
+	if err := s.Sweep(context.Background(), &sdr.Options{}, samples); err == nil {
+		t.Error("Sweep() succeeded although the file doesn't exist")
+	}
+	if _, ok := <-samples; ok {
+		t.Error("samples channel hasn't been closed")
+	}
+}
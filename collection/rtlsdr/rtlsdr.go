@@ -1,129 +1,138 @@
 package rtlsdr
 
 import (
-	"bufio"
+	"context"
 	"fmt"
-	"os/exec"
 	"strconv"
-	"strings"
-	"time"
-
-	"github.com/golang/glog"
 
+	"github.com/hb9tf/spectre/collection/powerscan"
 	"github.com/hb9tf/spectre/sdr"
 )
 
 const (
 	SourceName = "rtlsdr"
 	sweepAlias = "rtl_power"
+
+	// maxTunerGain is the highest gain of the common tuners (R820T/R828D: 49.6dB).
+	maxTunerGain = 50
+	// maxPPM is the largest frequency correction accepted, typical dongles are off by less
+	// than 100 ppm.
+	maxPPM = 1000
 )
 
 type SDR struct {
 	Identifier string
+	// Device selects the dongle to use by its index or serial number, the first device
+	// found is used if empty.
+	Device string
 }
 
 func (s SDR) Name() string {
 	return SourceName
 }
 
-func (s *SDR) Sweep(opts *sdr.Options, samples chan<- sdr.Sample) error {
-	args := []string{
-		fmt.Sprintf("-f %d:%d:%d", opts.LowFreq, opts.HighFreq, opts.BinSize),
-		fmt.Sprintf("-i %s", opts.IntegrationInterval),
-		"-", // dumps samples to stdout
-	}
-	cmd := exec.Command(sweepAlias, args...)
-	out, err := cmd.StdoutPipe()
+func (s *SDR) Sweep(ctx context.Context, opts *sdr.Options, samples chan<- sdr.Sample) error {
+	defer close(samples)
+
+	args, err := sweepArgs(opts)
 	if err != nil {
 		return err
 	}
-
-	scanner := bufio.NewScanner(out)
-	// Start() executes command asynchronically.
-	fmt.Printf("Running RTL SDR sweep: %q\n", cmd)
-	if err := cmd.Start(); err != nil {
-		glog.Exitf("unable to start sweep: %s\n", err)
+	if s.Device != "" {
+		// Passed as separate arguments, the serial would otherwise include the leading space.
+		args = append([]string{"-d", s.Device}, args...)
 	}
-	go func() {
-		if err := cmd.Wait(); err != nil {
-			glog.Exitf("sweep command ended with error: %s\n", err)
-		} else {
-			glog.Exit("sweep command ended successfully")
-		}
-	}()
 
-	// Start raw sample processing.
-	for scanner.Scan() {
-		if err := s.scanRow(scanner, samples); err != nil {
-			glog.Warningf("error parsing line: %s\n", err)
-			continue
-		}
+	// rtl_power integrates the samples itself. With interval overrides, it runs with the
+	// shortest interval and the samples of frequencies with longer intervals are combined here.
+	sweepSamples := samples
+	var raw chan sdr.Sample
+	integrated := make(chan struct{})
+	if len(opts.IntervalOverrides) > 0 {
+		raw = make(chan sdr.Sample)
+		go func() {
+			defer close(integrated)
+			integrate(raw, samples, opts)
+		}()
+		sweepSamples = raw
 	}
 
-	return nil
-}
-
-func parseInt(num string) (int64, error) {
-	return strconv.ParseInt(strings.Split(num, ".")[0], 10, 64)
+	sweep := &powerscan.Sweep{
+		Source:     SourceName,
+		Identifier: s.Identifier,
+		Command:    sweepAlias,
+		Args:       args,
+		SkipDCBin:  opts.SkipDCBin,
+	}
+	err = sweep.Run(ctx, sweepSamples)
+	if raw != nil {
+		close(raw)
+		<-integrated
+	}
+	return err
 }
 
-// calculateBinRange calculates the highest and lowest frequencies in a bin
-func calculateBinRange(freqLow, freqHigh, binWidth, binNum int64) (int64, int64) {
-	low := freqLow + (binNum * binWidth)
-	high := low + binWidth
-	if high > freqHigh {
-		high = freqHigh
+// sweepArgs returns the arguments to run rtl_power with after checking that the gain and
+// frequency correction are within the supported ranges.
+func sweepArgs(opts *sdr.Options) ([]string, error) {
+	if opts.TunerGain > maxTunerGain {
+		return nil, fmt.Errorf("tuner gain must be at most %d dB (or negative for automatic gain), got %g", maxTunerGain, opts.TunerGain)
+	}
+	if opts.PPM < -maxPPM || opts.PPM > maxPPM {
+		return nil, fmt.Errorf("frequency correction must be between -%d and %d ppm, got %d", maxPPM, maxPPM, opts.PPM)
 	}
-	return low, high
-}
-func (s *SDR) scanRow(scanner *bufio.Scanner, samples chan<- sdr.Sample) error {
-	glog.V(3).Info(scanner.Text())
-	row := strings.Split(scanner.Text(), ", ")
-	numBins := len(row) - 6
 
-	sampleCount, err := parseInt(row[5])
-	if err != nil {
-		return err
+	args := []string{
+		fmt.Sprintf("-f %d:%d:%d", opts.LowFreq, opts.HighFreq, opts.BinSize),
+		fmt.Sprintf("-i %s", opts.MinInterval()),
 	}
-	freqLow, err := parseInt(row[2])
-	if err != nil {
-		return err
+	if opts.TunerGain >= 0 {
+		args = append(args, fmt.Sprintf("-g %s", strconv.FormatFloat(opts.TunerGain, 'f', -1, 64)))
 	}
-	freqHigh, err := parseInt(row[3])
-	if err != nil {
-		return err
+	if opts.PPM != 0 {
+		args = append(args, fmt.Sprintf("-p %d", opts.PPM))
 	}
-	binWidth, err := parseInt(row[4])
-	if err != nil {
-		return err
+	if opts.BiasTee {
+		// Only supported by recent versions of rtl_power (e.g. from librtlsdr 0.6 or the RTL-SDR Blog drivers).
+		args = append(args, "-T")
 	}
+	return append(args, "-"), nil // dumps samples to stdout
+}
 
-	for i := 0; i < numBins; i++ {
-		low, high := calculateBinRange(freqLow, freqHigh, binWidth, int64(i))
-		binRowIndex := i + 6
-		parsedTime, err := time.Parse(time.RFC3339, row[0]+"T"+row[1]+"Z")
-		if err != nil {
-			return err
+// integrate combines as many consecutive samples per frequency as the multiple of the shortest
+// interval its integration interval corresponds to. Incomplete samples are output at the end.
+func integrate(raw <-chan sdr.Sample, samples chan<- sdr.Sample, opts *sdr.Options) {
+	buckets := map[int64]sdr.Sample{}
+	sweeps := map[int64]int{}
+	for sample := range raw {
+		freq := sample.FreqCenter
+		if stored, ok := buckets[freq]; ok {
+			sample = aggregate(stored, sample)
 		}
-
-		decibels, err := strconv.ParseFloat(row[binRowIndex], 64)
-		if err != nil {
-			return err
+		sweeps[freq]++
+		if sweeps[freq] < opts.IntervalMultiple(freq) {
+			buckets[freq] = sample
+			continue
 		}
+		samples <- sample
+		delete(buckets, freq)
+		delete(sweeps, freq)
+	}
+	for _, sample := range buckets {
+		samples <- sample
+	}
+}
 
-		samples <- sdr.Sample{
-			Identifier:  s.Identifier,
-			Source:      s.Name(),
-			FreqCenter:  (low + high) / 2,
-			FreqLow:     low,
-			FreqHigh:    high,
-			DBLow:       decibels,
-			DBHigh:      decibels,
-			DBAvg:       decibels,
-			SampleCount: sampleCount,
-			Start:       parsedTime,
-			End:         parsedTime,
-		}
+// aggregate combines the stored sample with a later one of the same frequency.
+func aggregate(stored, sample sdr.Sample) sdr.Sample {
+	stored.End = sample.End
+	stored.DBAvg = (stored.DBAvg*float64(stored.SampleCount) + sample.DBAvg*float64(sample.SampleCount)) / float64(stored.SampleCount+sample.SampleCount)
+	if sample.DBLow < stored.DBLow {
+		stored.DBLow = sample.DBLow
+	}
+	if sample.DBHigh > stored.DBHigh {
+		stored.DBHigh = sample.DBHigh
 	}
-	return nil
+	stored.SampleCount += sample.SampleCount
+	return stored
 }
@@ -0,0 +1,94 @@
+package rtlsdr
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+func TestSweepArgs(t *testing.T) {
+	base := sdr.Options{LowFreq: 100, HighFreq: 200, BinSize: 10, IntegrationInterval: 5 * time.Second, TunerGain: -1}
+	tests := []struct {
+		name    string
+		modify  func(*sdr.Options)
+		want    []string
+		wantErr bool
+	}{
+		{name: "automatic gain", modify: func(*sdr.Options) {}, want: []string{"-f 100:200:10", "-i 5s", "-"}},
+		{name: "gain", modify: func(o *sdr.Options) { o.TunerGain = 49.6 }, want: []string{"-f 100:200:10", "-i 5s", "-g 49.6", "-"}},
+		{name: "zero gain", modify: func(o *sdr.Options) { o.TunerGain = 0 }, want: []string{"-f 100:200:10", "-i 5s", "-g 0", "-"}},
+		{name: "frequencies above 2^31 Hz", modify: func(o *sdr.Options) { o.LowFreq, o.HighFreq, o.BinSize = 2400000000, 2500000000, 1000000 }, want: []string{"-f 2400000000:2500000000:1000000", "-i 5s", "-"}},
+		{name: "ppm and bias tee", modify: func(o *sdr.Options) { o.PPM, o.BiasTee = -42, true }, want: []string{"-f 100:200:10", "-i 5s", "-p -42", "-T", "-"}},
+		{
+			name: "shortest interval",
+			modify: func(o *sdr.Options) {
+				o.IntervalOverrides = []sdr.IntervalOverride{{FreqLow: 100, FreqHigh: 150, Interval: time.Second}}
+			},
+			want: []string{"-f 100:200:10", "-i 1s", "-"},
+		},
+		{name: "gain too high", modify: func(o *sdr.Options) { o.TunerGain = 50.1 }, wantErr: true},
+		{name: "ppm too high", modify: func(o *sdr.Options) { o.PPM = 1001 }, wantErr: true},
+		{name: "ppm too low", modify: func(o *sdr.Options) { o.PPM = -1001 }, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := base
+			tc.modify(&opts)
+			got, err := sweepArgs(&opts)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("sweepArgs() error = %v, want error: %t", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("sweepArgs() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIntegrate(t *testing.T) {
+	start := time.Unix(100, 0)
+	opts := &sdr.Options{
+		IntegrationInterval: time.Second,
+		IntervalOverrides:   []sdr.IntervalOverride{{FreqLow: 200, FreqHigh: 300, Interval: 2 * time.Second}},
+	}
+	// Three sweeps of one second with a sample at 100 Hz (no override) and 250 Hz (two sweeps).
+	raw := make(chan sdr.Sample, 6)
+	for i := 0; i < 3; i++ {
+		for _, freq := range []int64{100, 250} {
+			db := float64(-10 * (i + 1))
+			raw <- sdr.Sample{
+				FreqCenter:  freq,
+				DBLow:       db,
+				DBHigh:      db,
+				DBAvg:       db,
+				SampleCount: 1,
+				Start:       start.Add(time.Duration(i) * time.Second),
+				End:         start.Add(time.Duration(i+1) * time.Second),
+			}
+		}
+	}
+	close(raw)
+	samples := make(chan sdr.Sample, 6)
+	integrate(raw, samples, opts)
+	close(samples)
+
+	var got []sdr.Sample
+	for s := range samples {
+		got = append(got, s)
+	}
+	sort.SliceStable(got, func(i, j int) bool { return got[i].FreqCenter < got[j].FreqCenter })
+	want := []sdr.Sample{
+		{FreqCenter: 100, DBLow: -10, DBHigh: -10, DBAvg: -10, SampleCount: 1, Start: start, End: start.Add(time.Second)},
+		{FreqCenter: 100, DBLow: -20, DBHigh: -20, DBAvg: -20, SampleCount: 1, Start: start.Add(time.Second), End: start.Add(2 * time.Second)},
+		{FreqCenter: 100, DBLow: -30, DBHigh: -30, DBAvg: -30, SampleCount: 1, Start: start.Add(2 * time.Second), End: start.Add(3 * time.Second)},
+		{FreqCenter: 250, DBLow: -20, DBHigh: -10, DBAvg: -15, SampleCount: 2, Start: start, End: start.Add(2 * time.Second)},
+		// The incomplete sample of the last sweep is output at the end.
+		{FreqCenter: 250, DBLow: -30, DBHigh: -30, DBAvg: -30, SampleCount: 1, Start: start.Add(2 * time.Second), End: start.Add(3 * time.Second)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("integrate() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,68 @@
+package sdrplay
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hb9tf/spectre/collection/powerscan"
+	"github.com/hb9tf/spectre/sdr"
+)
+
+const (
+	SourceName = "sdrplay"
+	// soapy_power supports SDRplay devices through SoapySDR and outputs samples
+	// in the same format as rtl_power.
+	sweepAlias   = "soapy_power"
+	deviceDriver = "driver=sdrplay"
+
+	minIFGainReduction = 20
+	maxIFGainReduction = 59
+	maxLNAState        = 27
+)
+
+type SDR struct {
+	Identifier string
+}
+
+func (s SDR) Name() string {
+	return SourceName
+}
+
+func (s *SDR) Sweep(ctx context.Context, opts *sdr.Options, samples chan<- sdr.Sample) error {
+	defer close(samples)
+
+	if err := validateGains(opts); err != nil {
+		return err
+	}
+
+	args := []string{
+		fmt.Sprintf("--device=%s", deviceDriver),
+		// SDRplay uses gain reduction, i.e. higher values mean less gain.
+		fmt.Sprintf("--specific-gains=IFGR=%d,RFGR=%d", opts.IFGainReduction, opts.LNAState),
+		fmt.Sprintf("--freq=%d:%d", opts.LowFreq, opts.HighFreq),
+		fmt.Sprintf("--bin-size=%d", opts.BinSize),
+		fmt.Sprintf("--time=%f", opts.IntegrationInterval.Seconds()),
+		"--continue",         // sweep until terminated
+		"--format=rtl_power", // output format compatible with rtl_power, dumped to stdout
+	}
+	sweep := &powerscan.Sweep{
+		Source:     SourceName,
+		Identifier: s.Identifier,
+		Command:    sweepAlias,
+		Args:       args,
+		SkipDCBin:  opts.SkipDCBin,
+	}
+	return sweep.Run(ctx, samples)
+}
+
+// validateGains checks that the gain reductions are within the ranges supported by SDRplay devices.
+// Note that the upper bound of the LNA state depends on the model and frequency band.
+func validateGains(opts *sdr.Options) error {
+	if opts.IFGainReduction < minIFGainReduction || opts.IFGainReduction > maxIFGainReduction {
+		return fmt.Errorf("IF gain reduction must be between %d and %d dB, got %d", minIFGainReduction, maxIFGainReduction, opts.IFGainReduction)
+	}
+	if opts.LNAState < 0 || opts.LNAState > maxLNAState {
+		return fmt.Errorf("LNA state must be between 0 and %d, got %d", maxLNAState, opts.LNAState)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package sdrplay
+
+import (
+	"testing"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+func TestValidateGains(t *testing.T) {
+	tests := []struct {
+		name     string
+		ifGR     int
+		lnaState int
+		wantErr  bool
+	}{
+		{name: "lowest gain reductions", ifGR: 20, lnaState: 0},
+		{name: "highest gain reductions", ifGR: 59, lnaState: 27},
+		{name: "IF gain reduction too low", ifGR: 19, lnaState: 0, wantErr: true},
+		{name: "IF gain reduction too high", ifGR: 60, lnaState: 0, wantErr: true},
+		{name: "negative LNA state", ifGR: 40, lnaState: -1, wantErr: true},
+		{name: "LNA state too high", ifGR: 40, lnaState: 28, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateGains(&sdr.Options{IFGainReduction: tc.ifGR, LNAState: tc.lnaState})
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("validateGains(%d, %d) error = %v, want error: %t", tc.ifGR, tc.lnaState, err, tc.wantErr)
+			}
+		})
+	}
+}
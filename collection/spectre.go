@@ -4,34 +4,93 @@ import (
 	"context"
 	"database/sql"
 	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
 	"os"
+	"os/signal"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
-	"github.com/golang/glog"
 	"github.com/google/uuid"
 
+	"github.com/hb9tf/spectre/aggregate"
+	"github.com/hb9tf/spectre/collection/airspy"
+	"github.com/hb9tf/spectre/collection/fakesdr"
 	"github.com/hb9tf/spectre/collection/hackrf"
+	"github.com/hb9tf/spectre/collection/replay"
 	"github.com/hb9tf/spectre/collection/rtlsdr"
+	"github.com/hb9tf/spectre/collection/sdrplay"
+	"github.com/hb9tf/spectre/collection/split"
+	"github.com/hb9tf/spectre/config"
 	"github.com/hb9tf/spectre/export"
 	"github.com/hb9tf/spectre/filter"
+	"github.com/hb9tf/spectre/logging"
 	"github.com/hb9tf/spectre/sdr"
 
-	// Blind import support for sqlite3 used by sqlite.go.
+	// Blind import support for sqlite3 used by the sqlite storage.
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // Flags
 var (
+	configFile = flag.String("config", "", "Path to a YAML or JSON file with flag values, flags given on the command line take precedence.")
+
+	// Logging
+	logFormat = flag.String("logFormat", logging.FormatText, "Log format (one of: text, json).")
+	logLevel  = flag.String("logLevel", "info", "Minimum level of messages to log (one of: debug, info, warn, error).")
+
 	identifier          = flag.String("identifier", "", "unique identifier of source instance (defaults to a random UUID)")
+	lat                 = flag.Float64("lat", math.NaN(), "Latitude of the collector in decimal degrees stored with each sample (optional, requires -lon)")
+	lon                 = flag.Float64("lon", math.NaN(), "Longitude of the collector in decimal degrees stored with each sample (optional, requires -lat)")
+	tags                = metadataFlag{} // -tag, registered in main
 	lowFreq             = flag.Int64("lowFreq", 400000000, "lower frequency boundary in Hz")
 	highFreq            = flag.Int64("highFreq", 450000000, "upper frequency boundary in Hz")
 	binSize             = flag.Int64("binSize", 12500, "size of the bin in Hz")
 	integrationInterval = flag.Duration("integrationInterval", 5*time.Second, "duration to aggregate samples")
-	sdrType             = flag.String("sdr", "", "SDR to use (one of: hackrf, rtlsdr)")
+	intervalOverrides   = flag.String("intervalOverrides", "", "Comma separated integration intervals for frequency segments as freqLow:freqHigh:interval, e.g. 430000000:440000000:1s (HackRF and RTL-SDR only)")
+	freqDecimation      = flag.Int("freqDecimation", 1, "Number of adjacent frequency bins to merge into one sample before exporting")
+	timeDecimation      = flag.Int("timeDecimation", 1, "Number of consecutive integration intervals to merge into one sample before exporting")
+	skipDC              = flag.Bool("skipDC", false, "Drop the center (DC spike) bin of each sweep segment (RTL-SDR, Airspy and SDRplay only)")
+	sdrType             = flag.String("sdr", "", "SDR to use (one of: airspy, fake, hackrf, replay, rtlsdr, sdrplay)")
+	device              = flag.String("device", "", "Serial number (HackRF) or index or serial number (RTL-SDR) of the device to use, defaults to the first one found (HackRF and RTL-SDR only)")
+	maxDuration         = flag.Duration("maxDuration", 0, "Stop the collection and exit after this duration once all samples are exported, 0 to run until interrupted")
+	progressInterval    = flag.Duration("progressInterval", 5*time.Minute, "Interval to log the progress of the collection (throughput, last swept frequency and number of bins), 0 to disable")
+	splitDevices        = flag.String("splitDevices", "", "Comma separated devices (see -device) to split the frequency range across, each sweeping one sub-band in parallel (HackRF and RTL-SDR only)")
 	discardOutOfRange   = flag.Bool("discardOutOfRange", true, "Discard samples which are outside the specified frequencies")
-	output              = flag.String("output", "", "Export mechanism to use (one of: csv, sqlite, mysql, spectre)")
+	minDB               = flag.Float64("minDB", math.Inf(-1), "Discard samples with an average power below this value in dB")
+	storeFreqLow        = flag.Int64("storeFreqLow", 0, "Only store samples above this frequency in Hz while still sweeping -lowFreq to -highFreq (0 to disable)")
+	storeFreqHigh       = flag.Int64("storeFreqHigh", 0, "Only store samples below this frequency in Hz while still sweeping -lowFreq to -highFreq (0 to disable)")
+	minDBHigh           = flag.Float64("minDBHigh", math.Inf(-1), "Discard samples with a peak power below this value in dB")
+	ampEnable           = flag.Bool("ampEnable", true, "Enable the RX RF amplifier (HackRF only)")
+	lnaGain             = flag.Int("lnaGain", 16, "RX LNA (IF) gain in dB, 0-40dB in 8dB steps (HackRF only)")
+	vgaGain             = flag.Int("vgaGain", 20, "RX VGA (baseband) gain in dB, 0-62dB in 2dB steps (HackRF only)")
+	gain                = flag.Float64("gain", -1, "Tuner gain in dB, negative for automatic gain control (RTL-SDR only)")
+	biasTee             = flag.Bool("biasTee", false, "Power an active antenna or LNA through the antenna input (RTL-SDR only)")
+	ppm                 = flag.Int("ppm", 0, "Frequency correction in parts per million (RTL-SDR only)")
+	ifGainReduction     = flag.Int("ifGainReduction", 40, "IF gain reduction in dB, 20-59dB (SDRplay only)")
+	lnaState            = flag.Int("lnaState", 0, "RF gain reduction step of the LNA, range depends on the model (SDRplay only)")
+	replayFile          = flag.String("replayFile", "", "File path of the CSV file to replay (replay only)")
+	replayRealTime      = flag.Bool("replayRealTime", false, "Replay samples with their original timing instead of as fast as possible (replay only)")
+	output              = flag.String("output", "", "Export mechanism to use (one of: csv, jsonl, sqlite, mysql, spectre, mqtt, parquet, s3)")
+	dryRun              = flag.Bool("dryRun", false, "Print the collected samples to stdout instead of exporting them, -output is ignored")
+
+	// CSV
+	csvFile      = flag.String("csvFile", "", "File path of the CSV file to write (default stdout).")
+	csvFlushRows = flag.Int("csvFlushRows", 1000, "Number of rows after which the CSV output is flushed.")
+
+	// JSONL
+	jsonlFile          = flag.String("jsonlFile", "", "File path of the JSON Lines file to write (default stdout).")
+	jsonlFlushInterval = flag.Duration("jsonlFlushInterval", time.Second, "Maximum duration to buffer samples before writing them.")
+
+	// SQL (sqlite and mysql)
+	sqlBatchSize     = flag.Int("sqlBatchSize", 1000, "Maximum number of samples to insert in one transaction.")
+	sqlFlushInterval = flag.Duration("sqlFlushInterval", 5*time.Second, "Maximum duration to keep samples before inserting them.")
+	sqlUpsert        = flag.Bool("sqlUpsert", false, "Replace samples which are already stored (same identifier, source, center frequency and start) instead of inserting duplicates.")
 
 	// SQLite
 	sqliteFile = flag.String("sqliteFile", "/tmp/spectre", "File path of the sqlite DB file to use.")
@@ -43,98 +102,382 @@ var (
 	mysqlDBName       = flag.String("mysqlDBName", "spectre", "Name of the DB to use.")
 
 	// Spectre Server
-	spectreServer        = flag.String("spectreServer", "http://localhost:8080", "URL scheme, address and port of the spectre server.")
-	spectreServerSamples = flag.Int("spectreServerSamples", 0, "Defines how many samples should be sent to the server at once.")
+	spectreServer           = flag.String("spectreServer", "http://localhost:8080", "URL scheme, address and port of the spectre server.")
+	spectreServerSamples    = flag.Int("spectreServerSamples", 0, "Defines how many samples should be sent to the server at once.")
+	spectreServerBuffer     = flag.Int("spectreServerBuffer", 0, "Maximum number of samples to keep in memory while the server can't be reached (default 100000).")
+	spectreServerBackoff    = flag.Duration("spectreServerBackoff", time.Second, "Duration to wait before retrying after a failed request, doubled with each consecutive failure.")
+	spectreServerMaxBackoff = flag.Duration("spectreServerMaxBackoff", 5*time.Minute, "Maximum duration to wait before retrying after a failed request.")
+	spectreServerCompress   = flag.Bool("spectreServerCompress", false, "Compress samples sent to the server with gzip (requires server support).")
+	spectreServerAPIKeyFile = flag.String("spectreServerAPIKeyFile", "", "Path to the file containing the API key to authenticate with the server.")
+	spectreServerTimeout    = flag.Duration("spectreServerTimeout", 30*time.Second, "Maximum duration of a request to the server.")
+
+	// Parquet
+	parquetFile          = flag.String("parquetFile", "/tmp/spectre.parquet", "File path of the Parquet file to write.")
+	parquetFlushInterval = flag.Duration("parquetFlushInterval", time.Minute, "Maximum duration to buffer samples before writing them as a row group.")
+
+	// S3
+	s3Endpoint      = flag.String("s3Endpoint", "", "URL scheme, address and port of an S3 compatible object storage (e.g. http://localhost:9000 for MinIO), AWS S3 if empty.")
+	s3Region        = flag.String("s3Region", "us-east-1", "Region of the bucket.")
+	s3Bucket        = flag.String("s3Bucket", "", "Name of the bucket to upload the samples to.")
+	s3Prefix        = flag.String("s3Prefix", "", "Prefix of the object keys, objects are named <prefix><identifier>/<start>.jsonl.gz.")
+	s3AccessKey     = flag.String("s3AccessKey", "", "Access key ID to sign the requests with, requests are unsigned if empty.")
+	s3SecretKeyFile = flag.String("s3SecretKeyFile", "", "Path to the file containing the secret access key.")
+	s3MaxSamples    = flag.Int("s3MaxSamples", 100000, "Number of samples after which an object is uploaded.")
+	s3FlushInterval = flag.Duration("s3FlushInterval", 10*time.Minute, "Maximum duration to buffer samples before uploading them.")
+
+	// MQTT
+	mqttBroker       = flag.String("mqttBroker", "tcp://localhost:1883", "URL scheme, address and port of the MQTT broker.")
+	mqttUser         = flag.String("mqttUser", "", "MQTT user.")
+	mqttPasswordFile = flag.String("mqttPasswordFile", "", "Path to the file containing the password for the MQTT user.")
+	mqttTopic        = flag.String("mqttTopic", "spectre", "MQTT topic prefix, samples are published to <prefix>/<identifier>.")
+	mqttQoS          = flag.Uint("mqttQoS", 0, "MQTT quality of service level (0, 1 or 2).")
+	mqttSamples      = flag.Int("mqttSamples", 0, "Defines how many samples should be published in one message.")
 )
 
+// metadataFlag collects the key=value pairs of a repeatable flag.
+type metadataFlag map[string]string
+
+func (m metadataFlag) String() string {
+	var pairs []string
+	for key, value := range m {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m metadataFlag) Set(value string) error {
+	metadata, err := sdr.ParseMetadata(value)
+	if err != nil {
+		return err
+	}
+	for key, value := range metadata {
+		m[key] = value
+	}
+	return nil
+}
+
+// logProgress passes the samples on and logs the throughput, the last swept frequency and
+// the number of distinct bins seen at every interval and once all samples are processed.
+func logProgress(input <-chan sdr.Sample, output chan<- sdr.Sample, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	start := time.Now()
+	last := start
+	var total, sinceLast, lastFreq int64
+	bins := map[int64]bool{}
+	for {
+		select {
+		case s, ok := <-input:
+			if !ok {
+				elapsed := time.Since(start)
+				slog.Info("collection finished", "samples", total, "samplesPerSec", math.Round(float64(total)/elapsed.Seconds()), "bins", len(bins), "elapsed", elapsed.Round(time.Second))
+				return
+			}
+			total++
+			sinceLast++
+			lastFreq = s.FreqCenter
+			bins[s.FreqCenter] = true
+			output <- s
+		case now := <-ticker.C:
+			slog.Info("collection progress", "samples", total, "samplesPerSec", math.Round(float64(sinceLast)/now.Sub(last).Seconds()), "freq", lastFreq, "bins", len(bins), "elapsed", now.Sub(start).Round(time.Second))
+			last = now
+			sinceLast = 0
+		}
+	}
+}
+
+// newSplitSDR returns an SDR splitting the frequency range across the comma separated devices.
+func newSplitSDR(sdrType, devices string) *split.SDR {
+	radio := &split.SDR{}
+	if sdrType == hackrf.SourceName {
+		radio.Align = 1000000 // hackrf_sweep only takes whole MHz
+	}
+	for i, dev := range strings.Split(devices, ",") {
+		dev = strings.TrimSpace(dev)
+		if dev == "" {
+			continue
+		}
+		var devRadio sdr.SDR
+		switch sdrType {
+		case fakesdr.SourceName:
+			// Simulates multiple devices without any hardware.
+			devRadio = &fakesdr.SDR{
+				Identifier: *identifier,
+				Seed:       int64(i),
+			}
+		case hackrf.SourceName:
+			devRadio = &hackrf.SDR{
+				Identifier: *identifier,
+				Serial:     dev,
+			}
+		case rtlsdr.SourceName:
+			devRadio = &rtlsdr.SDR{
+				Identifier: *identifier,
+				Device:     dev,
+			}
+		default:
+			logging.Exit("-splitDevices is only supported for hackrf and rtlsdr", "sdr", sdrType)
+		}
+		radio.Devices = append(radio.Devices, split.Device{Name: dev, Radio: devRadio})
+	}
+	return radio
+}
+
 func main() {
 	ctx := context.Background()
-	// Set defaults for glog flags. Can be overridden via cmdline.
-	flag.Set("logtostderr", "false")
-	flag.Set("stderrthreshold", "WARNING")
-	flag.Set("v", "1")
+	flag.Var(tags, "tag", "Tag stored with each sample as key=value, e.g. antenna=discone. Can be repeated or comma separated.")
 	// Parse flags globally.
 	flag.Parse()
+	if *configFile != "" {
+		if err := config.Load(flag.CommandLine, *configFile, os.Args[1:]); err != nil {
+			logging.Exit("unable to load config", "error", err)
+		}
+	}
+	if err := logging.Setup(*logFormat, *logLevel); err != nil {
+		logging.Exit("unable to set up logging", "error", err)
+	}
 
 	if *identifier == "" {
 		*identifier = uuid.NewString()
 	}
+	if *freqDecimation < 1 || *timeDecimation < 1 {
+		logging.Exit("-freqDecimation and -timeDecimation need to be at least 1", "freqDecimation", *freqDecimation, "timeDecimation", *timeDecimation)
+	}
+	if math.IsNaN(*lat) != math.IsNaN(*lon) {
+		logging.Exit("-lat and -lon need to be set together", "lat", *lat, "lon", *lon)
+	}
+	if *lat < -90 || *lat > 90 || *lon < -180 || *lon > 180 {
+		logging.Exit("-lat needs to be between -90 and 90 and -lon between -180 and 180", "lat", *lat, "lon", *lon)
+	}
+	if *maxDuration < 0 {
+		logging.Exit("-maxDuration can't be negative", "maxDuration", *maxDuration)
+	}
+	if *storeFreqLow < 0 || *storeFreqHigh < 0 || (*storeFreqHigh > 0 && *storeFreqHigh <= *storeFreqLow) {
+		logging.Exit("-storeFreqHigh needs to be above -storeFreqLow", "storeFreqLow", *storeFreqLow, "storeFreqHigh", *storeFreqHigh)
+	}
 
 	// SDR setup
 	var radio sdr.SDR
 	switch strings.ToLower(*sdrType) {
+	case airspy.SourceName:
+		radio = &airspy.SDR{
+			Identifier: *identifier,
+		}
+	case fakesdr.SourceName:
+		radio = &fakesdr.SDR{
+			Identifier: *identifier,
+		}
 	case hackrf.SourceName:
 		radio = &hackrf.SDR{
 			Identifier: *identifier,
+			Serial:     *device,
+		}
+	case replay.SourceName:
+		radio = &replay.SDR{
+			Identifier: *identifier,
+			File:       *replayFile,
+			RealTime:   *replayRealTime,
 		}
 	case rtlsdr.SourceName:
 		radio = &rtlsdr.SDR{
 			Identifier: *identifier,
+			Device:     *device,
+		}
+	case sdrplay.SourceName:
+		radio = &sdrplay.SDR{
+			Identifier: *identifier,
 		}
 	default:
-		glog.Exitf("%q is not a supported SDR type, pick one of: hackrf, rtlsdr", *sdrType)
+		logging.Exit("unsupported SDR type, pick one of: airspy, fake, hackrf, replay, rtlsdr, sdrplay", "sdr", *sdrType)
+	}
+	if *splitDevices != "" {
+		if *device != "" {
+			logging.Exit("-device and -splitDevices can't be used together")
+		}
+		radio = newSplitSDR(strings.ToLower(*sdrType), *splitDevices)
+	}
+	overrides, err := sdr.ParseIntervalOverrides(*intervalOverrides)
+	if err != nil {
+		logging.Exit("unable to parse interval overrides", "error", err)
 	}
 	opts := &sdr.Options{
 		LowFreq:             *lowFreq,
 		HighFreq:            *highFreq,
 		BinSize:             *binSize,
 		IntegrationInterval: *integrationInterval,
+		IntervalOverrides:   overrides,
+		SkipDCBin:           *skipDC,
+		AmpEnable:           *ampEnable,
+		LNAGain:             *lnaGain,
+		VGAGain:             *vgaGain,
+		TunerGain:           *gain,
+		BiasTee:             *biasTee,
+		PPM:                 *ppm,
+		IFGainReduction:     *ifGainReduction,
+		LNAState:            *lnaState,
+	}
+	if err := opts.Validate(); err != nil {
+		logging.Exit("invalid sweep options", "error", err)
 	}
 
 	// Exporter setup
 	var exporter export.Exporter
-	switch strings.ToLower(*output) {
-	case "csv":
-		exporter = &export.CSV{}
-	case "sqlite":
-		db, err := sql.Open("sqlite3", *sqliteFile)
-		if err != nil {
-			glog.Exitf("unable to open sqlite DB %q: %s", *sqliteFile, err)
-		}
-		exporter = &export.SQL{
-			DB: db,
-		}
-	case "mysql":
-		pass, err := os.ReadFile(*mysqlPasswordFile)
-		if err != nil {
-			glog.Exitf("unable to read MySQL password file %q: %s\n", *mysqlPasswordFile, err)
-		}
-		cfg := mysql.Config{
-			User:   *mysqlUser,
-			Passwd: strings.TrimSpace(string(pass)),
-			Net:    "tcp",
-			Addr:   *mysqlServer,
-			DBName: *mysqlDBName,
-		}
-		db, err := sql.Open("mysql", cfg.FormatDSN())
-		if err != nil {
-			glog.Exitf("unable to open MySQL DB %q: %s", *mysqlServer, err)
-		}
-		db.SetConnMaxLifetime(3 * time.Minute)
-		db.SetMaxOpenConns(10)
-		db.SetMaxIdleConns(10)
-		exporter = &export.SQL{
-			DB: db,
-		}
-	case "spectre":
-		exporter = &export.SpectreServer{
-			Server:            *spectreServer,
-			SendSamplesAmount: *spectreServerSamples,
+	if *dryRun {
+		exporter = &export.Print{}
+	} else {
+		switch strings.ToLower(*output) {
+		case "csv":
+			exporter = &export.CSV{
+				File:      *csvFile,
+				FlushRows: *csvFlushRows,
+			}
+		case "jsonl":
+			exporter = &export.JSONL{
+				File:          *jsonlFile,
+				FlushInterval: *jsonlFlushInterval,
+			}
+		case "sqlite":
+			db, err := sql.Open("sqlite3", *sqliteFile)
+			if err != nil {
+				logging.Exit("unable to open sqlite DB", "file", *sqliteFile, "error", err)
+			}
+			exporter = &export.SQL{
+				DB:            db,
+				Dialect:       export.DialectSQLite,
+				BatchSize:     *sqlBatchSize,
+				FlushInterval: *sqlFlushInterval,
+				Upsert:        *sqlUpsert,
+			}
+		case "mysql":
+			pass, err := os.ReadFile(*mysqlPasswordFile)
+			if err != nil {
+				logging.Exit("unable to read MySQL password file", "file", *mysqlPasswordFile, "error", err)
+			}
+			cfg := mysql.Config{
+				User:   *mysqlUser,
+				Passwd: strings.TrimSpace(string(pass)),
+				Net:    "tcp",
+				Addr:   *mysqlServer,
+				DBName: *mysqlDBName,
+			}
+			db, err := sql.Open("mysql", cfg.FormatDSN())
+			if err != nil {
+				logging.Exit("unable to open MySQL DB", "server", *mysqlServer, "error", err)
+			}
+			db.SetConnMaxLifetime(3 * time.Minute)
+			db.SetMaxOpenConns(10)
+			db.SetMaxIdleConns(10)
+			exporter = &export.SQL{
+				DB:            db,
+				Dialect:       export.DialectMySQL,
+				BatchSize:     *sqlBatchSize,
+				FlushInterval: *sqlFlushInterval,
+				Upsert:        *sqlUpsert,
+			}
+		case "spectre":
+			var apiKey []byte
+			if *spectreServerAPIKeyFile != "" {
+				var err error
+				apiKey, err = os.ReadFile(*spectreServerAPIKeyFile)
+				if err != nil {
+					logging.Exit("unable to read API key file", "file", *spectreServerAPIKeyFile, "error", err)
+				}
+			}
+			exporter = &export.SpectreServer{
+				Server:             *spectreServer,
+				SendSamplesAmount:  *spectreServerSamples,
+				MaxBufferedSamples: *spectreServerBuffer,
+				InitialBackoff:     *spectreServerBackoff,
+				MaxBackoff:         *spectreServerMaxBackoff,
+				Compress:           *spectreServerCompress,
+				APIKey:             strings.TrimSpace(string(apiKey)),
+				Timeout:            *spectreServerTimeout,
+			}
+		case "mqtt":
+			var pass []byte
+			if *mqttPasswordFile != "" {
+				var err error
+				pass, err = os.ReadFile(*mqttPasswordFile)
+				if err != nil {
+					logging.Exit("unable to read MQTT password file", "file", *mqttPasswordFile, "error", err)
+				}
+			}
+			exporter = &export.MQTT{
+				Broker:            *mqttBroker,
+				ClientID:          fmt.Sprintf("spectre-%s", *identifier),
+				Username:          *mqttUser,
+				Password:          strings.TrimSpace(string(pass)),
+				Topic:             *mqttTopic,
+				QoS:               byte(*mqttQoS),
+				SendSamplesAmount: *mqttSamples,
+			}
+		case "parquet":
+			exporter = &export.Parquet{
+				File:          *parquetFile,
+				FlushInterval: *parquetFlushInterval,
+			}
+		case "s3":
+			if *s3Bucket == "" {
+				logging.Exit("-s3Bucket is required for the s3 output")
+			}
+			var secretKey []byte
+			if *s3SecretKeyFile != "" {
+				var err error
+				secretKey, err = os.ReadFile(*s3SecretKeyFile)
+				if err != nil {
+					logging.Exit("unable to read S3 secret key file", "file", *s3SecretKeyFile, "error", err)
+				}
+			}
+			exporter = &export.S3{
+				Endpoint:      *s3Endpoint,
+				Region:        *s3Region,
+				Bucket:        *s3Bucket,
+				Prefix:        *s3Prefix,
+				AccessKey:     *s3AccessKey,
+				SecretKey:     strings.TrimSpace(string(secretKey)),
+				MaxSamples:    *s3MaxSamples,
+				FlushInterval: *s3FlushInterval,
+			}
+		default:
+			logging.Exit("unsupported export method, pick one of: csv, jsonl, sqlite, mysql, spectre, mqtt, parquet, s3", "output", *output)
 		}
-	default:
-		glog.Exitf("%q is not a supported export method, pick one of: csv, sqlite, mysql, spectre", *output)
 	}
 
 	// Run
+	// Stop the sweep on SIGINT/SIGTERM. The exporter uses its own context as it
+	// still needs to drain the remaining samples after the sweep stopped.
+	sweepCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *maxDuration > 0 {
+		// Stops the sweep the same way as a signal, so the samples collected so far are
+		// still exported.
+		var cancel context.CancelFunc
+		sweepCtx, cancel = context.WithTimeout(sweepCtx, *maxDuration)
+		defer cancel()
+	}
+
 	samples := make(chan sdr.Sample)
+	sweepErr := make(chan error, 1)
 	go func() {
-		if err := radio.Sweep(opts, samples); err != nil {
-			glog.Fatal(err)
-		}
+		// Sweep closes the samples channel when done which in turn
+		// ends the filtering and export once all samples are processed.
+		sweepErr <- radio.Sweep(sweepCtx, opts, samples)
 	}()
 
+	sweptSamples := samples
+	if *progressInterval > 0 {
+		progressSamples := make(chan sdr.Sample)
+		go func() {
+			defer close(progressSamples)
+			logProgress(samples, progressSamples, *progressInterval)
+		}()
+		sweptSamples = progressSamples
+	}
+
 	filteredSamples := make(chan sdr.Sample)
 	go func() {
+		defer close(filteredSamples)
 		filters := []filter.Filterer{}
 		if *discardOutOfRange {
 			filters = append(filters, &filter.FilterFreq{
@@ -142,14 +485,72 @@ func main() {
 				FreqHigh: *highFreq,
 			})
 		}
-		if err := filter.Filter(samples, filteredSamples, filters); err != nil {
-			glog.Fatal(err)
+		if *storeFreqLow > 0 || *storeFreqHigh > 0 {
+			storeFilter := &filter.FilterFreq{
+				FreqLow:  *storeFreqLow,
+				FreqHigh: *storeFreqHigh,
+			}
+			if *storeFreqHigh == 0 {
+				storeFilter.FreqHigh = math.MaxInt64
+			}
+			filters = append(filters, storeFilter)
+		}
+		if !math.IsInf(*minDB, -1) || !math.IsInf(*minDBHigh, -1) {
+			filters = append(filters, &filter.FilterDB{
+				MinDBAvg:  *minDB,
+				MinDBHigh: *minDBHigh,
+			})
+		}
+		if err := filter.Filter(sweptSamples, filteredSamples, filters); err != nil {
+			logging.Exit("error filtering samples", "error", err)
 		}
 	}()
 
-	if err := exporter.Write(ctx, filteredSamples); err != nil {
-		glog.Fatal(err)
+	exportSamples := filteredSamples
+	if *freqDecimation > 1 || *timeDecimation > 1 {
+		decimatedSamples := make(chan sdr.Sample)
+		go func() {
+			defer close(decimatedSamples)
+			decimator := &aggregate.Decimator{
+				FreqFactor: *freqDecimation,
+				TimeFactor: *timeDecimation,
+				Interval:   *integrationInterval,
+			}
+			if err := decimator.Decimate(filteredSamples, decimatedSamples); err != nil {
+				logging.Exit("error decimating samples", "error", err)
+			}
+		}()
+		exportSamples = decimatedSamples
 	}
 
-	glog.Flush()
+	if !math.IsNaN(*lat) || len(tags) > 0 {
+		taggedSamples := make(chan sdr.Sample)
+		go func(samples <-chan sdr.Sample) {
+			defer close(taggedSamples)
+			for s := range samples {
+				if !math.IsNaN(*lat) {
+					s.Lat, s.Lon = lat, lon
+				}
+				if len(tags) > 0 {
+					if len(s.Metadata) == 0 {
+						s.Metadata = tags
+					} else {
+						// Keep the tags of the sample, e.g. the device of a split sweep.
+						metadata := maps.Clone(tags)
+						maps.Copy(metadata, s.Metadata)
+						s.Metadata = metadata
+					}
+				}
+				taggedSamples <- s
+			}
+		}(exportSamples)
+		exportSamples = taggedSamples
+	}
+
+	if err := exporter.Write(ctx, exportSamples); err != nil {
+		logging.Exit("error exporting samples", "output", *output, "error", err)
+	}
+	if err := <-sweepErr; err != nil {
+		logging.Exit("error sweeping", "source", radio.Name(), "identifier", *identifier, "error", err)
+	}
 }
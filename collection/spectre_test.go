@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hb9tf/spectre/collection/rtlsdr"
+	"github.com/hb9tf/spectre/sdr"
+)
+
+func TestFrequencyFlags(t *testing.T) {
+	// The frequencies don't fit in 32 bits, run with GOARCH=386 to check 32-bit platforms.
+	defer func(low, high int64) { *lowFreq, *highFreq = low, high }(*lowFreq, *highFreq)
+	for name, value := range map[string]string{"lowFreq": "2400000000", "highFreq": "2500000000"} {
+		if err := flag.Set(name, value); err != nil {
+			t.Fatalf("flag.Set(%q, %q) failed: %s", name, value, err)
+		}
+	}
+	if *lowFreq != 2400000000 || *highFreq != 2500000000 {
+		t.Errorf("frequencies are %d - %d Hz, want 2400000000 - 2500000000 Hz", *lowFreq, *highFreq)
+	}
+}
+
+func TestMetadataFlag(t *testing.T) {
+	m := metadataFlag{}
+	for _, value := range []string{"antenna=discone", "feed=roof, antenna=yagi"} {
+		if err := m.Set(value); err != nil {
+			t.Fatalf("Set(%q) failed: %s", value, err)
+		}
+	}
+	if want := (metadataFlag{"antenna": "yagi", "feed": "roof"}); !reflect.DeepEqual(m, want) {
+		t.Errorf("flag = %v, want %v", m, want)
+	}
+	if got, want := m.String(), "antenna=yagi,feed=roof"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if err := m.Set("antenna"); err == nil {
+		t.Error("Set() without a value succeeded, want error")
+	}
+}
+
+func TestLogProgress(t *testing.T) {
+	input := make(chan sdr.Sample, 3)
+	for freq := int64(100); freq <= 300; freq += 100 {
+		input <- sdr.Sample{FreqCenter: freq}
+	}
+	close(input)
+	output := make(chan sdr.Sample, 3)
+	logProgress(input, output, time.Hour)
+	close(output)
+	var got []int64
+	for s := range output {
+		got = append(got, s.FreqCenter)
+	}
+	if want := []int64{100, 200, 300}; !reflect.DeepEqual(got, want) {
+		t.Errorf("logProgress() passed on samples at %v Hz, want %v Hz", got, want)
+	}
+}
+
+func TestNewSplitSDR(t *testing.T) {
+	radio := newSplitSDR(rtlsdr.SourceName, "0, serial1,,")
+	if len(radio.Devices) != 2 {
+		t.Fatalf("newSplitSDR() has %d devices, want 2", len(radio.Devices))
+	}
+	for i, want := range []string{"0", "serial1"} {
+		dev := radio.Devices[i]
+		r, ok := dev.Radio.(*rtlsdr.SDR)
+		if dev.Name != want || !ok || r.Device != want {
+			t.Errorf("device %d is %q (%+v), want rtlsdr device %q", i, dev.Name, dev.Radio, want)
+		}
+	}
+	if radio.Align != 0 {
+		t.Errorf("rtlsdr sub-bands are aligned to %d Hz, want no alignment", radio.Align)
+	}
+	if got := newSplitSDR("hackrf", "a,b").Align; got != 1000000 {
+		t.Errorf("hackrf sub-bands are aligned to %d Hz, want whole MHz", got)
+	}
+}
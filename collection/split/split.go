@@ -0,0 +1,108 @@
+package split
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sync"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+// DeviceTag is the metadata key of the samples holding the name of the device which
+// produced them.
+const DeviceTag = "device"
+
+// Device is one of the radios the frequency range is split across.
+type Device struct {
+	// Name identifies the device in the DeviceTag of its samples, e.g. its serial number.
+	Name  string
+	Radio sdr.SDR
+}
+
+// SDR sweeps the frequency range faster by splitting it into one contiguous sub-band per
+// device, sweeping all of them in parallel and merging their samples.
+type SDR struct {
+	Devices []Device
+	// Align rounds the boundaries between the sub-bands to multiples of it in Hz, e.g. to
+	// whole MHz for radios which can't sweep any other ranges. Boundaries are always rounded
+	// to multiples of the bin size.
+	Align int64
+}
+
+func (s SDR) Name() string {
+	if len(s.Devices) == 0 {
+		return ""
+	}
+	return s.Devices[0].Radio.Name()
+}
+
+// subBands returns the options to sweep with per device, each covering one sub-band.
+func (s *SDR) subBands(opts *sdr.Options) ([]*sdr.Options, error) {
+	if len(s.Devices) == 0 {
+		return nil, errors.New("no devices to split the sweep across")
+	}
+	step := max(opts.BinSize, s.Align, 1)
+	span := opts.HighFreq - opts.LowFreq
+	bands := make([]*sdr.Options, len(s.Devices))
+	low := opts.LowFreq
+	for i := range bands {
+		high := opts.HighFreq
+		if i < len(bands)-1 {
+			boundary := opts.LowFreq + span*int64(i+1)/int64(len(bands))
+			high = (boundary + step/2) / step * step
+		}
+		if high <= low || high > opts.HighFreq {
+			return nil, fmt.Errorf("frequency range %d-%d Hz is too narrow to split across %d devices in steps of %d Hz", opts.LowFreq, opts.HighFreq, len(s.Devices), step)
+		}
+		band := *opts
+		band.LowFreq, band.HighFreq = low, high
+		bands[i] = &band
+		low = high
+	}
+	return bands, nil
+}
+
+func (s *SDR) Sweep(ctx context.Context, opts *sdr.Options, samples chan<- sdr.Sample) error {
+	defer close(samples)
+
+	bands, err := s.subBands(opts)
+	if err != nil {
+		return err
+	}
+
+	// Stop all sweeps as soon as one fails, the others would only cover part of the range.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(s.Devices))
+	for i, dev := range s.Devices {
+		slog.Info("splitting sweep", "device", dev.Name, "freqLow", bands[i].LowFreq, "freqHigh", bands[i].HighFreq)
+		devSamples := make(chan sdr.Sample)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := dev.Radio.Sweep(ctx, bands[i], devSamples); err != nil {
+				errs[i] = fmt.Errorf("device %q: %s", dev.Name, err)
+				cancel()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for sample := range devSamples {
+				metadata := maps.Clone(sample.Metadata)
+				if metadata == nil {
+					metadata = map[string]string{}
+				}
+				metadata[DeviceTag] = dev.Name
+				sample.Metadata = metadata
+				samples <- sample
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
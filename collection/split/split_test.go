@@ -0,0 +1,108 @@
+package split
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+// fakeRadio sends one sample at the low frequency of the sweep, or fails if err is set.
+type fakeRadio struct {
+	err      error
+	metadata map[string]string
+	// block keeps the sweep running until the context is cancelled.
+	block bool
+}
+
+func (f *fakeRadio) Name() string {
+	return "fake"
+}
+
+func (f *fakeRadio) Sweep(ctx context.Context, opts *sdr.Options, samples chan<- sdr.Sample) error {
+	defer close(samples)
+	if f.err != nil {
+		return f.err
+	}
+	samples <- sdr.Sample{FreqLow: opts.LowFreq, FreqHigh: opts.HighFreq, Metadata: f.metadata}
+	if f.block {
+		<-ctx.Done()
+	}
+	return nil
+}
+
+func TestSubBands(t *testing.T) {
+	tests := []struct {
+		name    string
+		devices int
+		align   int64
+		opts    sdr.Options
+		want    [][2]int64
+		wantErr bool
+	}{
+		{name: "single device", devices: 1, opts: sdr.Options{LowFreq: 100, HighFreq: 200, BinSize: 10}, want: [][2]int64{{100, 200}}},
+		{name: "two devices", devices: 2, opts: sdr.Options{LowFreq: 100, HighFreq: 200, BinSize: 10}, want: [][2]int64{{100, 150}, {150, 200}}},
+		{name: "rounded to bin size", devices: 3, opts: sdr.Options{LowFreq: 0, HighFreq: 100, BinSize: 10}, want: [][2]int64{{0, 30}, {30, 70}, {70, 100}}},
+		{name: "aligned", devices: 2, align: 40, opts: sdr.Options{LowFreq: 100, HighFreq: 200, BinSize: 10}, want: [][2]int64{{100, 160}, {160, 200}}},
+		{name: "no devices", opts: sdr.Options{LowFreq: 100, HighFreq: 200, BinSize: 10}, wantErr: true},
+		{name: "too narrow", devices: 2, opts: sdr.Options{LowFreq: 100, HighFreq: 110, BinSize: 10}, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &SDR{Devices: make([]Device, tc.devices), Align: tc.align}
+			bands, err := s.subBands(&tc.opts)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("subBands() error = %v, want error: %t", err, tc.wantErr)
+			}
+			var got [][2]int64
+			for _, band := range bands {
+				if band.BinSize != tc.opts.BinSize {
+					t.Errorf("sub-band has a bin size of %d Hz, want %d Hz", band.BinSize, tc.opts.BinSize)
+				}
+				got = append(got, [2]int64{band.LowFreq, band.HighFreq})
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("subBands() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSweep(t *testing.T) {
+	s := &SDR{Devices: []Device{
+		{Name: "one", Radio: &fakeRadio{}},
+		{Name: "two", Radio: &fakeRadio{metadata: map[string]string{"antenna": "discone"}}},
+	}}
+	samples := make(chan sdr.Sample, 2)
+	if err := s.Sweep(context.Background(), &sdr.Options{LowFreq: 100, HighFreq: 200, BinSize: 10}, samples); err != nil {
+		t.Fatalf("Sweep() failed: %s", err)
+	}
+	var got []sdr.Sample
+	for sample := range samples {
+		got = append(got, sample)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].FreqLow < got[j].FreqLow })
+	want := []sdr.Sample{
+		{FreqLow: 100, FreqHigh: 150, Metadata: map[string]string{DeviceTag: "one"}},
+		{FreqLow: 150, FreqHigh: 200, Metadata: map[string]string{DeviceTag: "two", "antenna": "discone"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Sweep() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSweepStopsOnError(t *testing.T) {
+	// The first device only stops once the failure of the second one cancels its sweep.
+	s := &SDR{Devices: []Device{
+		{Name: "one", Radio: &fakeRadio{block: true}},
+		{Name: "two", Radio: &fakeRadio{err: errors.New("failed")}},
+	}}
+	samples := make(chan sdr.Sample, 1)
+	err := s.Sweep(context.Background(), &sdr.Options{LowFreq: 100, HighFreq: 200, BinSize: 10}, samples)
+	if want := `device "two": failed`; err == nil || err.Error() != want {
+		t.Errorf("Sweep() error = %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Load sets the flags of the flag set to the values in the YAML (or JSON) file at path which
+// maps flag names to values, e.g. "lowFreq: 400000000". Afterwards args are parsed again so
+// flags given on the command line take precedence over the file.
+func Load(fs *flag.FlagSet, path string, args []string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config file %q: %s", path, err)
+	}
+	values := map[string]string{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return fmt.Errorf("unable to parse config file %q: %s", path, err)
+	}
+
+	for name, value := range values {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown flag %q in config file %q", name, path)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for flag %q in config file %q: %s", value, name, path, err)
+		}
+	}
+
+	return fs.Parse(args)
+}
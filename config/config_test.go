@@ -0,0 +1,77 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		args         []string
+		wantLowFreq  int64
+		wantInterval time.Duration
+		wantOutput   string
+		wantErr      bool
+	}{
+		{
+			name:         "YAML",
+			content:      "lowFreq: 400000000\nintegrationInterval: 10s\noutput: sqlite\n",
+			wantLowFreq:  400000000,
+			wantInterval: 10 * time.Second,
+			wantOutput:   "sqlite",
+		},
+		{
+			name:         "JSON",
+			content:      `{"lowFreq": "400000000", "output": "csv"}`,
+			wantLowFreq:  400000000,
+			wantInterval: 5 * time.Second,
+			wantOutput:   "csv",
+		},
+		{
+			name:         "command line takes precedence",
+			content:      "lowFreq: 400000000\noutput: sqlite\n",
+			args:         []string{"-output", "jsonl"},
+			wantLowFreq:  400000000,
+			wantInterval: 5 * time.Second,
+			wantOutput:   "jsonl",
+		},
+		{name: "unknown flag", content: "highFreq: 1", wantErr: true},
+		{name: "invalid value", content: "lowFreq: low", wantErr: true},
+		{name: "invalid YAML", content: "lowFreq: [", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tc.content), 0o644); err != nil {
+				t.Fatalf("unable to write config file: %s", err)
+			}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			lowFreq := fs.Int64("lowFreq", 24000000, "")
+			interval := fs.Duration("integrationInterval", 5*time.Second, "")
+			output := fs.String("output", "csv", "")
+
+			err := Load(fs, path, tc.args)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("Load() error = %v, want error: %t", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if *lowFreq != tc.wantLowFreq || *interval != tc.wantInterval || *output != tc.wantOutput {
+				t.Errorf("Load() set lowFreq=%d, integrationInterval=%s, output=%q, want %d, %s, %q", *lowFreq, *interval, *output, tc.wantLowFreq, tc.wantInterval, tc.wantOutput)
+			}
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	if err := Load(fs, filepath.Join(t.TempDir(), "missing.yaml"), nil); err == nil {
+		t.Error("Load() succeeded for a missing file")
+	}
+}
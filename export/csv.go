@@ -4,32 +4,86 @@ import (
 	"context"
 	"encoding/csv"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
-
-	"github.com/golang/glog"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/hb9tf/spectre/sdr"
 )
 
-type CSV struct{}
+const defaultCSVFlushRows = 1000
+
+// csvHeader is the first row of the CSV file naming the columns.
+var csvHeader = []string{
+	"Source",
+	"Identifier",
+	"FreqCenter",
+	"FreqLow",
+	"FreqHigh",
+	"StartUnixMilli",
+	"EndUnixMilli",
+	"dBLow",
+	"dBHigh",
+	"dbAvg",
+	"SampleCount",
+	"Lat",
+	"Lon",
+	"Metadata",
+}
+
+// csvRequiredColumns is the number of columns of files written before samples had a location
+// and metadata. ReadCSV accepts files with any prefix of csvHeader of at least this length.
+const csvRequiredColumns = 11
+
+type CSV struct {
+	// File is the path of the CSV file to write, defaults to stdout if empty.
+	File string
+	// FlushRows defines after how many rows the output is flushed.
+	FlushRows int
+}
 
 func (c *CSV) Write(ctx context.Context, samples <-chan sdr.Sample) error {
-	w := csv.NewWriter(os.Stdout)
-	w.Write([]string{
-		"Source",
-		"Identifier",
-		"FreqCenter",
-		"FreqLow",
-		"FreqHigh",
-		"StartUnixMilli",
-		"EndUnixMilli",
-		"dBLow",
-		"dBHigh",
-		"dbAvg",
-		"SampleCount",
-	})
-
-	for s := range samples {
+	flushRows := defaultCSVFlushRows
+	if c.FlushRows > 0 {
+		flushRows = c.FlushRows
+	}
+
+	out := os.Stdout
+	if c.File != "" {
+		f, err := os.Create(c.File)
+		if err != nil {
+			return fmt.Errorf("unable to create CSV file %q: %s", c.File, err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w := csv.NewWriter(out)
+	flush := func() error {
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return fmt.Errorf("error flushing CSV: %s", err)
+		}
+		return nil
+	}
+	w.Write(csvHeader)
+
+	for rows := 1; ; rows++ {
+		var s sdr.Sample
+		select {
+		case <-ctx.Done():
+			flush()
+			return ctx.Err()
+		case sample, ok := <-samples:
+			if !ok {
+				return flush()
+			}
+			s = sample
+		}
+
 		if err := w.Write([]string{
 			s.Source,
 			s.Identifier,
@@ -42,14 +96,137 @@ func (c *CSV) Write(ctx context.Context, samples <-chan sdr.Sample) error {
 			fmt.Sprintf("%f", s.DBHigh),
 			fmt.Sprintf("%f", s.DBAvg),
 			fmt.Sprintf("%d", s.SampleCount),
+			csvFloat(s.Lat),
+			csvFloat(s.Lon),
+			encodeMetadata(s.Metadata),
 		}); err != nil {
-			glog.Warningf("error while writing CSV line: %s\n", err)
+			slog.Warn("error while writing CSV line", "error", err)
 		}
 
-		w.Flush()
-		if err := w.Error(); err != nil {
-			glog.Warningf("error flushing CSV: %s\n", err)
+		if rows%flushRows == 0 {
+			if err := flush(); err != nil {
+				slog.Warn("error flushing CSV", "error", err)
+			}
+		}
+	}
+}
+
+// ReadCSV reads samples in the format written by CSV and sends them to the channel in the
+// order they appear in. Malformed rows are logged and skipped. The number of samples sent
+// is returned, the channel is not closed.
+func ReadCSV(ctx context.Context, r io.Reader, samples chan<- sdr.Sample) (int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // Row lengths are checked in parseCSVRow to skip malformed ones.
+	header, err := cr.Read()
+	if err != nil {
+		return 0, fmt.Errorf("unable to read CSV header: %s", err)
+	}
+	if len(header) < csvRequiredColumns || len(header) > len(csvHeader) || strings.Join(header, ",") != strings.Join(csvHeader[:len(header)], ",") {
+		return 0, fmt.Errorf("unexpected CSV header %q, want %q", header, csvHeader)
+	}
+
+	count := 0
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); ok {
+				slog.Warn("skipping malformed CSV line", "error", err)
+				continue
+			}
+			return count, fmt.Errorf("unable to read CSV: %s", err)
 		}
+		line, _ := cr.FieldPos(0)
+		s, err := parseCSVRow(row, len(header))
+		if err != nil {
+			slog.Warn("skipping malformed CSV line", "line", line, "error", err)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return count, ctx.Err()
+		case samples <- s:
+			count++
+		}
+	}
+}
+
+// parseCSVRow is the inverse of the row written by CSV. Rows need to have as many fields
+// as the header.
+func parseCSVRow(row []string, columns int) (sdr.Sample, error) {
+	if len(row) != columns {
+		return sdr.Sample{}, fmt.Errorf("got %d instead of %d fields", len(row), columns)
+	}
+	s := sdr.Sample{
+		Source:     row[0],
+		Identifier: row[1],
+	}
+	var err error
+	if s.FreqCenter, err = strconv.ParseInt(row[2], 10, 64); err != nil {
+		return sdr.Sample{}, fmt.Errorf("invalid FreqCenter: %s", err)
+	}
+	if s.FreqLow, err = strconv.ParseInt(row[3], 10, 64); err != nil {
+		return sdr.Sample{}, fmt.Errorf("invalid FreqLow: %s", err)
+	}
+	if s.FreqHigh, err = strconv.ParseInt(row[4], 10, 64); err != nil {
+		return sdr.Sample{}, fmt.Errorf("invalid FreqHigh: %s", err)
+	}
+	start, err := strconv.ParseInt(row[5], 10, 64)
+	if err != nil {
+		return sdr.Sample{}, fmt.Errorf("invalid StartUnixMilli: %s", err)
+	}
+	s.Start = time.UnixMilli(start)
+	end, err := strconv.ParseInt(row[6], 10, 64)
+	if err != nil {
+		return sdr.Sample{}, fmt.Errorf("invalid EndUnixMilli: %s", err)
+	}
+	s.End = time.UnixMilli(end)
+	if s.DBLow, err = strconv.ParseFloat(row[7], 64); err != nil {
+		return sdr.Sample{}, fmt.Errorf("invalid dBLow: %s", err)
+	}
+	if s.DBHigh, err = strconv.ParseFloat(row[8], 64); err != nil {
+		return sdr.Sample{}, fmt.Errorf("invalid dBHigh: %s", err)
+	}
+	if s.DBAvg, err = strconv.ParseFloat(row[9], 64); err != nil {
+		return sdr.Sample{}, fmt.Errorf("invalid dbAvg: %s", err)
+	}
+	if s.SampleCount, err = strconv.ParseInt(row[10], 10, 64); err != nil {
+		return sdr.Sample{}, fmt.Errorf("invalid SampleCount: %s", err)
+	}
+	if columns > 12 {
+		if s.Lat, err = parseCSVFloat(row[11]); err != nil {
+			return sdr.Sample{}, fmt.Errorf("invalid Lat: %s", err)
+		}
+		if s.Lon, err = parseCSVFloat(row[12]); err != nil {
+			return sdr.Sample{}, fmt.Errorf("invalid Lon: %s", err)
+		}
+	}
+	if columns > 13 {
+		if s.Metadata, err = decodeMetadata(row[13]); err != nil {
+			return sdr.Sample{}, fmt.Errorf("invalid Metadata: %s", err)
+		}
+	}
+	return s, nil
+}
+
+// csvFloat formats an optional value, leaving the field empty if it is nil.
+func csvFloat(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+// parseCSVFloat is the inverse of csvFloat.
+func parseCSVFloat(field string) (*float64, error) {
+	if field == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseFloat(field, 64)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return &v, nil
 }
@@ -0,0 +1,103 @@
+package export
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+// readAllCSV returns the samples read from the CSV content.
+func readAllCSV(t *testing.T, content string) ([]sdr.Sample, error) {
+	t.Helper()
+	samples := make(chan sdr.Sample, 100)
+	_, err := ReadCSV(context.Background(), strings.NewReader(content), samples)
+	close(samples)
+	var got []sdr.Sample
+	for s := range samples {
+		got = append(got, s)
+	}
+	return got, err
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	lat, lon := 47.376888, 8.541694
+	start := time.UnixMilli(1700000000123)
+	input := []sdr.Sample{
+		{
+			Source: "rtlsdr", Identifier: "roof", FreqCenter: 150, FreqLow: 100, FreqHigh: 200,
+			DBLow: -60.5, DBHigh: -40.25, DBAvg: -50, SampleCount: 3,
+			Start: start, End: start.Add(time.Second),
+			Lat: &lat, Lon: &lon, Metadata: map[string]string{"antenna": "discone"},
+		},
+		{Source: "hackrf", Identifier: "id, with comma", FreqCenter: 250, FreqLow: 200, FreqHigh: 300, Start: start, End: start},
+	}
+
+	file := filepath.Join(t.TempDir(), "spectre.csv")
+	samples := make(chan sdr.Sample, len(input))
+	for _, s := range input {
+		samples <- s
+	}
+	close(samples)
+	if err := (&CSV{File: file, FlushRows: 1}).Write(context.Background(), samples); err != nil {
+		t.Fatalf("Write() failed: %s", err)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("unable to read CSV file: %s", err)
+	}
+
+	got, err := readAllCSV(t, string(content))
+	if err != nil {
+		t.Fatalf("ReadCSV() failed: %s", err)
+	}
+	if !reflect.DeepEqual(got, input) {
+		t.Errorf("ReadCSV() = %+v, want %+v", got, input)
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	oldHeader := strings.Join(csvHeader[:csvRequiredColumns], ",")
+	fullHeader := strings.Join(csvHeader, ",")
+	tests := []struct {
+		name      string
+		content   string
+		wantFreqs []int64
+		wantErr   bool
+	}{
+		{
+			name:      "header without location and metadata",
+			content:   oldHeader + "\nrtlsdr,id,150,100,200,0,1000,-60,-40,-50,1\n",
+			wantFreqs: []int64{150},
+		},
+		{
+			name:      "malformed rows are skipped",
+			content:   fullHeader + "\nrtlsdr,id,150,100,200,0,1000,-60,-40,-50,1,,,\nrtlsdr,id,x,100,200,0,1000,-60,-40,-50,1,,,\nrtlsdr,id,250\nrtlsdr,id,350,300,400,0,1000,-60,-40,-50,1,1,2,{}\n",
+			wantFreqs: []int64{150, 350},
+		},
+		{name: "invalid metadata is skipped", content: fullHeader + "\nrtlsdr,id,150,100,200,0,1000,-60,-40,-50,1,,,{\n"},
+		{name: "empty", content: "", wantErr: true},
+		{name: "unexpected header", content: "Source,Identifier\n", wantErr: true},
+		{name: "reordered header", content: strings.Replace(fullHeader, "FreqLow,FreqHigh", "FreqHigh,FreqLow", 1) + "\n", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := readAllCSV(t, tc.content)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("ReadCSV() error = %v, want error: %t", err, tc.wantErr)
+			}
+			var freqs []int64
+			for _, s := range got {
+				freqs = append(freqs, s.FreqCenter)
+			}
+			if !reflect.DeepEqual(freqs, tc.wantFreqs) {
+				t.Errorf("ReadCSV() returned samples at %v Hz, want %v Hz", freqs, tc.wantFreqs)
+			}
+		})
+	}
+}
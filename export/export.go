@@ -2,6 +2,7 @@ package export
 
 import (
 	"context"
+	"encoding/json"
 
 	"github.com/hb9tf/spectre/sdr"
 )
@@ -9,3 +10,26 @@ import (
 type Exporter interface {
 	Write(context.Context, <-chan sdr.Sample) error
 }
+
+// encodeMetadata returns the metadata of a sample as JSON object with sorted keys, or an
+// empty string if there is none. This is how metadata is stored in columnar formats.
+func encodeMetadata(metadata map[string]string) string {
+	if len(metadata) == 0 {
+		return ""
+	}
+	// Marshalling a map of strings can't fail.
+	encoded, _ := json.Marshal(metadata)
+	return string(encoded)
+}
+
+// decodeMetadata is the inverse of encodeMetadata.
+func decodeMetadata(encoded string) (map[string]string, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	var metadata map[string]string
+	if err := json.Unmarshal([]byte(encoded), &metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
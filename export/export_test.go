@@ -0,0 +1,48 @@
+package export
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMetadataEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		want     string
+	}{
+		{name: "nil", metadata: nil, want: ""},
+		{name: "empty", metadata: map[string]string{}, want: ""},
+		{name: "sorted keys", metadata: map[string]string{"feed": "roof", "antenna": "discone"}, want: `{"antenna":"discone","feed":"roof"}`},
+		{name: "escaped", metadata: map[string]string{"note": `say "hi"`}, want: `{"note":"say \"hi\""}`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded := encodeMetadata(tc.metadata)
+			if encoded != tc.want {
+				t.Errorf("encodeMetadata(%v) = %q, want %q", tc.metadata, encoded, tc.want)
+			}
+			decoded, err := decodeMetadata(encoded)
+			if err != nil {
+				t.Fatalf("decodeMetadata(%q) failed: %s", encoded, err)
+			}
+			if len(tc.metadata) == 0 {
+				if decoded != nil {
+					t.Errorf("decodeMetadata(%q) = %v, want nil", encoded, decoded)
+				}
+				return
+			}
+			if !reflect.DeepEqual(decoded, tc.metadata) {
+				t.Errorf("decodeMetadata(%q) = %v, want %v", encoded, decoded, tc.metadata)
+			}
+		})
+	}
+}
+
+func TestDecodeMetadataInvalid(t *testing.T) {
+	for _, encoded := range []string{"{", `{"antenna":1}`, `["antenna"]`} {
+		if _, err := decodeMetadata(encoded); err == nil {
+			t.Errorf("decodeMetadata(%q) succeeded, want error", encoded)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+const defaultJSONLFlushInterval = time.Second
+
+// JSONL writes samples as newline delimited JSON, one sample object per line.
+type JSONL struct {
+	// File is the path of the file to write, defaults to stdout if empty.
+	File string
+	// FlushInterval is the maximum duration samples are buffered before being written.
+	FlushInterval time.Duration
+}
+
+func (j *JSONL) Write(ctx context.Context, samples <-chan sdr.Sample) error {
+	flushInterval := defaultJSONLFlushInterval
+	if j.FlushInterval > 0 {
+		flushInterval = j.FlushInterval
+	}
+
+	out := os.Stdout
+	if j.File != "" {
+		f, err := os.Create(j.File)
+		if err != nil {
+			return fmt.Errorf("unable to create JSONL file %q: %s", j.File, err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w := bufio.NewWriter(out)
+	// Encode terminates each sample with a newline.
+	enc := json.NewEncoder(w)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			w.Flush()
+			return ctx.Err()
+		case <-ticker.C:
+			if err := w.Flush(); err != nil {
+				slog.Warn("error flushing JSONL", "error", err)
+			}
+		case s, ok := <-samples:
+			if !ok {
+				if err := w.Flush(); err != nil {
+					return fmt.Errorf("error flushing JSONL: %s", err)
+				}
+				return nil
+			}
+			if err := enc.Encode(s); err != nil {
+				slog.Warn("error while writing JSONL line", "error", err)
+			}
+		}
+	}
+}
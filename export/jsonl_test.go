@@ -0,0 +1,72 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+func TestJSONLWrite(t *testing.T) {
+	lat := 47.1
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	input := []sdr.Sample{
+		{Source: "rtlsdr", Identifier: "a", FreqCenter: 150, DBAvg: -50, Start: start, End: start.Add(time.Second), Lat: &lat, Metadata: map[string]string{"antenna": "dipole"}},
+		{Source: "hackrf", Identifier: "b", FreqCenter: 250, Start: start, End: start},
+	}
+	tests := []struct {
+		name    string
+		cancel  bool
+		wantErr error
+	}{
+		{name: "channel closed"},
+		{name: "context cancelled", cancel: true, wantErr: context.Canceled},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "spectre.jsonl")
+			samples := make(chan sdr.Sample)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- (&JSONL{File: file, FlushInterval: time.Hour}).Write(ctx, samples) }()
+			for _, s := range input {
+				samples <- s
+			}
+			if tc.cancel {
+				cancel()
+			} else {
+				close(samples)
+			}
+			if err := <-done; !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Write() error = %v, want %v", err, tc.wantErr)
+			}
+
+			// The buffered samples are written in both cases.
+			f, err := os.Open(file)
+			if err != nil {
+				t.Fatalf("unable to open JSONL file: %s", err)
+			}
+			defer f.Close()
+			var got []sdr.Sample
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				var s sdr.Sample
+				if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+					t.Fatalf("invalid JSONL line %q: %s", scanner.Text(), err)
+				}
+				got = append(got, s)
+			}
+			if !reflect.DeepEqual(got, input) {
+				t.Errorf("file contains %+v, want %+v", got, input)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+const (
+	defaultMQTTTopic          = "spectre"
+	defaultMQTTPublishTimeout = 10 * time.Second
+	mqttConnectRetryInterval  = 10 * time.Second
+)
+
+// MQTT publishes samples as JSON to an MQTT broker.
+// Samples are published in batches to the topic "<Topic>/<identifier>".
+type MQTT struct {
+	// Broker is the URL of the MQTT broker, e.g. "tcp://localhost:1883".
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// Topic is the topic prefix to publish to (default "spectre").
+	Topic string
+	// QoS is the MQTT quality of service level (0, 1 or 2).
+	QoS byte
+	// SendSamplesAmount defines how many samples are published in one message.
+	SendSamplesAmount int
+
+	// client is connected to Broker in Write if not set.
+	client mqtt.Client
+}
+
+func (m *MQTT) Write(ctx context.Context, samples <-chan sdr.Sample) error {
+	if m.QoS > 2 {
+		return fmt.Errorf("MQTT QoS needs to be one of 0, 1 or 2, got %d", m.QoS)
+	}
+
+	client := m.client
+	if client == nil {
+		client = m.newClient()
+	}
+	// With ConnectRetry, the client keeps retrying in the background and queues
+	// published messages until the connection is established.
+	client.Connect()
+	defer client.Disconnect(250)
+
+	topic := defaultMQTTTopic
+	if m.Topic != "" {
+		topic = strings.TrimRight(m.Topic, "/")
+	}
+	sendSamplesAmount := defaultSendSampleAmount
+	if m.SendSamplesAmount > 0 {
+		sendSamplesAmount = m.SendSamplesAmount
+	}
+
+	// Samples are batched per identifier as each identifier has its own topic.
+	samplesToSend := map[string][]sdr.Sample{}
+	for {
+		var sample sdr.Sample
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case next, ok := <-samples:
+			if !ok {
+				// Publish remaining samples.
+				for identifier, batch := range samplesToSend {
+					m.publish(client, fmt.Sprintf("%s/%s", topic, identifier), batch)
+				}
+				return nil
+			}
+			sample = next
+		}
+
+		samplesToSend[sample.Identifier] = append(samplesToSend[sample.Identifier], sample)
+		if len(samplesToSend[sample.Identifier]) < sendSamplesAmount {
+			continue // we haven't collected enough samples to send yet
+		}
+		m.publish(client, fmt.Sprintf("%s/%s", topic, sample.Identifier), samplesToSend[sample.Identifier])
+		delete(samplesToSend, sample.Identifier)
+	}
+}
+
+// newClient returns a client for the broker which reconnects automatically.
+func (m *MQTT) newClient() mqtt.Client {
+	opts := mqtt.NewClientOptions().
+		AddBroker(m.Broker).
+		SetClientID(m.ClientID).
+		SetUsername(m.Username).
+		SetPassword(m.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(mqttConnectRetryInterval).
+		SetOnConnectHandler(func(mqtt.Client) {
+			slog.Info("connected to MQTT broker", "broker", m.Broker)
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("lost connection to MQTT broker, reconnecting", "broker", m.Broker, "error", err)
+		})
+	return mqtt.NewClient(opts)
+}
+
+func (m *MQTT) publish(client mqtt.Client, topic string, samples []sdr.Sample) {
+	body, err := json.Marshal(samples)
+	if err != nil {
+		slog.Warn("error marshalling samples to JSON", "error", err)
+		return
+	}
+
+	token := client.Publish(topic, m.QoS, false, body)
+	if !token.WaitTimeout(defaultMQTTPublishTimeout) {
+		slog.Warn("timeout publishing samples to MQTT", "topic", topic, "count", len(samples))
+		return
+	}
+	if err := token.Error(); err != nil {
+		slog.Warn("error publishing samples to MQTT", "topic", topic, "count", len(samples), "error", err)
+		return
+	}
+	slog.Debug("published samples to MQTT", "topic", topic, "count", len(samples))
+}
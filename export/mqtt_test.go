@@ -0,0 +1,117 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+// fakeMQTTClient records the published messages, the other methods of the client aren't used.
+type fakeMQTTClient struct {
+	mqtt.Client
+	connected    bool
+	disconnected bool
+	// messages holds the number of samples of each published message per topic.
+	messages map[string][]int
+}
+
+func (c *fakeMQTTClient) Connect() mqtt.Token {
+	c.connected = true
+	return &fakeMQTTToken{}
+}
+
+func (c *fakeMQTTClient) Disconnect(uint) {
+	c.disconnected = true
+}
+
+func (c *fakeMQTTClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	var samples []sdr.Sample
+	if err := json.Unmarshal(payload.([]byte), &samples); err != nil {
+		return &fakeMQTTToken{err: err}
+	}
+	c.messages[topic] = append(c.messages[topic], len(samples))
+	return &fakeMQTTToken{}
+}
+
+// fakeMQTTToken is completed when created.
+type fakeMQTTToken struct {
+	err error
+}
+
+func (t *fakeMQTTToken) Wait() bool                     { return true }
+func (t *fakeMQTTToken) WaitTimeout(time.Duration) bool { return true }
+func (t *fakeMQTTToken) Error() error                   { return t.err }
+
+func (t *fakeMQTTToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+func TestMQTTWrite(t *testing.T) {
+	tests := []struct {
+		name         string
+		topic        string
+		qos          byte
+		identifiers  []string
+		wantMessages map[string][]int
+		wantErr      bool
+	}{
+		{
+			name:         "batches per identifier",
+			identifiers:  []string{"a", "a", "b", "a", "a", "a"},
+			wantMessages: map[string][]int{"spectre/a": {2, 2, 1}, "spectre/b": {1}},
+		},
+		{
+			name:         "topic prefix",
+			topic:        "sdr/",
+			qos:          2,
+			identifiers:  []string{"a"},
+			wantMessages: map[string][]int{"sdr/a": {1}},
+		},
+		{name: "invalid QoS", qos: 3, identifiers: []string{"a"}, wantMessages: map[string][]int{}, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &fakeMQTTClient{messages: map[string][]int{}}
+			exporter := &MQTT{Topic: tc.topic, QoS: tc.qos, SendSamplesAmount: 2, client: client}
+			samples := make(chan sdr.Sample, len(tc.identifiers))
+			for _, id := range tc.identifiers {
+				samples <- sdr.Sample{Identifier: id}
+			}
+			close(samples)
+
+			err := exporter.Write(context.Background(), samples)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("Write() error = %v, want error: %t", err, tc.wantErr)
+			}
+			if !tc.wantErr && (!client.connected || !client.disconnected) {
+				t.Errorf("client connected: %t, disconnected: %t, want both", client.connected, client.disconnected)
+			}
+			if len(client.messages) != len(tc.wantMessages) {
+				t.Fatalf("published to topics %v, want %v", client.messages, tc.wantMessages)
+			}
+			for topic, want := range tc.wantMessages {
+				got := client.messages[topic]
+				// The remaining batches are published in random order.
+				sort.Sort(sort.Reverse(sort.IntSlice(got)))
+				if len(got) != len(want) {
+					t.Errorf("published %v samples to %q, want %v", got, topic, want)
+					continue
+				}
+				for i := range got {
+					if got[i] != want[i] {
+						t.Errorf("published %v samples to %q, want %v", got, topic, want)
+						break
+					}
+				}
+			}
+		})
+	}
+}
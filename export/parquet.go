@@ -0,0 +1,117 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+const (
+	defaultParquetFlushInterval = time.Minute
+	parquetParallelism          = 4
+)
+
+// parquetSample is the schema of the rows written to the Parquet file. Metadata is a JSON
+// object, empty if the sample has none.
+type parquetSample struct {
+	Identifier  string   `parquet:"name=identifier, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Source      string   `parquet:"name=source, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	FreqCenter  int64    `parquet:"name=freq_center, type=INT64"`
+	FreqLow     int64    `parquet:"name=freq_low, type=INT64"`
+	FreqHigh    int64    `parquet:"name=freq_high, type=INT64"`
+	DBHigh      float64  `parquet:"name=db_high, type=DOUBLE"`
+	DBLow       float64  `parquet:"name=db_low, type=DOUBLE"`
+	DBAvg       float64  `parquet:"name=db_avg, type=DOUBLE"`
+	SampleCount int64    `parquet:"name=sample_count, type=INT64"`
+	Start       int64    `parquet:"name=start, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	End         int64    `parquet:"name=end, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Lat         *float64 `parquet:"name=lat, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Lon         *float64 `parquet:"name=lon, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Metadata    string   `parquet:"name=metadata, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+}
+
+// Parquet writes samples to a Parquet file. The file is only complete (readable) once
+// the samples channel has been closed or the context cancelled and the footer has been
+// written.
+type Parquet struct {
+	// File is the path of the Parquet file to create.
+	File string
+	// FlushInterval is the maximum duration samples are buffered before being written
+	// as a row group.
+	FlushInterval time.Duration
+}
+
+func (p *Parquet) Write(ctx context.Context, samples <-chan sdr.Sample) error {
+	flushInterval := defaultParquetFlushInterval
+	if p.FlushInterval > 0 {
+		flushInterval = p.FlushInterval
+	}
+
+	f, err := os.Create(p.File)
+	if err != nil {
+		return fmt.Errorf("unable to create Parquet file %q: %s", p.File, err)
+	}
+
+	pw, err := writer.NewParquetWriterFromWriter(f, new(parquetSample), parquetParallelism)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("unable to create Parquet writer: %s", err)
+	}
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Write the footer so the samples written so far remain readable.
+			if err := p.finish(pw, f); err != nil {
+				return err
+			}
+			return ctx.Err()
+		case <-ticker.C:
+			if err := pw.Flush(true); err != nil {
+				slog.Warn("error flushing Parquet row group", "error", err)
+			}
+		case s, ok := <-samples:
+			if !ok {
+				return p.finish(pw, f)
+			}
+			if err := pw.Write(parquetSample{
+				Identifier:  s.Identifier,
+				Source:      s.Source,
+				FreqCenter:  s.FreqCenter,
+				FreqLow:     s.FreqLow,
+				FreqHigh:    s.FreqHigh,
+				DBHigh:      s.DBHigh,
+				DBLow:       s.DBLow,
+				DBAvg:       s.DBAvg,
+				SampleCount: s.SampleCount,
+				Start:       s.Start.UnixMilli(),
+				End:         s.End.UnixMilli(),
+				Lat:         s.Lat,
+				Lon:         s.Lon,
+				Metadata:    encodeMetadata(s.Metadata),
+			}); err != nil {
+				slog.Warn("error writing sample to Parquet file", "source", s.Source, "identifier", s.Identifier, "error", err)
+			}
+		}
+	}
+}
+
+// finish writes the buffered rows and the footer and closes the file.
+func (p *Parquet) finish(pw *writer.ParquetWriter, f *os.File) error {
+	err := pw.WriteStop()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to finish Parquet file %q: %s", p.File, err)
+	}
+	return nil
+}
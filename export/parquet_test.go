@@ -0,0 +1,84 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+// readParquet returns all rows of the Parquet file.
+func readParquet(t *testing.T, file string) []parquetSample {
+	t.Helper()
+	fr, err := local.NewLocalFileReader(file)
+	if err != nil {
+		t.Fatalf("unable to open Parquet file: %s", err)
+	}
+	defer fr.Close()
+	pr, err := reader.NewParquetReader(fr, new(parquetSample), 1)
+	if err != nil {
+		t.Fatalf("unable to read Parquet file: %s", err)
+	}
+	defer pr.ReadStop()
+	rows := make([]parquetSample, pr.GetNumRows())
+	if err := pr.Read(&rows); err != nil {
+		t.Fatalf("unable to read Parquet rows: %s", err)
+	}
+	return rows
+}
+
+func TestParquetWrite(t *testing.T) {
+	lat := 47.1
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	input := []sdr.Sample{
+		{Identifier: "a", Source: "rtlsdr", FreqCenter: 100, DBAvg: -40, SampleCount: 2, Start: start, End: start.Add(time.Second), Lat: &lat, Metadata: map[string]string{"antenna": "dipole"}},
+		{Identifier: "b", Source: "hackrf", FreqCenter: 200, DBAvg: -50, Start: start, End: start},
+	}
+	tests := []struct {
+		name    string
+		cancel  bool
+		wantErr error
+	}{
+		{name: "channel closed"},
+		{name: "context cancelled", cancel: true, wantErr: context.Canceled},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "spectre.parquet")
+			exporter := &Parquet{File: file, FlushInterval: time.Hour}
+			samples := make(chan sdr.Sample)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- exporter.Write(ctx, samples) }()
+			for _, s := range input {
+				samples <- s
+			}
+			if tc.cancel {
+				cancel()
+			} else {
+				close(samples)
+			}
+			if err := <-done; !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Write() error = %v, want %v", err, tc.wantErr)
+			}
+
+			rows := readParquet(t, file)
+			if len(rows) != len(input) {
+				t.Fatalf("file has %d rows, want %d", len(rows), len(input))
+			}
+			if got := rows[0]; got.Identifier != "a" || got.DBAvg != -40 || got.Start != start.UnixMilli() || got.Lat == nil || *got.Lat != lat || got.Metadata != `{"antenna":"dipole"}` {
+				t.Errorf("first row = %+v, want the first sample", got)
+			}
+			if got := rows[1]; got.Identifier != "b" || got.Lat != nil || got.Metadata != "" {
+				t.Errorf("second row = %+v, want the second sample", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+// Print writes a human readable line per sample followed by a summary. It is meant for
+// verifying the collection without storing anything, e.g. when bringing up new hardware.
+type Print struct {
+	// Out is where the samples are written to, defaults to stdout if nil.
+	Out io.Writer
+}
+
+func (p *Print) Write(ctx context.Context, samples <-chan sdr.Sample) error {
+	out := p.Out
+	if out == nil {
+		out = os.Stdout
+	}
+
+	var count int
+	var lowFreq, highFreq int64
+	var minDB, maxDB float64
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case s, ok := <-samples:
+			if !ok {
+				if count == 0 {
+					fmt.Fprintln(out, "No samples received.")
+					return nil
+				}
+				fmt.Fprintf(out, "%d samples received, %d - %d Hz, %.2f - %.2f dB\n", count, lowFreq, highFreq, minDB, maxDB)
+				return nil
+			}
+			if count == 0 {
+				lowFreq, highFreq, minDB, maxDB = s.FreqLow, s.FreqHigh, s.DBLow, s.DBHigh
+			}
+			count++
+			lowFreq = min(lowFreq, s.FreqLow)
+			highFreq = max(highFreq, s.FreqHigh)
+			minDB = min(minDB, s.DBLow)
+			maxDB = max(maxDB, s.DBHigh)
+			fmt.Fprintf(out, "%s %s (%s): %d - %d Hz (center %d Hz), avg %.2f dB, low %.2f dB, high %.2f dB, %d samples over %s\n",
+				s.Start.Format(time.RFC3339), s.Source, s.Identifier, s.FreqLow, s.FreqHigh, s.FreqCenter,
+				s.DBAvg, s.DBLow, s.DBHigh, s.SampleCount, s.End.Sub(s.Start))
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+func TestPrintWrite(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		samples     []sdr.Sample
+		wantLines   int
+		wantSummary string
+	}{
+		{name: "no samples", wantLines: 1, wantSummary: "No samples received."},
+		{
+			name: "samples",
+			samples: []sdr.Sample{
+				{Source: "rtlsdr", Identifier: "id", FreqLow: 200, FreqHigh: 300, DBLow: -50, DBHigh: -40, Start: start, End: start.Add(time.Second)},
+				{Source: "rtlsdr", Identifier: "id", FreqLow: 100, FreqHigh: 200, DBLow: -70, DBHigh: -20, Start: start, End: start.Add(time.Second)},
+			},
+			wantLines:   3,
+			wantSummary: "2 samples received, 100 - 300 Hz, -70.00 - -20.00 dB",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			samples := make(chan sdr.Sample, len(tc.samples))
+			for _, s := range tc.samples {
+				samples <- s
+			}
+			close(samples)
+			var out bytes.Buffer
+			if err := (&Print{Out: &out}).Write(context.Background(), samples); err != nil {
+				t.Fatalf("Write() failed: %s", err)
+			}
+			lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			if len(lines) != tc.wantLines {
+				t.Fatalf("Write() printed %d lines, want %d:\n%s", len(lines), tc.wantLines, out.String())
+			}
+			if got := lines[len(lines)-1]; got != tc.wantSummary {
+				t.Errorf("summary is %q, want %q", got, tc.wantSummary)
+			}
+			if len(tc.samples) > 0 && !strings.HasPrefix(lines[0], "2024-01-01T12:00:00Z rtlsdr (id): 200 - 300 Hz") {
+				t.Errorf("first line is %q, want the first sample", lines[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package export
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DeleteFilter selects the samples to delete. Empty fields don't restrict the selection.
+type DeleteFilter struct {
+	// Source only selects samples of this source (SDR type).
+	Source string
+	// Identifier only selects samples of this source instance.
+	Identifier string
+	// Before only selects samples which ended before this time.
+	Before time.Time
+}
+
+// DeleteSamples deletes the samples matching the filter, including their aggregates in the
+// rollup, and returns how many samples were deleted. All samples are deleted if the filter is empty.
+func DeleteSamples(db *sql.DB, filter DeleteFilter) (int64, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.Source != "" {
+		conditions = append(conditions, "Source = ?")
+		args = append(args, filter.Source)
+	}
+	if filter.Identifier != "" {
+		conditions = append(conditions, "Identifier = ?")
+		args = append(args, filter.Identifier)
+	}
+	if !filter.Before.IsZero() {
+		conditions = append(conditions, "End < ?")
+		args = append(args, filter.Before.UnixMilli())
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	result, err := db.Exec("DELETE FROM spectre"+where, args...)
+	if err != nil {
+		return 0, fmt.Errorf("unable to delete samples: %s", err)
+	}
+	// Keep the rollup in sync, it only exists if it has been enabled (see UpdateRollup).
+	if _, err := db.Exec("DELETE FROM spectre_rollup"+where, args...); err != nil && rollupExists(db) {
+		return 0, fmt.Errorf("unable to delete aggregated samples from the rollup: %s", err)
+	}
+	return result.RowsAffected()
+}
+
+// rollupExists returns whether the spectre_rollup table exists.
+func rollupExists(db *sql.DB) bool {
+	var count int64
+	return db.QueryRow("SELECT COUNT(*) FROM spectre_rollup WHERE 1 = 0").Scan(&count) == nil
+}
+
+// Vacuum reclaims the disk space of deleted samples. This is only needed for sqlite which
+// doesn't shrink the DB file on its own, it is a no-op for other dialects.
+func Vacuum(db *sql.DB, dialect string) error {
+	if dialect != "" && dialect != DialectSQLite {
+		return nil
+	}
+	if _, err := db.Exec("VACUUM;"); err != nil {
+		return fmt.Errorf("unable to vacuum DB: %s", err)
+	}
+	return nil
+}
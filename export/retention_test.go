@@ -0,0 +1,68 @@
+package export
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+func TestDeleteSamples(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	samples := []sdr.Sample{
+		{Source: "rtlsdr", Identifier: "a", FreqCenter: 100, Start: start, End: start.Add(time.Second)},
+		{Source: "rtlsdr", Identifier: "b", FreqCenter: 100, Start: start, End: start.Add(time.Second)},
+		{Source: "hackrf", Identifier: "a", FreqCenter: 100, Start: start.Add(time.Hour), End: start.Add(time.Hour + time.Second)},
+	}
+	tests := []struct {
+		name        string
+		filter      DeleteFilter
+		withRollup  bool
+		wantDeleted int64
+	}{
+		{name: "all", filter: DeleteFilter{}, wantDeleted: 3},
+		{name: "source", filter: DeleteFilter{Source: "rtlsdr"}, wantDeleted: 2},
+		{name: "identifier", filter: DeleteFilter{Identifier: "a"}, wantDeleted: 2},
+		{name: "source and identifier", filter: DeleteFilter{Source: "hackrf", Identifier: "b"}, wantDeleted: 0},
+		{name: "before", filter: DeleteFilter{Before: start.Add(time.Minute)}, wantDeleted: 2},
+		{name: "before with rollup", filter: DeleteFilter{Before: start.Add(time.Minute)}, withRollup: true, wantDeleted: 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newTestDB(t)
+			insertTestSamples(t, db, samples...)
+			if tc.withRollup {
+				if _, err := UpdateRollup(db, DialectSQLite, start.Add(2*time.Hour)); err != nil {
+					t.Fatalf("UpdateRollup() failed: %s", err)
+				}
+			}
+
+			deleted, err := DeleteSamples(db, tc.filter)
+			if err != nil {
+				t.Fatalf("DeleteSamples() failed: %s", err)
+			}
+			if deleted != tc.wantDeleted {
+				t.Errorf("DeleteSamples() deleted %d samples, want %d", deleted, tc.wantDeleted)
+			}
+			var remaining int64
+			if err := db.QueryRow("SELECT COUNT(*) FROM spectre").Scan(&remaining); err != nil {
+				t.Fatalf("unable to count samples: %s", err)
+			}
+			if want := int64(len(samples)) - tc.wantDeleted; remaining != want {
+				t.Errorf("%d samples remain, want %d", remaining, want)
+			}
+			if tc.withRollup {
+				var rollup int64
+				if err := db.QueryRow("SELECT COUNT(*) FROM spectre_rollup").Scan(&rollup); err != nil {
+					t.Fatalf("unable to count aggregated samples: %s", err)
+				}
+				if rollup != 1 {
+					t.Errorf("%d aggregated samples remain, want 1", rollup)
+				}
+			}
+			if err := Vacuum(db, DialectSQLite); err != nil {
+				t.Errorf("Vacuum() failed: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package export
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	// RollupResolution is the duration of the time buckets samples are aggregated into in the
+	// spectre_rollup table.
+	RollupResolution = time.Minute
+	// rollupChunk limits how much data is aggregated in one statement, e.g. when the rollup
+	// is created for an existing DB.
+	rollupChunk = 24 * time.Hour
+
+	// The rollup table has the same columns as the spectre table, every row aggregates the
+	// samples of one frequency during one RollupResolution. Start and End are the start of
+	// the first and the end of the last aggregated sample, DBAvg is the average of the sample
+	// averages weighted by their SampleCount.
+	sqliteCreateRollupTableTmpl = `CREATE TABLE IF NOT EXISTS spectre_rollup (
+		"Identifier"   TEXT NOT NULL,
+		"Source"       TEXT NOT NULL,
+		"FreqCenter"   INTEGER,
+		"FreqLow"      INTEGER,
+		"FreqHigh"     INTEGER,
+		"DBHigh"       REAL,
+		"DBLow"        REAL,
+		"DBAvg"        REAL,
+		"SampleCount"  INTEGER,
+		"Start"        INTEGER,
+		"End"          INTEGER
+	);`
+	sqlCreateRollupSourceIndexTmpl     = `CREATE INDEX IF NOT EXISTS spectre_rollup_source_start ON spectre_rollup (Source, Start);`
+	sqlCreateRollupFreqCenterIndexTmpl = `CREATE INDEX IF NOT EXISTS spectre_rollup_freqcenter ON spectre_rollup (FreqCenter);`
+	mysqlCreateRollupTableTmpl         = `CREATE TABLE IF NOT EXISTS spectre_rollup (
+		Identifier   VARCHAR(255) NOT NULL,
+		Source       VARCHAR(255) NOT NULL,
+		FreqCenter   BIGINT,
+		FreqLow      BIGINT,
+		FreqHigh     BIGINT,
+		DBHigh       DOUBLE,
+		DBLow        DOUBLE,
+		DBAvg        DOUBLE,
+		SampleCount  BIGINT,
+		Start        BIGINT,
+		End          BIGINT,
+		INDEX spectre_rollup_source_start (Source, Start),
+		INDEX spectre_rollup_freqcenter (FreqCenter)
+	);`
+	getRollupWatermarkTmpl = `SELECT
+		MAX(Start)
+	FROM
+		spectre_rollup;`
+	getFirstSampleStartTmpl = `SELECT
+		MIN(Start)
+	FROM
+		spectre;`
+	// insertRollupTmpl needs to be formatted with RollupResolution in milliseconds.
+	insertRollupTmpl = `INSERT INTO spectre_rollup (
+		Identifier,
+		Source,
+		FreqCenter,
+		FreqLow,
+		FreqHigh,
+		DBHigh,
+		DBLow,
+		DBAvg,
+		SampleCount,
+		Start,
+		End
+	) SELECT
+		Identifier,
+		Source,
+		FreqCenter,
+		MIN(FreqLow),
+		MAX(FreqHigh),
+		MAX(DBHigh),
+		MIN(DBLow),
+		SUM(DBAvg * SampleCount) / SUM(SampleCount),
+		SUM(SampleCount),
+		MIN(Start),
+		MAX(End)
+	FROM
+		spectre
+	WHERE
+		Start >= ?
+		AND Start < ?
+	GROUP BY
+		Identifier,
+		Source,
+		FreqCenter,
+		Start - (Start %% %d);`
+)
+
+// sqlCreateRollupTmpls are the statements to create the rollup table and indexes per dialect.
+var sqlCreateRollupTmpls = map[string][]string{
+	DialectSQLite: {sqliteCreateRollupTableTmpl, sqlCreateRollupSourceIndexTmpl, sqlCreateRollupFreqCenterIndexTmpl},
+	DialectMySQL:  {mysqlCreateRollupTableTmpl},
+}
+
+// UpdateRollup aggregates the samples of all RollupResolution buckets which ended before
+// until and haven't been aggregated yet into the spectre_rollup table, creating it if needed.
+// Samples stored after their bucket has been aggregated are not added to the rollup, so until
+// should leave enough time for delayed samples to arrive. It returns the number of rows added.
+//
+// The rollup is continued from its last bucket without any locking, so only one process may
+// update the rollup of a DB. Concurrent updates aggregate the same buckets more than once.
+func UpdateRollup(db *sql.DB, dialect string, until time.Time) (int64, error) {
+	if dialect == "" {
+		dialect = DialectSQLite
+	}
+	tmpls, ok := sqlCreateRollupTmpls[dialect]
+	if !ok {
+		return 0, fmt.Errorf("unsupported SQL dialect %q", dialect)
+	}
+	for _, tmpl := range tmpls {
+		if _, err := db.Exec(tmpl); err != nil {
+			return 0, fmt.Errorf("unable to create rollup table: %s", err)
+		}
+	}
+
+	resolution := RollupResolution.Milliseconds()
+	var watermark sql.NullInt64
+	if err := db.QueryRow(getRollupWatermarkTmpl).Scan(&watermark); err != nil {
+		return 0, fmt.Errorf("unable to get the last rollup bucket: %s", err)
+	}
+	var from int64
+	if watermark.Valid {
+		from = watermark.Int64 - watermark.Int64%resolution + resolution
+	} else {
+		var first sql.NullInt64
+		if err := db.QueryRow(getFirstSampleStartTmpl).Scan(&first); err != nil {
+			return 0, fmt.Errorf("unable to get the first sample: %s", err)
+		}
+		if !first.Valid {
+			return 0, nil // there are no samples yet
+		}
+		from = first.Int64 - first.Int64%resolution
+	}
+	to := until.UnixMilli() - until.UnixMilli()%resolution
+
+	var inserted int64
+	query := fmt.Sprintf(insertRollupTmpl, resolution)
+	for start := from; start < to; start += rollupChunk.Milliseconds() {
+		end := min(start+rollupChunk.Milliseconds(), to)
+		result, err := db.Exec(query, start, end)
+		if err != nil {
+			return inserted, fmt.Errorf("unable to aggregate samples from %s to %s: %s", time.UnixMilli(start).UTC(), time.UnixMilli(end).UTC(), err)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return inserted, err
+		}
+		inserted += rows
+	}
+	return inserted, nil
+}
@@ -0,0 +1,114 @@
+package export
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+// newTestDB returns an empty sqlite DB with the spectre table.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "spectre.db"))
+	if err != nil {
+		t.Fatalf("unable to open DB: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := sqlCreateTableIfNotExists(db, DialectSQLite); err != nil {
+		t.Fatalf("unable to create table: %s", err)
+	}
+	return db
+}
+
+// insertTestSamples stores the samples in the DB.
+func insertTestSamples(t *testing.T, db *sql.DB, samples ...sdr.Sample) {
+	t.Helper()
+	statement, err := db.Prepare(sqlInsertSampleTmpl)
+	if err != nil {
+		t.Fatalf("unable to prepare insert: %s", err)
+	}
+	defer statement.Close()
+	for _, sample := range samples {
+		if err := sqlInsertSample(statement, sample); err != nil {
+			t.Fatalf("unable to insert sample: %s", err)
+		}
+	}
+}
+
+func TestUpdateRollup(t *testing.T) {
+	db := newTestDB(t)
+	minute := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	sample := func(freq int64, offset time.Duration, db float64, count int64) sdr.Sample {
+		return sdr.Sample{
+			Identifier:  "id",
+			Source:      "rtlsdr",
+			FreqCenter:  freq,
+			FreqLow:     freq - 5,
+			FreqHigh:    freq + 5,
+			DBLow:       db,
+			DBHigh:      db,
+			DBAvg:       db,
+			SampleCount: count,
+			Start:       minute.Add(offset),
+			End:         minute.Add(offset + time.Second),
+		}
+	}
+	insertTestSamples(t, db,
+		// Same frequency and minute, the average is weighted by the sample counts.
+		sample(100, 0, -10, 1),
+		sample(100, 10*time.Second, -50, 3),
+		// Another frequency.
+		sample(200, 20*time.Second, -20, 2),
+		// The next minute.
+		sample(100, time.Minute+5*time.Second, -30, 1),
+		// Not finished yet.
+		sample(100, 2*time.Minute+5*time.Second, -40, 1),
+	)
+
+	rows, err := UpdateRollup(db, DialectSQLite, minute.Add(2*time.Minute+30*time.Second))
+	if err != nil {
+		t.Fatalf("UpdateRollup() failed: %s", err)
+	}
+	if rows != 3 {
+		t.Errorf("UpdateRollup() added %d rows, want 3", rows)
+	}
+
+	tests := []struct {
+		freq      int64
+		start     time.Time
+		wantLow   float64
+		wantHigh  float64
+		wantAvg   float64
+		wantCount int64
+	}{
+		{freq: 100, start: minute, wantLow: -50, wantHigh: -10, wantAvg: -40, wantCount: 4},
+		{freq: 200, start: minute.Add(20 * time.Second), wantLow: -20, wantHigh: -20, wantAvg: -20, wantCount: 2},
+		{freq: 100, start: minute.Add(time.Minute + 5*time.Second), wantLow: -30, wantHigh: -30, wantAvg: -30, wantCount: 1},
+	}
+	for _, tc := range tests {
+		var low, high, avg float64
+		var count int64
+		err := db.QueryRow("SELECT DBLow, DBHigh, DBAvg, SampleCount FROM spectre_rollup WHERE FreqCenter = ? AND Start = ?", tc.freq, tc.start.UnixMilli()).Scan(&low, &high, &avg, &count)
+		if err != nil {
+			t.Errorf("rollup row for %d Hz at %s: %s", tc.freq, tc.start, err)
+			continue
+		}
+		if low != tc.wantLow || high != tc.wantHigh || avg != tc.wantAvg || count != tc.wantCount {
+			t.Errorf("rollup row for %d Hz at %s = %g/%g/%g (%d samples), want %g/%g/%g (%d samples)", tc.freq, tc.start, low, high, avg, count, tc.wantLow, tc.wantHigh, tc.wantAvg, tc.wantCount)
+		}
+	}
+
+	// Updating again only adds the newly finished bucket.
+	rows, err = UpdateRollup(db, DialectSQLite, minute.Add(10*time.Minute))
+	if err != nil {
+		t.Fatalf("UpdateRollup() failed: %s", err)
+	}
+	if rows != 1 {
+		t.Errorf("second UpdateRollup() added %d rows, want 1", rows)
+	}
+}
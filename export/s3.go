@@ -0,0 +1,184 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+const (
+	defaultS3Region        = "us-east-1"
+	defaultS3MaxSamples    = 100000
+	defaultS3FlushInterval = 10 * time.Minute
+	// s3BufferFactor limits how many objects worth of samples are kept in memory
+	// while uploads are failing.
+	s3BufferFactor  = 10
+	s3KeyTimeFormat = "20060102T150405.000Z"
+	s3ContentType   = "application/x-ndjson"
+)
+
+// S3 archives samples as gzip compressed JSON Lines objects in an S3 compatible object
+// storage (e.g. AWS S3 or MinIO). Samples are buffered and uploaded as one object per
+// identifier whenever MaxSamples are buffered, FlushInterval has passed or the samples
+// channel is closed. Objects are named <Prefix><identifier>/<start of first sample>.jsonl.gz.
+type S3 struct {
+	// Endpoint is the URL scheme, address and port of an S3 compatible object storage,
+	// e.g. http://localhost:9000 for MinIO, objects are then addressed path-style
+	// (<Endpoint>/<Bucket>/<key>). AWS S3 is used if empty.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to all object keys, e.g. "spectre/".
+	Prefix string
+	// AccessKey and SecretKey are used to sign the requests. Requests are sent unsigned
+	// if AccessKey is empty.
+	AccessKey string
+	SecretKey string
+
+	// MaxSamples is the number of samples after which an upload is started.
+	MaxSamples int
+	// FlushInterval is the maximum duration samples are buffered before being uploaded.
+	FlushInterval time.Duration
+
+	// uploader uploads the objects, created from the fields above when Write is called.
+	uploader s3Uploader
+}
+
+// s3Uploader uploads objects, it is implemented by the upload manager of the AWS SDK.
+type s3Uploader interface {
+	Upload(ctx context.Context, input *s3.PutObjectInput, opts ...func(*manager.Uploader)) (*manager.UploadOutput, error)
+}
+
+func (s *S3) Write(ctx context.Context, samples <-chan sdr.Sample) error {
+	maxSamples := defaultS3MaxSamples
+	if s.MaxSamples > 0 {
+		maxSamples = s.MaxSamples
+	}
+	flushInterval := defaultS3FlushInterval
+	if s.FlushInterval > 0 {
+		flushInterval = s.FlushInterval
+	}
+	if s.uploader == nil {
+		s.uploader = newS3Uploader(s)
+	}
+
+	var buffered []sdr.Sample
+	received := 0 // since the last flush
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			buffered = s.flush(ctx, buffered)
+			received = 0
+		case next, ok := <-samples:
+			if !ok {
+				if remaining := s.flush(ctx, buffered); len(remaining) > 0 {
+					return fmt.Errorf("unable to upload %d remaining samples to bucket %q", len(remaining), s.Bucket)
+				}
+				return nil
+			}
+			buffered = append(buffered, next)
+			received++
+			if received < maxSamples {
+				continue
+			}
+			buffered = s.flush(ctx, buffered)
+			received = 0
+			if dropped := len(buffered) - s3BufferFactor*maxSamples; dropped > 0 {
+				slog.Warn("buffer is full, dropping oldest samples", "count", dropped)
+				buffered = buffered[dropped:]
+			}
+		}
+	}
+}
+
+// flush uploads the samples as one object per identifier and returns the samples which
+// could not be uploaded so they are retried with the next flush.
+func (s *S3) flush(ctx context.Context, samples []sdr.Sample) []sdr.Sample {
+	if len(samples) == 0 {
+		return nil
+	}
+	var identifiers []string
+	byIdentifier := map[string][]sdr.Sample{}
+	for _, sample := range samples {
+		if _, ok := byIdentifier[sample.Identifier]; !ok {
+			identifiers = append(identifiers, sample.Identifier)
+		}
+		byIdentifier[sample.Identifier] = append(byIdentifier[sample.Identifier], sample)
+	}
+
+	var failed []sdr.Sample
+	for _, identifier := range identifiers {
+		objSamples := byIdentifier[identifier]
+		key := fmt.Sprintf("%s%s/%s.jsonl.gz", s.Prefix, identifier, objSamples[0].Start.UTC().Format(s3KeyTimeFormat))
+		if err := s.upload(ctx, key, objSamples); err != nil {
+			slog.Warn("error uploading samples, retrying with the next flush", "bucket", s.Bucket, "key", key, "count", len(objSamples), "error", err)
+			failed = append(failed, objSamples...)
+			continue
+		}
+		slog.Info("uploaded samples", "bucket", s.Bucket, "key", key, "count", len(objSamples))
+	}
+	return failed
+}
+
+// upload stores the samples as a gzip compressed JSON Lines object.
+func (s *S3) upload(ctx context.Context, key string, samples []sdr.Sample) error {
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	enc := json.NewEncoder(zw)
+	for _, sample := range samples {
+		if err := enc.Encode(sample); err != nil {
+			return fmt.Errorf("error encoding sample: %s", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("error compressing samples: %s", err)
+	}
+
+	if _, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:          aws.String(s.Bucket),
+		Key:             aws.String(key),
+		Body:            bytes.NewReader(buf.Bytes()),
+		ContentType:     aws.String(s3ContentType),
+		ContentEncoding: aws.String("gzip"),
+	}); err != nil {
+		return fmt.Errorf("error uploading object: %s", err)
+	}
+	return nil
+}
+
+// newS3Uploader returns an uploader for the object storage configured in s.
+func newS3Uploader(s *S3) *manager.Uploader {
+	region := defaultS3Region
+	if s.Region != "" {
+		region = s.Region
+	}
+	var creds aws.CredentialsProvider = aws.AnonymousCredentials{}
+	if s.AccessKey != "" {
+		creds = credentials.NewStaticCredentialsProvider(s.AccessKey, s.SecretKey, "")
+	}
+	client := s3.New(s3.Options{
+		Region:      region,
+		Credentials: creds,
+	}, func(o *s3.Options) {
+		if s.Endpoint != "" {
+			o.BaseEndpoint = aws.String(s.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return manager.NewUploader(client)
+}
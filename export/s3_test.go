@@ -0,0 +1,191 @@
+package export
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+// fakeUploader keeps the uploaded objects in memory and fails the first failures uploads.
+type fakeUploader struct {
+	mu       sync.Mutex
+	failures int
+	objects  map[string][]sdr.Sample
+}
+
+func (u *fakeUploader) Upload(ctx context.Context, input *s3.PutObjectInput, opts ...func(*manager.Uploader)) (*manager.UploadOutput, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.failures > 0 {
+		u.failures--
+		return nil, errors.New("injected failure")
+	}
+	if got, want := *input.ContentEncoding, "gzip"; got != want {
+		return nil, errors.New("unexpected content encoding " + got)
+	}
+	zr, err := gzip.NewReader(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	var samples []sdr.Sample
+	dec := json.NewDecoder(zr)
+	for {
+		var sample sdr.Sample
+		if err := dec.Decode(&sample); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+	if u.objects == nil {
+		u.objects = map[string][]sdr.Sample{}
+	}
+	u.objects[*input.Bucket+"/"+*input.Key] = samples
+	return &manager.UploadOutput{}, nil
+}
+
+func (u *fakeUploader) keys() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var keys []string
+	for key := range u.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestS3Write(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name     string
+		samples  []sdr.Sample
+		failures int
+		wantKeys []string
+		wantErr  bool
+	}{
+		{
+			name: "one object per identifier",
+			samples: []sdr.Sample{
+				{Identifier: "a", FreqCenter: 100, Start: start},
+				{Identifier: "b", FreqCenter: 100, Start: start.Add(time.Second)},
+				{Identifier: "a", FreqCenter: 200, Start: start.Add(2 * time.Second)},
+			},
+			wantKeys: []string{
+				"bucket/spectre/a/20240102T030405.000Z.jsonl.gz",
+				"bucket/spectre/b/20240102T030406.000Z.jsonl.gz",
+			},
+		},
+		{
+			name:    "no samples",
+			samples: nil,
+		},
+		{
+			name:     "failed upload",
+			samples:  []sdr.Sample{{Identifier: "a", Start: start}},
+			failures: 1,
+			wantErr:  true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			uploader := &fakeUploader{failures: tc.failures}
+			exporter := &S3{
+				Bucket:   "bucket",
+				Prefix:   "spectre/",
+				uploader: uploader,
+			}
+			samples := make(chan sdr.Sample, len(tc.samples))
+			for _, sample := range tc.samples {
+				samples <- sample
+			}
+			close(samples)
+
+			err := exporter.Write(context.Background(), samples)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("Write() error = %v, want error: %t", err, tc.wantErr)
+			}
+			keys := uploader.keys()
+			if len(keys) != len(tc.wantKeys) {
+				t.Fatalf("uploaded objects %q, want %q", keys, tc.wantKeys)
+			}
+			for i := range keys {
+				if keys[i] != tc.wantKeys[i] {
+					t.Errorf("uploaded objects %q, want %q", keys, tc.wantKeys)
+				}
+			}
+			var total int
+			for _, objSamples := range uploader.objects {
+				total += len(objSamples)
+			}
+			if !tc.wantErr && total != len(tc.samples) {
+				t.Errorf("uploaded %d samples, want %d", total, len(tc.samples))
+			}
+		})
+	}
+}
+
+func TestS3FlushRetriesFailedUploads(t *testing.T) {
+	uploader := &fakeUploader{failures: 1}
+	exporter := &S3{Bucket: "bucket", uploader: uploader}
+	samples := []sdr.Sample{{Identifier: "a", Start: time.Unix(0, 0)}, {Identifier: "a", Start: time.Unix(1, 0)}}
+
+	remaining := exporter.flush(context.Background(), samples)
+	if len(remaining) != len(samples) {
+		t.Fatalf("first flush returned %d samples to retry, want %d", len(remaining), len(samples))
+	}
+	if remaining = exporter.flush(context.Background(), remaining); len(remaining) != 0 {
+		t.Fatalf("second flush returned %d samples to retry, want 0", len(remaining))
+	}
+	if got := uploader.objects["bucket/a/19700101T000000.000Z.jsonl.gz"]; len(got) != len(samples) {
+		t.Errorf("uploaded %d samples, want %d", len(got), len(samples))
+	}
+}
+
+func TestS3UploadsToEndpoint(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+			t.Errorf("request isn't signed with the access key: %q", r.Header.Get("Authorization"))
+		}
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer server.Close()
+
+	exporter := &S3{
+		Endpoint:  server.URL,
+		Region:    "eu-central-1",
+		Bucket:    "bucket",
+		AccessKey: "key",
+		SecretKey: "secret",
+	}
+	samples := make(chan sdr.Sample, 1)
+	samples <- sdr.Sample{Identifier: "a", Start: time.Unix(0, 0)}
+	close(samples)
+	if err := exporter.Write(context.Background(), samples); err != nil {
+		t.Fatalf("Write() failed: %s", err)
+	}
+	want := "PUT /bucket/a/19700101T000000.000Z.jsonl.gz"
+	if len(requests) != 1 || requests[0] != want {
+		t.Errorf("requests %q, want [%q]", requests, want)
+	}
+}
@@ -2,71 +2,227 @@ package export
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
-	"github.com/golang/glog"
 	"github.com/hb9tf/spectre/sdr"
 )
 
 const (
-	contentType             = "application/json"
-	spectreEndpoint         = "spectre/v1/collect"
-	defaultSendSampleAmount = 100
+	contentType               = "application/json"
+	spectreEndpoint           = "spectre/v1/collect"
+	defaultSendSampleAmount   = 100
+	defaultMaxBufferedSamples = 100000
+	defaultInitialBackoff     = time.Second
+	defaultMaxBackoff         = 5 * time.Minute
+	defaultRequestTimeout     = 30 * time.Second
+	flushAttempts             = 3
+)
+
+var (
+	// errBatchRejected is returned by send if the server rejected the samples, e.g. because
+	// the request is too large. Resending the same samples wouldn't help.
+	errBatchRejected = errors.New("server rejected the samples")
+	// errUnauthorized is returned by send if the server doesn't accept the API key.
+	errUnauthorized = errors.New("server rejected the API key")
 )
 
 type SpectreServer struct {
 	Server            string
 	SendSamplesAmount int
-}
 
-func (s *SpectreServer) Write(ctx context.Context, samples <-chan sdr.Sample) error {
+	// MaxBufferedSamples is the maximum number of samples kept in memory while the
+	// server can't be reached. The oldest samples are dropped when exceeded.
+	MaxBufferedSamples int
+	// InitialBackoff is the duration to wait before retrying after a failed request.
+	// It is doubled with every consecutive failure up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
 
-	type collectResponse struct {
-		Status      string `json:"status"`
-		SampleCount int    `json:"sampleCount"`
-	}
+	// Compress enables gzip compression of the request body. Requires server support.
+	Compress bool
+	// APIKey is sent to the server to authenticate the collector (optional).
+	APIKey string
+	// Timeout is the maximum duration of a request including reading the response,
+	// defaults to 30s.
+	Timeout time.Duration
+
+	client *http.Client
+}
 
+func (s *SpectreServer) Write(ctx context.Context, samples <-chan sdr.Sample) error {
 	sendSamplesAmount := defaultSendSampleAmount
 	if s.SendSamplesAmount > 0 {
 		sendSamplesAmount = s.SendSamplesAmount
 	}
+	maxBufferedSamples := defaultMaxBufferedSamples
+	if s.MaxBufferedSamples > 0 {
+		maxBufferedSamples = s.MaxBufferedSamples
+	}
+	initialBackoff := defaultInitialBackoff
+	if s.InitialBackoff > 0 {
+		initialBackoff = s.InitialBackoff
+	}
+	maxBackoff := defaultMaxBackoff
+	if s.MaxBackoff > 0 {
+		maxBackoff = s.MaxBackoff
+	}
+	timeout := defaultRequestTimeout
+	if s.Timeout > 0 {
+		timeout = s.Timeout
+	}
+	s.client = &http.Client{Timeout: timeout}
 
 	var samplesToSend []sdr.Sample
-	for sample := range samples {
-		samplesToSend = append(samplesToSend, sample)
-		if len(samplesToSend) < sendSamplesAmount {
-			continue // we haven't collected enough samples to send yet
+	backoff := initialBackoff
+	var retry <-chan time.Time // set while waiting to retry after a failed request
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case next, ok := <-samples:
+			if !ok {
+				return s.flush(ctx, samplesToSend, sendSamplesAmount, initialBackoff)
+			}
+			samplesToSend = append(samplesToSend, next)
+			if dropped := len(samplesToSend) - maxBufferedSamples; dropped > 0 {
+				slog.Warn("buffer is full, dropping oldest samples", "count", dropped)
+				samplesToSend = samplesToSend[dropped:]
+			}
+			if retry != nil {
+				continue // we're waiting to retry, keep buffering
+			}
+		case <-retry:
+			retry = nil
 		}
 
-		body, err := json.Marshal(samplesToSend)
-		if err != nil {
-			glog.Warningf("error marshalling sample to JSON: %s\n", err)
-			continue
+		// Send as many full batches as we have. Failed batches remain at the front of the buffer.
+		for len(samplesToSend) >= sendSamplesAmount {
+			err := s.send(ctx, samplesToSend[:sendSamplesAmount])
+			if errors.Is(err, errUnauthorized) {
+				return err
+			}
+			if errors.Is(err, errBatchRejected) {
+				// Retrying wouldn't help and would block all following samples.
+				slog.Error("dropping samples rejected by the server", "server", s.Server, "count", sendSamplesAmount, "error", err)
+				err = nil
+			}
+			if err != nil {
+				slog.Warn("error sending samples to server", "server", s.Server, "retryIn", backoff, "error", err)
+				retry = time.After(backoff)
+				backoff = min(2*backoff, maxBackoff)
+				break
+			}
+			samplesToSend = samplesToSend[sendSamplesAmount:]
+			backoff = initialBackoff
 		}
+	}
+}
 
-		resp, err := http.Post(fmt.Sprintf("%s/%s", strings.TrimRight(s.Server, "/"), spectreEndpoint), contentType, bytes.NewBuffer(body))
-		if err != nil {
-			glog.Warningf("error POSTing sample: %s\n", err)
-			continue
+// flush sends all remaining samples, including a final partial batch. Each batch is
+// retried a few times before giving up.
+func (s *SpectreServer) flush(ctx context.Context, samplesToSend []sdr.Sample, sendSamplesAmount int, backoff time.Duration) error {
+	for len(samplesToSend) > 0 {
+		n := min(sendSamplesAmount, len(samplesToSend))
+		var err error
+		for attempt := 1; attempt <= flushAttempts; attempt++ {
+			if err = s.send(ctx, samplesToSend[:n]); err == nil {
+				break
+			}
+			if errors.Is(err, errUnauthorized) {
+				return err
+			}
+			if errors.Is(err, errBatchRejected) {
+				slog.Error("dropping samples rejected by the server", "server", s.Server, "count", n, "error", err)
+				err = nil
+				break
+			}
+			slog.Warn("error sending remaining samples to server", "server", s.Server, "attempt", attempt, "maxAttempts", flushAttempts, "error", err)
+			if attempt == flushAttempts {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
 		}
-		respBody, err := io.ReadAll(resp.Body)
 		if err != nil {
-			glog.Warningf("error reading POST body: %s\n", err)
+			return fmt.Errorf("unable to send %d remaining samples to server %s: %s", len(samplesToSend), s.Server, err)
 		}
+		samplesToSend = samplesToSend[n:]
+	}
+	return nil
+}
 
-		collectResponseBody := collectResponse{}
-		json.Unmarshal(respBody, &collectResponseBody)
-		glog.Infof("submitted %d samples to server %s", collectResponseBody.SampleCount, s.Server)
+// send POSTs the samples to the server and returns an error if they have not been accepted.
+func (s *SpectreServer) send(ctx context.Context, samples []sdr.Sample) error {
+	type collectResponse struct {
+		Status      string `json:"status"`
+		SampleCount int    `json:"sampleCount"`
+	}
 
-		resp.Body.Close()
+	body, err := json.Marshal(samples)
+	if err != nil {
+		return fmt.Errorf("error marshalling samples to JSON: %s", err)
+	}
 
-		samplesToSend = nil
+	if s.Compress {
+		buf := new(bytes.Buffer)
+		zw := gzip.NewWriter(buf)
+		if _, err := zw.Write(body); err != nil {
+			return fmt.Errorf("error compressing samples: %s", err)
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("error compressing samples: %s", err)
+		}
+		body = buf.Bytes()
 	}
 
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/%s", strings.TrimRight(s.Server, "/"), spectreEndpoint), bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %s", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if s.Compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if s.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error POSTing samples: %s", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading POST body: %s", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: server responded with status %q: %s", errUnauthorized, resp.Status, respBody)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		// Other client errors are permanent, e.g. a request larger than the server accepts.
+		return fmt.Errorf("%w: server responded with status %q: %s", errBatchRejected, resp.Status, respBody)
+	default:
+		return fmt.Errorf("server responded with status %q: %s", resp.Status, respBody)
+	}
+
+	collectResponseBody := collectResponse{}
+	json.Unmarshal(respBody, &collectResponseBody)
+	slog.Info("submitted samples to server", "server", s.Server, "count", collectResponseBody.SampleCount)
+
 	return nil
 }
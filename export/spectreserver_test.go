@@ -0,0 +1,119 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+// collectServer answers the requests with the given status codes in order, the last one is
+// repeated. The samples of the accepted requests are recorded.
+type collectServer struct {
+	mu       sync.Mutex
+	statuses []int
+	requests int
+	accepted []sdr.Sample
+}
+
+func (c *collectServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := c.statuses[min(c.requests, len(c.statuses)-1)]
+	c.requests++
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	var samples []sdr.Sample
+	if err := json.NewDecoder(r.Body).Decode(&samples); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.accepted = append(c.accepted, samples...)
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok", "sampleCount": len(samples)})
+}
+
+func TestSpectreServerWrite(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantRequests int
+		wantAccepted int
+		wantErr      bool
+	}{
+		{name: "accepted", statuses: []int{http.StatusOK}, wantRequests: 2, wantAccepted: 4},
+		{name: "server error is retried", statuses: []int{http.StatusInternalServerError, http.StatusOK}, wantRequests: 3, wantAccepted: 4},
+		{name: "rate limit is retried", statuses: []int{http.StatusTooManyRequests, http.StatusOK}, wantRequests: 3, wantAccepted: 4},
+		{name: "request timeout is retried", statuses: []int{http.StatusRequestTimeout, http.StatusOK}, wantRequests: 3, wantAccepted: 4},
+		{name: "rejected batch is dropped", statuses: []int{http.StatusRequestEntityTooLarge, http.StatusOK}, wantRequests: 2, wantAccepted: 2},
+		{name: "bad request is dropped", statuses: []int{http.StatusBadRequest}, wantRequests: 2},
+		{name: "unauthorized ends the export", statuses: []int{http.StatusUnauthorized}, wantRequests: 1, wantErr: true},
+		{name: "forbidden ends the export", statuses: []int{http.StatusForbidden}, wantRequests: 1, wantErr: true},
+		{name: "flush gives up", statuses: []int{http.StatusOK, http.StatusServiceUnavailable}, wantRequests: 2 + flushAttempts, wantAccepted: 2, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := &collectServer{statuses: tc.statuses}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			exporter := &SpectreServer{
+				Server:            server.URL,
+				SendSamplesAmount: 2,
+				InitialBackoff:    time.Millisecond,
+				MaxBackoff:        time.Millisecond,
+			}
+			samples := make(chan sdr.Sample, 4)
+			for i := int64(1); i <= 4; i++ {
+				samples <- sdr.Sample{FreqCenter: i}
+			}
+			close(samples)
+
+			err := exporter.Write(context.Background(), samples)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("Write() error = %v, want error: %t", err, tc.wantErr)
+			}
+			if handler.requests != tc.wantRequests {
+				t.Errorf("server got %d requests, want %d", handler.requests, tc.wantRequests)
+			}
+			if len(handler.accepted) != tc.wantAccepted {
+				t.Errorf("server accepted %d samples, want %d", len(handler.accepted), tc.wantAccepted)
+			}
+		})
+	}
+}
+
+func TestSpectreServerTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	exporter := &SpectreServer{
+		Server:         server.URL,
+		InitialBackoff: time.Millisecond,
+		Timeout:        10 * time.Millisecond,
+	}
+	samples := make(chan sdr.Sample, 1)
+	samples <- sdr.Sample{FreqCenter: 1}
+	close(samples)
+
+	done := make(chan error, 1)
+	go func() { done <- exporter.Write(context.Background(), samples) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Write() succeeded although the server never responded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write() didn't time out")
+	}
+}
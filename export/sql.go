@@ -4,16 +4,23 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-
-	"github.com/golang/glog"
+	"log/slog"
+	"time"
 
 	"github.com/hb9tf/spectre/sdr"
 )
 
 const (
-	sqlSampleCountInfo = 1000
+	DialectSQLite = "sqlite"
+	DialectMySQL  = "mysql"
+)
+
+const (
+	sqlSampleCountInfo      = 1000
+	defaultSQLBatchSize     = 1000
+	defaultSQLFlushInterval = 5 * time.Second
 
-	sqlCreateTableTmpl = `CREATE TABLE IF NOT EXISTS spectre (
+	sqliteCreateTableTmpl = `CREATE TABLE IF NOT EXISTS spectre (
 		"ID"           INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
 		"Identifier"   TEXT NOT NULL,
 		"Source"       TEXT NOT NULL,
@@ -25,8 +32,36 @@ const (
 		"DBAvg"        REAL,
 		"SampleCount"  INTEGER,
 		"Start"        INTEGER,
-		"End"          INTEGER
+		"End"          INTEGER,
+		"Lat"          REAL,
+		"Lon"          REAL,
+		"Metadata"     TEXT
 	);`
+	// Indexes matching the filters of the render queries (see extraction package).
+	sqlCreateSourceIndexTmpl     = `CREATE INDEX IF NOT EXISTS spectre_source_identifier_start ON spectre (Source, Identifier, Start);`
+	sqlCreateFreqCenterIndexTmpl = `CREATE INDEX IF NOT EXISTS spectre_freqcenter ON spectre (FreqCenter);`
+	// MySQL doesn't support CREATE INDEX IF NOT EXISTS so the indexes are part of the table.
+	mysqlCreateTableTmpl = `CREATE TABLE IF NOT EXISTS spectre (
+		ID           BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		Identifier   VARCHAR(255) NOT NULL,
+		Source       VARCHAR(255) NOT NULL,
+		FreqCenter   BIGINT,
+		FreqLow      BIGINT,
+		FreqHigh     BIGINT,
+		DBHigh       DOUBLE,
+		DBLow        DOUBLE,
+		DBAvg        DOUBLE,
+		SampleCount  BIGINT,
+		Start        BIGINT,
+		End          BIGINT,
+		Lat          DOUBLE,
+		Lon          DOUBLE,
+		Metadata     TEXT,
+		INDEX spectre_source_identifier_start (Source, Identifier, Start),
+		INDEX spectre_freqcenter (FreqCenter)
+	);`
+	// sqlAddColumnTmpl needs to be formatted with the column name and type.
+	sqlAddColumnTmpl    = `ALTER TABLE spectre ADD COLUMN %s %s;`
 	sqlInsertSampleTmpl = `INSERT INTO spectre (
 		Identifier,
 		Source,
@@ -38,58 +73,270 @@ const (
 		DBAvg,
 		SampleCount,
 		Start,
-		End
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+		End,
+		Lat,
+		Lon,
+		Metadata
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	// The unique index identifying samples for upserts. It fails to be created if the table
+	// already contains duplicates.
+	sqlCreateUniqueIndexTmpl = `CREATE UNIQUE INDEX IF NOT EXISTS spectre_sample ON spectre (Identifier, Source, FreqCenter, Start);`
+	// MySQL doesn't support CREATE INDEX IF NOT EXISTS so its existence is checked first.
+	mysqlCreateUniqueIndexTmpl = `CREATE UNIQUE INDEX spectre_sample ON spectre (Identifier, Source, FreqCenter, Start);`
+	mysqlUniqueIndexExistsTmpl = `SELECT
+		COUNT(*)
+	FROM
+		information_schema.statistics
+	WHERE
+		table_schema = DATABASE()
+		AND table_name = 'spectre'
+		AND index_name = 'spectre_sample';`
+	// The upsert clauses are appended to sqlInsertSampleTmpl and replace all values of an
+	// existing sample.
+	sqliteUpsertTmpl = ` ON CONFLICT (Identifier, Source, FreqCenter, Start) DO UPDATE SET
+		FreqLow = excluded.FreqLow,
+		FreqHigh = excluded.FreqHigh,
+		DBHigh = excluded.DBHigh,
+		DBLow = excluded.DBLow,
+		DBAvg = excluded.DBAvg,
+		SampleCount = excluded.SampleCount,
+		End = excluded.End,
+		Lat = excluded.Lat,
+		Lon = excluded.Lon,
+		Metadata = excluded.Metadata`
+	mysqlUpsertTmpl = ` ON DUPLICATE KEY UPDATE
+		FreqLow = VALUES(FreqLow),
+		FreqHigh = VALUES(FreqHigh),
+		DBHigh = VALUES(DBHigh),
+		DBLow = VALUES(DBLow),
+		DBAvg = VALUES(DBAvg),
+		SampleCount = VALUES(SampleCount),
+		End = VALUES(End),
+		Lat = VALUES(Lat),
+		Lon = VALUES(Lon),
+		Metadata = VALUES(Metadata)`
 )
 
+// sqlUpsertTmpls are the upsert clauses per dialect.
+var sqlUpsertTmpls = map[string]string{
+	DialectSQLite: sqliteUpsertTmpl,
+	DialectMySQL:  mysqlUpsertTmpl,
+}
+
+// sqlCreateTmpls are the statements to create the table and indexes per dialect.
+var sqlCreateTmpls = map[string][]string{
+	DialectSQLite: {sqliteCreateTableTmpl, sqlCreateSourceIndexTmpl, sqlCreateFreqCenterIndexTmpl},
+	DialectMySQL:  {mysqlCreateTableTmpl},
+}
+
+// sqlAddedColumns are the columns added to the table after its initial version with their
+// type per dialect. They are added to existing tables when missing, all rows stored before
+// have them set to NULL.
+var sqlAddedColumns = []struct {
+	name  string
+	types map[string]string
+}{
+	{"Lat", map[string]string{DialectSQLite: "REAL", DialectMySQL: "DOUBLE"}},
+	{"Lon", map[string]string{DialectSQLite: "REAL", DialectMySQL: "DOUBLE"}},
+	{"Metadata", map[string]string{DialectSQLite: "TEXT", DialectMySQL: "TEXT"}},
+}
+
 type SQL struct {
 	DB *sql.DB
+	// Dialect is the SQL dialect of the DB (see Dialect* constants), defaults to DialectSQLite.
+	Dialect string
+	// OnInsertError is called for every sample which could not be stored (optional).
+	OnInsertError func(sdr.Sample, error)
+
+	// BatchSize is the maximum number of samples inserted in one transaction.
+	BatchSize int
+	// FlushInterval is the maximum duration samples are kept before being inserted.
+	FlushInterval time.Duration
+	// Upsert replaces samples which are already stored (same identifier, source, center
+	// frequency and start) instead of inserting duplicates, e.g. when a batch is resent.
+	// This requires a unique index which is created if needed.
+	Upsert bool
 }
 
 func (s *SQL) Write(ctx context.Context, samples <-chan sdr.Sample) error {
-	if err := sqlCreateTableIfNotExists(s.DB); err != nil {
+	if err := sqlCreateTableIfNotExists(s.DB, s.Dialect); err != nil {
 		return fmt.Errorf("unable to create table: %s", err)
 	}
 
+	query := sqlInsertSampleTmpl
+	if s.Upsert {
+		if err := sqlCreateUniqueIndexIfNotExists(s.DB, s.Dialect); err != nil {
+			return fmt.Errorf("unable to create unique index for upserts, the table might already contain duplicates: %s", err)
+		}
+		query += sqlUpsertTmpls[s.dialect()]
+	}
+
+	// Prepare the insert statement once and reuse it in every transaction.
+	statement, err := s.DB.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("unable to prepare insert statement: %s", err)
+	}
+	defer statement.Close()
+
+	batchSize := defaultSQLBatchSize
+	if s.BatchSize > 0 {
+		batchSize = s.BatchSize
+	}
+	flushInterval := defaultSQLFlushInterval
+	if s.FlushInterval > 0 {
+		flushInterval = s.FlushInterval
+	}
+
 	counts := map[string]int64{
 		"error":   0,
 		"success": 0,
 		"total":   0,
 	}
-	for sample := range samples {
-		counts["total"] += 1
-		if err := sqlInsertSample(s.DB, sample); err != nil {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	var batch []sdr.Sample
+	for {
+		select {
+		case <-ctx.Done():
+			// Store the buffered samples like when the channel is closed.
+			s.insertBatch(statement, batch, counts)
+			return ctx.Err()
+		case sample, ok := <-samples:
+			if !ok {
+				s.insertBatch(statement, batch, counts)
+				return nil
+			}
+			batch = append(batch, sample)
+			if len(batch) < batchSize {
+				continue // we haven't collected enough samples to insert yet
+			}
+		case <-ticker.C:
+		}
+		s.insertBatch(statement, batch, counts)
+		batch = nil
+	}
+}
+
+func (s *SQL) dialect() string {
+	if s.Dialect == "" {
+		return DialectSQLite
+	}
+	return s.Dialect
+}
+
+// insertBatch inserts all samples in one transaction and updates the counts accordingly.
+func (s *SQL) insertBatch(statement *sql.Stmt, batch []sdr.Sample, counts map[string]int64) {
+	if len(batch) == 0 {
+		return
+	}
+	before := counts["total"]
+	counts["total"] += int64(len(batch))
+	defer func() {
+		if before/sqlSampleCountInfo != counts["total"]/sqlSampleCountInfo {
+			slog.Info("sample export counts", "total", counts["total"], "success", counts["success"], "error", counts["error"])
+		}
+	}()
+
+	failAll := func(err error) {
+		counts["error"] += int64(len(batch))
+		slog.Warn("error storing samples in DB", "count", len(batch), "error", err)
+		if s.OnInsertError != nil {
+			for _, sample := range batch {
+				s.OnInsertError(sample, err)
+			}
+		}
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		failAll(err)
+		return
+	}
+	txStatement := tx.Stmt(statement)
+	var inserted []sdr.Sample
+	for _, sample := range batch {
+		if err := sqlInsertSample(txStatement, sample); err != nil {
 			counts["error"] += 1
-			glog.Warningf("error storing in sqlite DB: %s\n", err)
+			slog.Warn("error storing sample in DB", "source", sample.Source, "identifier", sample.Identifier, "error", err)
+			if s.OnInsertError != nil {
+				s.OnInsertError(sample, err)
+			}
 			continue
 		}
-		counts["success"] += 1
-		if counts["total"]%sqlSampleCountInfo == 0 {
-			glog.Infof("Sample export counts: %+v\n", counts)
+		inserted = append(inserted, sample)
+	}
+	if err := tx.Commit(); err != nil {
+		batch = inserted
+		failAll(err)
+		return
+	}
+	counts["success"] += int64(len(inserted))
+}
+
+func sqlCreateTableIfNotExists(db *sql.DB, dialect string) error {
+	if dialect == "" {
+		dialect = DialectSQLite
+	}
+	tmpls, ok := sqlCreateTmpls[dialect]
+	if !ok {
+		return fmt.Errorf("unsupported SQL dialect %q", dialect)
+	}
+	// This only runs once, so there is no point in preparing a statement.
+	for _, tmpl := range tmpls {
+		if _, err := db.Exec(tmpl); err != nil {
+			return err
+		}
+	}
+
+	for _, column := range sqlAddedColumns {
+		if sqlColumnExists(db, column.name) {
+			continue
+		}
+		slog.Info("adding missing column to table", "column", column.name)
+		if _, err := db.Exec(fmt.Sprintf(sqlAddColumnTmpl, column.name, column.types[dialect])); err != nil {
+			// Another writer might have added it in the meantime.
+			if sqlColumnExists(db, column.name) {
+				continue
+			}
+			return fmt.Errorf("unable to add column %q: %s", column.name, err)
 		}
 	}
 
 	return nil
 }
 
-func sqlCreateTableIfNotExists(db *sql.DB) error {
-	statement, err := db.Prepare(sqlCreateTableTmpl)
-	if err != nil {
+// sqlCreateUniqueIndexIfNotExists creates the unique index upserts are keyed on.
+func sqlCreateUniqueIndexIfNotExists(db *sql.DB, dialect string) error {
+	if dialect != DialectMySQL {
+		_, err := db.Exec(sqlCreateUniqueIndexTmpl)
 		return err
 	}
-	if _, err := statement.Exec(); err != nil {
+	var count int
+	if err := db.QueryRow(mysqlUniqueIndexExistsTmpl).Scan(&count); err != nil {
 		return err
 	}
+	if count > 0 {
+		return nil
+	}
+	slog.Info("creating unique index, this might take a while for large tables")
+	_, err := db.Exec(mysqlCreateUniqueIndexTmpl)
+	return err
+}
 
-	return nil
+// sqlColumnExists returns whether the table has the column.
+func sqlColumnExists(db *sql.DB, column string) bool {
+	var count int64
+	return db.QueryRow(fmt.Sprintf("SELECT COUNT(%s) FROM spectre WHERE 1 = 0", column)).Scan(&count) == nil
 }
 
-func sqlInsertSample(db *sql.DB, s sdr.Sample) error {
-	statement, err := db.Prepare(sqlInsertSampleTmpl)
-	if err != nil {
-		return err
+func sqlInsertSample(statement *sql.Stmt, s sdr.Sample) error {
+	// Metadata is stored as JSON object, NULL if there is none.
+	var metadata *string
+	if encoded := encodeMetadata(s.Metadata); encoded != "" {
+		metadata = &encoded
 	}
-	if _, err := statement.Exec(s.Identifier, s.Source, s.FreqCenter, s.FreqLow, s.FreqHigh, s.DBHigh, s.DBLow, s.DBAvg, s.SampleCount, s.Start.UnixMilli(), s.End.UnixMilli()); err != nil {
+	if _, err := statement.Exec(s.Identifier, s.Source, s.FreqCenter, s.FreqLow, s.FreqHigh, s.DBHigh, s.DBLow, s.DBAvg, s.SampleCount, s.Start.UnixMilli(), s.End.UnixMilli(), s.Lat, s.Lon, metadata); err != nil {
 		return err
 	}
 
@@ -0,0 +1,238 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+func TestSQLWrite(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	sample := func(freq int64, db float64) sdr.Sample {
+		return sdr.Sample{
+			Identifier:  "id",
+			Source:      "rtlsdr",
+			FreqCenter:  freq,
+			DBLow:       db,
+			DBHigh:      db,
+			DBAvg:       db,
+			SampleCount: 1,
+			Start:       start,
+			End:         start.Add(time.Second),
+		}
+	}
+	tests := []struct {
+		name      string
+		upsert    bool
+		samples   []sdr.Sample
+		wantRows  int
+		wantDBAvg float64 // of the sample at 100 Hz
+	}{
+		{
+			name:      "insert",
+			samples:   []sdr.Sample{sample(100, -40), sample(200, -50)},
+			wantRows:  2,
+			wantDBAvg: -40,
+		},
+		{
+			name:      "insert keeps duplicates",
+			samples:   []sdr.Sample{sample(100, -40), sample(100, -30)},
+			wantRows:  2,
+			wantDBAvg: -40,
+		},
+		{
+			name:      "upsert replaces duplicates",
+			upsert:    true,
+			samples:   []sdr.Sample{sample(100, -40), sample(200, -50), sample(100, -30)},
+			wantRows:  2,
+			wantDBAvg: -30,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newTestDB(t)
+			// Each sample is inserted in its own batch so duplicates aren't part of the same transaction.
+			exporter := &SQL{DB: db, Upsert: tc.upsert, BatchSize: 1}
+			samples := make(chan sdr.Sample, len(tc.samples))
+			for _, s := range tc.samples {
+				samples <- s
+			}
+			close(samples)
+			if err := exporter.Write(context.Background(), samples); err != nil {
+				t.Fatalf("Write() failed: %s", err)
+			}
+
+			var rows int
+			if err := db.QueryRow("SELECT COUNT(*) FROM spectre").Scan(&rows); err != nil {
+				t.Fatalf("unable to count rows: %s", err)
+			}
+			if rows != tc.wantRows {
+				t.Errorf("table has %d rows, want %d", rows, tc.wantRows)
+			}
+			var dbAvg float64
+			if err := db.QueryRow("SELECT DBAvg FROM spectre WHERE FreqCenter = 100 ORDER BY ID LIMIT 1").Scan(&dbAvg); err != nil {
+				t.Fatalf("unable to query sample: %s", err)
+			}
+			if dbAvg != tc.wantDBAvg {
+				t.Errorf("sample has %g dB, want %g dB", dbAvg, tc.wantDBAvg)
+			}
+		})
+	}
+}
+
+func TestSQLUpsertFailsOnDuplicates(t *testing.T) {
+	db := newTestDB(t)
+	sample := sdr.Sample{Identifier: "id", Source: "rtlsdr", FreqCenter: 100, Start: time.Unix(0, 0)}
+	insertTestSamples(t, db, sample, sample)
+
+	samples := make(chan sdr.Sample)
+	close(samples)
+	if err := (&SQL{DB: db, Upsert: true}).Write(context.Background(), samples); err == nil {
+		t.Error("Write() succeeded although the unique index can't be created")
+	}
+}
+
+func TestSQLWriteFlushesOnCancel(t *testing.T) {
+	db := newTestDB(t)
+	exporter := &SQL{DB: db, BatchSize: 100, FlushInterval: time.Hour}
+	samples := make(chan sdr.Sample)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- exporter.Write(ctx, samples) }()
+
+	for i := int64(0); i < 3; i++ {
+		samples <- sdr.Sample{Identifier: "id", Source: "rtlsdr", FreqCenter: i, Start: time.Unix(i, 0)}
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Write() error = %v, want %v", err, context.Canceled)
+	}
+
+	var rows int
+	if err := db.QueryRow("SELECT COUNT(*) FROM spectre").Scan(&rows); err != nil {
+		t.Fatalf("unable to count rows: %s", err)
+	}
+	if rows != 3 {
+		t.Errorf("table has %d rows, want the 3 buffered samples", rows)
+	}
+}
+
+// countingDriver wraps the sqlite3 driver and counts the statements prepared on its connections.
+type countingDriver struct {
+	sqlite3.SQLiteDriver
+	prepares atomic.Int64
+}
+
+func (d *countingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.SQLiteDriver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{SQLiteConn: conn.(*sqlite3.SQLiteConn), prepares: &d.prepares}, nil
+}
+
+// countingConn counts the prepared statements. Exec and Query run on the SQLiteConn directly
+// without preparing one.
+type countingConn struct {
+	*sqlite3.SQLiteConn
+	prepares *atomic.Int64
+}
+
+func (c *countingConn) Prepare(query string) (driver.Stmt, error) {
+	c.prepares.Add(1)
+	return c.SQLiteConn.Prepare(query)
+}
+
+func (c *countingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	c.prepares.Add(1)
+	return c.SQLiteConn.PrepareContext(ctx, query)
+}
+
+func TestSQLWritePreparesOnce(t *testing.T) {
+	d := &countingDriver{}
+	name := fmt.Sprintf("sqlite3_counting_%p", d)
+	sql.Register(name, d)
+
+	tests := []struct {
+		samples   int
+		batchSize int
+		upsert    bool
+	}{
+		{samples: 1, batchSize: 1},
+		{samples: 10, batchSize: 1},
+		{samples: 1000, batchSize: 1},
+		{samples: 1000, batchSize: 7},
+		{samples: 1000, batchSize: 1000},
+		{samples: 1000, batchSize: 7, upsert: true},
+	}
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("%d samples in batches of %d upsert %t", tc.samples, tc.batchSize, tc.upsert), func(t *testing.T) {
+			db, err := sql.Open(name, filepath.Join(t.TempDir(), "spectre.db"))
+			if err != nil {
+				t.Fatalf("unable to open DB: %s", err)
+			}
+			defer db.Close()
+			// A single connection makes the transactions reuse the statement prepared on it.
+			db.SetMaxOpenConns(1)
+
+			samples := make(chan sdr.Sample, tc.samples)
+			for i := 0; i < tc.samples; i++ {
+				samples <- sdr.Sample{Identifier: "id", Source: "rtlsdr", FreqCenter: int64(i), Start: time.Unix(0, 0)}
+			}
+			close(samples)
+			before := d.prepares.Load()
+			if err := (&SQL{DB: db, BatchSize: tc.batchSize, Upsert: tc.upsert}).Write(context.Background(), samples); err != nil {
+				t.Fatalf("Write() failed: %s", err)
+			}
+			if got := d.prepares.Load() - before; got != 1 {
+				t.Errorf("Write() prepared %d statements, want 1", got)
+			}
+
+			var rows int
+			if err := db.QueryRow("SELECT COUNT(*) FROM spectre").Scan(&rows); err != nil {
+				t.Fatalf("unable to count rows: %s", err)
+			}
+			if rows != tc.samples {
+				t.Errorf("table has %d rows, want %d", rows, tc.samples)
+			}
+		})
+	}
+}
+
+// BenchmarkSQLWrite measures storing samples in sqlite, a batch size of 1 commits every
+// sample in its own transaction.
+func BenchmarkSQLWrite(b *testing.B) {
+	const count = 1000
+	for _, batchSize := range []int{1, 100, defaultSQLBatchSize} {
+		b.Run(fmt.Sprintf("batch%d", batchSize), func(b *testing.B) {
+			db, err := sql.Open("sqlite3", filepath.Join(b.TempDir(), "spectre.db"))
+			if err != nil {
+				b.Fatalf("unable to open DB: %s", err)
+			}
+			defer db.Close()
+			exporter := &SQL{DB: db, BatchSize: batchSize}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				samples := make(chan sdr.Sample, count)
+				for j := int64(0); j < count; j++ {
+					samples <- sdr.Sample{Identifier: "id", Source: "rtlsdr", FreqCenter: j, Start: time.Unix(int64(i), 0)}
+				}
+				close(samples)
+				if err := exporter.Write(context.Background(), samples); err != nil {
+					b.Fatalf("Write() failed: %s", err)
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*count), "ns/sample")
+		})
+	}
+}
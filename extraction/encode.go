@@ -0,0 +1,103 @@
+package extraction
+
+import (
+	"database/sql"
+	"fmt"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/tiff"
+)
+
+const (
+	FormatJPEG = "jpg"
+	FormatPNG  = "png"
+	FormatWebP = "webp"
+	FormatTIFF = "tiff"
+	FormatSVG  = "svg"
+)
+
+var (
+	contentTypes = map[string]string{
+		FormatJPEG: "image/jpeg",
+		FormatPNG:  "image/png",
+		FormatWebP: "image/webp",
+		FormatTIFF: "image/tiff",
+		FormatSVG:  "image/svg+xml",
+	}
+	// Formats which support a transparent background.
+	transparentFormats = map[string]bool{
+		FormatPNG:  true,
+		FormatWebP: true,
+		FormatTIFF: true,
+		FormatSVG:  true,
+	}
+)
+
+// ContentType returns the MIME type of the image format or an empty string if the format is unknown.
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// SupportsTransparency returns whether the image format can have a transparent background.
+func SupportsTransparency(format string) bool {
+	return transparentFormats[format]
+}
+
+// Encode writes the rendered image to w in the given format. SVG results can only be
+// written as FormatSVG and raster results only as any of the other formats.
+func Encode(w io.Writer, result *RenderResult, format string, quality int) error {
+	if format == FormatSVG {
+		if result.SVG == nil {
+			return fmt.Errorf("result has not been rendered as %s", format)
+		}
+		_, err := w.Write(result.SVG)
+		return err
+	}
+	if result.Image == nil {
+		return fmt.Errorf("result has not been rendered as %s", format)
+	}
+
+	switch format {
+	case FormatJPEG:
+		if quality < 1 || quality > 100 {
+			quality = jpeg.DefaultQuality
+		}
+		return jpeg.Encode(w, result.Image, &jpeg.Options{Quality: quality})
+	case FormatPNG:
+		return png.Encode(w, result.Image)
+	case FormatWebP:
+		return EncodeWebP(w, result.Image)
+	case FormatTIFF:
+		return tiff.Encode(w, result.Image, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+	default:
+		return fmt.Errorf("unsupported image format %q", format)
+	}
+}
+
+// RenderFormat renders the waterfall with the renderer matching the format, i.e. RenderSVG
+// for SVG and Render for all other formats, without encoding it.
+func RenderFormat(db *sql.DB, req *RenderRequest, format string) (*RenderResult, error) {
+	if ContentType(format) == "" {
+		return nil, fmt.Errorf("unsupported image format %q", format)
+	}
+	if format == FormatSVG {
+		return RenderSVG(db, req)
+	}
+	return Render(db, req)
+}
+
+// RenderTo renders the waterfall and streams the encoded image directly to w instead of
+// buffering it which bounds the memory needed for large images. Nothing is written to w
+// if rendering fails.
+func RenderTo(db *sql.DB, req *RenderRequest, w io.Writer, format string) (*RenderResult, error) {
+	result, err := RenderFormat(db, req, format)
+	if err != nil {
+		return nil, err
+	}
+	if err := Encode(w, result, format, req.Image.Quality); err != nil {
+		return nil, fmt.Errorf("unable to encode image as %s: %s", format, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,133 @@
+package extraction
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+func TestRenderTo(t *testing.T) {
+	db := newTestDB(t)
+	decoders := map[string]func(io.Reader) (image.Image, error){
+		FormatJPEG: jpeg.Decode,
+		FormatPNG:  png.Decode,
+		FormatWebP: webp.Decode,
+		FormatTIFF: tiff.Decode,
+	}
+	for format, decode := range decoders {
+		t.Run(format, func(t *testing.T) {
+			req := newTestRequest("a")
+			req.Image.AddGrid = true
+			buf := new(bytes.Buffer)
+			result, err := RenderTo(db, req, buf, format)
+			if err != nil {
+				t.Fatalf("RenderTo() failed: %s", err)
+			}
+			img, err := decode(buf)
+			if err != nil {
+				t.Fatalf("unable to decode %s image: %s", format, err)
+			}
+			if got, want := img.Bounds().Size(), result.Image.Bounds().Size(); got != want {
+				t.Errorf("decoded image is %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestRenderToSVG(t *testing.T) {
+	db := newTestDB(t)
+	req := newTestRequest("a")
+	req.Image.AddGrid = true
+	req.Image.AddLegend = true
+	buf := new(bytes.Buffer)
+	if _, err := RenderTo(db, req, buf, FormatSVG); err != nil {
+		t.Fatalf("RenderTo() failed: %s", err)
+	}
+	var doc struct {
+		XMLName xml.Name `xml:"svg"`
+		Width   int      `xml:"width,attr"`
+		Height  int      `xml:"height,attr"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("SVG is not valid XML: %s", err)
+	}
+	if want := 4 + gridMarginLeft + legendMarginLeft + legendWidth + legendLabelWidth; doc.Width != want {
+		t.Errorf("SVG is %d pixels wide, want %d", doc.Width, want)
+	}
+	if want := 3 + gridMarginTop; doc.Height != want {
+		t.Errorf("SVG is %d pixels high, want %d", doc.Height, want)
+	}
+	// All 12 buckets have a different color, so none of the rectangles are merged.
+	if got := strings.Count(buf.String(), `height="1"`); got != 12 {
+		t.Errorf("SVG has %d waterfall rectangles, want 12", got)
+	}
+}
+
+func TestRenderToErrors(t *testing.T) {
+	db := newTestDB(t)
+	tests := []struct {
+		name       string
+		identifier string
+		format     string
+		mode       string
+	}{
+		{name: "unknown format", identifier: "a", format: "gif"},
+		{name: "spectrum as svg", identifier: "a", format: FormatSVG, mode: ModeSpectrum},
+		{name: "no samples", identifier: "c", format: FormatPNG},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newTestRequest(tc.identifier)
+			req.Image.Mode = tc.mode
+			buf := new(bytes.Buffer)
+			if _, err := RenderTo(db, req, buf, tc.format); err == nil {
+				t.Fatal("RenderTo() succeeded, want error")
+			}
+			if buf.Len() > 0 {
+				t.Errorf("RenderTo() wrote %d bytes although rendering failed", buf.Len())
+			}
+		})
+	}
+}
+
+func TestEncodeMismatchedResult(t *testing.T) {
+	raster := &RenderResult{Image: image.NewRGBA(image.Rect(0, 0, 1, 1))}
+	if err := Encode(io.Discard, raster, FormatSVG, 0); err == nil {
+		t.Error("Encode() of a raster image as SVG succeeded, want error")
+	}
+	svg := &RenderResult{SVG: []byte("<svg/>")}
+	if err := Encode(io.Discard, svg, FormatPNG, 0); err == nil {
+		t.Error("Encode() of an SVG as PNG succeeded, want error")
+	}
+}
+
+func TestContentType(t *testing.T) {
+	tests := []struct {
+		format          string
+		wantType        string
+		wantTransparent bool
+	}{
+		{format: FormatJPEG, wantType: "image/jpeg"},
+		{format: FormatPNG, wantType: "image/png", wantTransparent: true},
+		{format: FormatWebP, wantType: "image/webp", wantTransparent: true},
+		{format: FormatTIFF, wantType: "image/tiff", wantTransparent: true},
+		{format: FormatSVG, wantType: "image/svg+xml", wantTransparent: true},
+		{format: "gif"},
+	}
+	for _, tc := range tests {
+		if got := ContentType(tc.format); got != tc.wantType {
+			t.Errorf("ContentType(%q) = %q, want %q", tc.format, got, tc.wantType)
+		}
+		if got := SupportsTransparency(tc.format); got != tc.wantTransparent {
+			t.Errorf("SupportsTransparency(%q) = %t, want %t", tc.format, got, tc.wantTransparent)
+		}
+	}
+}
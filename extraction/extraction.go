@@ -2,34 +2,89 @@ package extraction
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
+	"log/slog"
 	"math"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/golang/glog"
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/basicfont"
 	"golang.org/x/image/math/fixed"
 )
 
+const (
+	MetricHigh = "high"
+	MetricAvg  = "avg"
+	MetricLow  = "low"
+)
+
+const (
+	PaletteDefault   = "default"
+	PaletteViridis   = "viridis"
+	PaletteGrayscale = "grayscale"
+	PaletteInferno   = "inferno"
+)
+
 var (
-	// Colors defining the gradient in the heatmap. The higher the index, the warmer.
-	colors = map[int]color.RGBA{
-		0: {0, 0, 0, 255},       // black
-		1: {0, 0, 255, 255},     // blue
-		2: {0, 255, 255, 255},   // cyan
-		3: {0, 255, 0, 255},     // green
-		4: {255, 255, 0, 255},   // yellow
-		5: {255, 0, 0, 255},     // red
-		6: {255, 255, 255, 255}, // white
+	// Palettes defining the gradient in the heatmap. The higher the index, the warmer.
+	palettes = map[string]map[int]color.RGBA{
+		PaletteDefault: {
+			0: {0, 0, 0, 255},       // black
+			1: {0, 0, 255, 255},     // blue
+			2: {0, 255, 255, 255},   // cyan
+			3: {0, 255, 0, 255},     // green
+			4: {255, 255, 0, 255},   // yellow
+			5: {255, 0, 0, 255},     // red
+			6: {255, 255, 255, 255}, // white
+		},
+		PaletteViridis: {
+			0: {68, 1, 84, 255},    // dark purple
+			1: {68, 57, 131, 255},  // purple
+			2: {49, 104, 142, 255}, // blue
+			3: {33, 145, 140, 255}, // teal
+			4: {53, 183, 121, 255}, // green
+			5: {144, 215, 67, 255}, // light green
+			6: {253, 231, 37, 255}, // yellow
+		},
+		PaletteGrayscale: {
+			0: {0, 0, 0, 255},       // black
+			1: {255, 255, 255, 255}, // white
+		},
+		PaletteInferno: {
+			0: {0, 0, 4, 255},       // black
+			1: {50, 10, 94, 255},    // dark purple
+			2: {120, 28, 109, 255},  // purple
+			3: {188, 55, 84, 255},   // red
+			4: {237, 105, 37, 255},  // orange
+			5: {251, 182, 26, 255},  // yellow
+			6: {252, 255, 164, 255}, // light yellow
+		},
+	}
+
+	// Aggregations of the dB values of all samples in a bucket per metric.
+	metricAggregations = map[string]string{
+		MetricHigh: "MAX(DBHigh)",
+		MetricAvg:  "AVG(DBAvg)",
+		MetricLow:  "MIN(DBLow)",
 	}
 
-	gridColor           = color.RGBA{0, 0, 0, 255}       // white
-	gridBackgroundColor = color.RGBA{255, 255, 255, 255} // black
+	// DefaultGridColors draws a light grid on a dark background to match the waterfall.
+	DefaultGridColors = GridColors{
+		Grid:       color.RGBA{255, 255, 255, 255}, // white
+		Text:       color.RGBA{255, 255, 255, 255}, // white
+		Background: color.RGBA{0, 0, 0, 255},       // black
+	}
+	gridTransparentBackgroundColor = color.RGBA{0, 0, 0, 0} // transparent
 
 	expSuffixLookup = map[int]string{
 		0: "Hz",  // 10^0
@@ -37,28 +92,36 @@ var (
 		2: "MHz", // 10^6
 		3: "GHz", // 10^9
 		4: "THz", // 10^12
+		5: "PHz", // 10^15
+		6: "EHz", // 10^18, the largest suffix needed for int64 frequencies
 	}
 )
 
 const (
-	timeFmt            = "2006-01-02T15:04:05"
-	gridMarginTop      = 20  // pixels
-	gridMarginLeft     = 150 // pixels
-	gridTickLen        = 10  // pixel
-	gridMinStepX       = 100 // pixels
-	gridMinStepY       = 20  // pixels
+	timeFmt          = "2006-01-02T15:04:05"
+	gridMarginTop    = 20  // pixels
+	gridMarginLeft   = 150 // pixels
+	gridTickLen      = 10  // pixel
+	gridMinStepX     = 100 // pixels
+	gridMinStepY     = 20  // pixels
+	gridMaxTicks     = 25  // per axis
+	legendMarginLeft = 10  // pixels
+	legendWidth      = 20  // pixels
+	legendLabelWidth = 80  // pixels
+	// The following templates need to be formatted with the condition selecting the
+	// identifiers (see identifierFilter).
 	getSampleCountTmpl = `SELECT
 		COUNT(*)
 	FROM
 		spectre
 	WHERE
 		Source = ?
-		AND Identifier LIKE ?
+		AND %s
 		AND FreqLow >= ?
 		AND FreqHigh <= ?
 		AND Start >= ?
 		AND End <= ?;`
-	// getFreqResolutionTmpl is the sqlite query to get the number of distinct frequencies
+	// getFreqResolutionTmpl is the SQL query to get the number of distinct frequencies
 	// in the DB. This results in the maximum amount of pixels in the X axis we should render.
 	// This is possible because the frequency centers remain the same across a run.
 	getFreqResolutionTmpl = `SELECT
@@ -67,12 +130,12 @@ const (
 		spectre
 	WHERE
 		Source = ?
-		AND Identifier LIKE ?
+		AND %s
 		AND FreqLow >= ?
 		AND FreqHigh <= ?
 		AND Start >= ?
 		AND End <= ?;`
-	// getTimeResolution is the sqlite query to get the number of distinct timestamps
+	// getTimeResolution is the SQL query to get the number of distinct timestamps
 	// for a frequency in the DB. This results in the maximum amount of pixels in the Y
 	// axis we should render.
 	// This is more involved because the timestamps are different per frequency.
@@ -88,23 +151,26 @@ const (
 					spectre
 				WHERE
 					Source = ?
-					AND Identifier LIKE ?
+					AND %s
 					AND FreqLow >= ?
 					AND FreqHigh <= ?
 					AND Start >= ?
 					AND End <= ?
 			)
 			AND Source = ?
-			AND Identifier LIKE ?
+			AND %s
 			AND Start >= ?
 			AND End <= ?;`
+	// getImgDataTmpl needs to be formatted with the aggregation of the dB values (see metricAggregations),
+	// the table to query (the samples or the rollup) and the identifier condition.
 	getImgDataTmpl = `SELECT
 			MIN(FreqLow),
 			AVG(FreqCenter),
 			MAX(FreqHigh),
-			MAX(DBHigh),
+			%s,
 			MIN(Start),
 			MAX(End),
+			SUM(SampleCount),
 			TimeBucket,
 			FreqBucket
 		FROM (
@@ -113,15 +179,18 @@ const (
 				FreqCenter,
 				FreqHigh,
 				DBHigh,
+				DBLow,
+				DBAvg,
+				SampleCount,
 				Start,
 				End,
 				NTILE (?) OVER (ORDER BY Start) TimeBucket,
 				NTILE (?) OVER (ORDER BY FreqCenter) FreqBucket
 			FROM
-				spectre
+				%s
 			WHERE
 				Source = ?
-				AND Identifier LIKE ?
+				AND %s
 				AND FreqLow >= ?
 				AND FreqHigh <= ?
 				AND Start >= ?
@@ -131,145 +200,369 @@ const (
 				FreqBucket ASC
 		)
 		GROUP BY TimeBucket, FreqBucket;`
+	// getSpectrumDataTmpl is like getImgDataTmpl but buckets the samples only by frequency.
+	// All buckets are in the first (and only) time bucket.
+	getSpectrumDataTmpl = `SELECT
+			MIN(FreqLow),
+			AVG(FreqCenter),
+			MAX(FreqHigh),
+			%s,
+			MIN(Start),
+			MAX(End),
+			SUM(SampleCount),
+			1,
+			FreqBucket
+		FROM (
+			SELECT
+				FreqLow,
+				FreqCenter,
+				FreqHigh,
+				DBHigh,
+				DBLow,
+				DBAvg,
+				SampleCount,
+				Start,
+				End,
+				NTILE (?) OVER (ORDER BY FreqCenter) FreqBucket
+			FROM
+				%s
+			WHERE
+				Source = ?
+				AND %s
+				AND FreqLow >= ?
+				AND FreqHigh <= ?
+				AND Start >= ?
+				AND End <= ?
+		)
+		GROUP BY FreqBucket;`
 )
 
 func GetSampleCount(db *sql.DB, source, identifier string, startFreq, endFreq int64, startTime, endTime time.Time) (int, error) {
-	if identifier == "" {
-		identifier = "%"
-	}
-	statement, err := db.Prepare(getSampleCountTmpl)
+	return sampleCount(db, &FilterOptions{SDR: source, Identifier: identifier, StartFreq: startFreq, EndFreq: endFreq, StartTime: startTime, EndTime: endTime})
+}
+
+func GetMaxImageHeight(db *sql.DB, source, identifier string, startFreq, endFreq int64, startTime, endTime time.Time) (int, error) {
+	return maxImageHeight(db, &FilterOptions{SDR: source, Identifier: identifier, StartFreq: startFreq, EndFreq: endFreq, StartTime: startTime, EndTime: endTime})
+}
+
+func GetMaxImageWidth(db *sql.DB, source, identifier string, startFreq, endFreq int64, startTime, endTime time.Time) (int, error) {
+	return maxImageWidth(db, &FilterOptions{SDR: source, Identifier: identifier, StartFreq: startFreq, EndFreq: endFreq, StartTime: startTime, EndTime: endTime})
+}
+
+func sampleCount(db *sql.DB, filter *FilterOptions) (int, error) {
+	condition, conditionArgs := sampleFilter(filter)
+	statement, err := db.Prepare(fmt.Sprintf(getSampleCountTmpl, condition))
 	if err != nil {
 		return 0, err
 	}
+	args := append(append([]any{filter.SDR}, conditionArgs...), filter.StartFreq, filter.EndFreq, filter.StartTime.UnixMilli(), filter.EndTime.UnixMilli())
 	var count int
-	return count, statement.QueryRow(source, identifier, startFreq, endFreq, startTime.UnixMilli(), endTime.UnixMilli()).Scan(&count)
+	return count, statement.QueryRow(args...).Scan(&count)
 }
 
-func GetMaxImageHeight(db *sql.DB, source, identifier string, startFreq, endFreq int64, startTime, endTime time.Time) (int, error) {
-	if identifier == "" {
-		identifier = "%"
-	}
-	statement, err := db.Prepare(getTimeResolutionTmpl)
+func maxImageHeight(db *sql.DB, filter *FilterOptions) (int, error) {
+	condition, conditionArgs := sampleFilter(filter)
+	statement, err := db.Prepare(fmt.Sprintf(getTimeResolutionTmpl, condition, condition))
 	if err != nil {
 		return 0, err
 	}
+	args := append(append([]any{filter.SDR}, conditionArgs...), filter.StartFreq, filter.EndFreq, filter.StartTime.UnixMilli(), filter.EndTime.UnixMilli())
+	args = append(append(append(args, filter.SDR), conditionArgs...), filter.StartTime.UnixMilli(), filter.EndTime.UnixMilli())
 	var count int
-	return count, statement.QueryRow(source, identifier, startFreq, endFreq, startTime.UnixMilli(), endTime.UnixMilli(), source, identifier, startTime.UnixMilli(), endTime.UnixMilli()).Scan(&count)
+	return count, statement.QueryRow(args...).Scan(&count)
 }
 
-func GetMaxImageWidth(db *sql.DB, source, identifier string, startFreq, endFreq int64, startTime, endTime time.Time) (int, error) {
-	if identifier == "" {
-		identifier = "%"
-	}
-	statement, err := db.Prepare(getFreqResolutionTmpl)
+func maxImageWidth(db *sql.DB, filter *FilterOptions) (int, error) {
+	condition, conditionArgs := sampleFilter(filter)
+	statement, err := db.Prepare(fmt.Sprintf(getFreqResolutionTmpl, condition))
 	if err != nil {
 		return 0, err
 	}
+	args := append(append([]any{filter.SDR}, conditionArgs...), filter.StartFreq, filter.EndFreq, filter.StartTime.UnixMilli(), filter.EndTime.UnixMilli())
 	var count int
-	return count, statement.QueryRow(source, identifier, startFreq, endFreq, startTime.UnixMilli(), endTime.UnixMilli()).Scan(&count)
+	return count, statement.QueryRow(args...).Scan(&count)
+}
+
+// sampleFilter returns the SQL condition and its arguments selecting the samples of the
+// identifiers and tags of the filter.
+func sampleFilter(filter *FilterOptions) (string, []any) {
+	condition, args := identifierFilter(filter.Identifier)
+	if len(filter.Tags) == 0 {
+		return condition, args
+	}
+	tagCondition, tagArgs := tagFilter(filter.Tags)
+	return condition + " AND " + tagCondition, append(args, tagArgs...)
+}
+
+// tagFilter returns the SQL condition and its arguments selecting the samples having all
+// tags in their metadata. Metadata is stored as JSON object with sorted keys and without
+// whitespace (see export package), so each tag is matched as "key":"value" substring. This
+// works the same in all SQL dialects, unlike their JSON functions.
+func tagFilter(tags map[string]string) (string, []any) {
+	var keys []string
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var conditions []string
+	var args []any
+	for _, key := range keys {
+		// Marshalling strings can't fail.
+		encodedKey, _ := json.Marshal(key)
+		encodedValue, _ := json.Marshal(tags[key])
+		conditions = append(conditions, "Metadata LIKE ? ESCAPE '!'")
+		args = append(args, "%"+likeEscaper.Replace(string(encodedKey)+":"+string(encodedValue))+"%")
+	}
+	return "(" + strings.Join(conditions, " AND ") + ")", args
+}
+
+// likeEscaper escapes the LIKE wildcards with the escape character used by tagFilter.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// identifierFilter returns the SQL condition and its arguments selecting the samples of the
+// comma separated identifiers. Identifiers containing LIKE wildcards (% or _) are matched as
+// patterns, the others exactly. An empty list selects all identifiers.
+func identifierFilter(identifier string) (string, []any) {
+	var exact, patterns []any
+	for _, id := range strings.Split(identifier, ",") {
+		id = strings.TrimSpace(id)
+		switch {
+		case id == "":
+			continue
+		case strings.ContainsAny(id, "%_"):
+			patterns = append(patterns, id)
+		default:
+			exact = append(exact, id)
+		}
+	}
+	var conditions []string
+	if len(exact) > 0 {
+		conditions = append(conditions, fmt.Sprintf("Identifier IN (%s)", strings.TrimSuffix(strings.Repeat("?, ", len(exact)), ", ")))
+	}
+	for range patterns {
+		conditions = append(conditions, "Identifier LIKE ?")
+	}
+	if len(conditions) == 0 {
+		return "1 = 1", nil
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", append(exact, patterns...)
+}
+
+// IsValidMetric returns whether the given metric can be rendered.
+func IsValidMetric(metric string) bool {
+	_, ok := metricAggregations[metric]
+	return ok
+}
+
+// IsValidPalette returns whether a palette with the given name exists.
+func IsValidPalette(palette string) bool {
+	_, ok := palettes[palette]
+	return ok
 }
 
-// GetColor determines the color of a pixel based on a color gradient and a pixel "level".
+// GetColor determines the color of a pixel based on a color gradient (palette) and a pixel "level".
+// Unknown palettes fall back to the default gradient.
 // http://www.andrewnoske.com/wiki/Code_-_heatmaps_and_color_gradients
 // This is mostly a copy of https://github.com/finfinack/netmap/blob/master/netmap.go.
-func GetColor(lvl uint16) color.RGBA {
+func GetColor(lvl uint16, palette string) color.RGBA {
+	colors, ok := palettes[palette]
+	if !ok {
+		colors = palettes[PaletteDefault]
+	}
 	// Find the first color in the gradient where the "level" is higher than the level we're looking for.
 	// Then determine how far along we are between the previous and next color in the gradient and use that
 	// to calculate the color between the two.
-	for i := 0; i < len(colors); i++ {
+	steps := len(colors) - 1
+	for i := 1; i < len(colors); i++ {
+		currV := i * math.MaxUint16 / steps
+		if int(lvl) > currV {
+			continue
+		}
+		prevV := (i - 1) * math.MaxUint16 / steps
+		fract := math.Min(1.0, math.Max(0.0, float64(int(lvl)-prevV)/float64(currV-prevV)))
+		prevC := colors[i-1]
 		currC := colors[i]
-		currV := uint16(i * math.MaxUint16 / len(colors))
-		if lvl < currV {
-			prevC := colors[int(math.Max(0.0, float64(i-1)))]
-			diff := uint16(math.Max(0.0, float64(i-1)))*math.MaxUint16/uint16(len(colors)) - currV
-			fract := 0.0
-			if diff != 0 {
-				fract = float64(lvl) - float64(currV)/float64(diff)
-			}
-			return color.RGBA{
-				uint8(float64(prevC.R-currC.R)*fract + float64(currC.R)),
-				uint8(float64(prevC.G-currC.G)*fract + float64(currC.G)),
-				uint8(float64(prevC.B-currC.B)*fract + float64(currC.B)),
-				uint8(float64(prevC.A-currC.A)*fract + float64(currC.A)),
-			}
+		return color.RGBA{
+			interpolate(prevC.R, currC.R, fract),
+			interpolate(prevC.G, currC.G, fract),
+			interpolate(prevC.B, currC.B, fract),
+			interpolate(prevC.A, currC.A, fract),
 		}
 	}
 	return colors[len(colors)-1]
 }
 
+// interpolate returns the value at fract (0-1) of the way from a to b.
+func interpolate(a, b uint8, fract float64) uint8 {
+	return uint8(math.Round(float64(a) + (float64(b)-float64(a))*fract))
+}
+
+// GetReadableFreq formats the frequency with two decimals and the largest suffix which keeps
+// the value at or above 1, e.g. "1.00 kHz" for 1000 Hz.
 func GetReadableFreq(freq int64) string {
 	exp := 0
-	for f := float64(freq); f > 1000; f = f / 1000.0 {
+	f := float64(freq)
+	// Values which would be rounded up to 1000.00 are shown with the next suffix.
+	for ; f >= 999.995 && exp < len(expSuffixLookup)-1; f = f / 1000.0 {
 		exp += 1
 	}
-	suffix, ok := expSuffixLookup[exp]
-	if !ok {
-		return fmt.Sprintf("%d Hz", freq)
+	return fmt.Sprintf("%.2f %s", f, expSuffixLookup[exp])
+}
+
+// GridColors defines the colors of the grid and legend drawn around the waterfall.
+type GridColors struct {
+	// Grid is the color of the ticks.
+	Grid color.RGBA
+	// Text is the color of the labels.
+	Text color.RGBA
+	// Background is the color of the margins around the waterfall.
+	Background color.RGBA
+}
+
+// ParseColor parses a color in hex notation, i.e. "#rrggbb" or "#rrggbbaa".
+func ParseColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 6 {
+		s += "ff"
 	}
-	return fmt.Sprintf("%.2f %s", float64(freq)/math.Pow(1000, float64(exp)), suffix)
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || len(s) != 8 {
+		return color.RGBA{}, fmt.Errorf("%q is not a valid color, use #rrggbb or #rrggbbaa", s)
+	}
+	return color.RGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
 }
 
-func drawTick(canvas *image.RGBA, start image.Point, length int, horizontal bool) {
+func drawTick(canvas *image.RGBA, start image.Point, length int, horizontal bool, c color.RGBA) {
 	for i := 0; i <= length; i++ {
 		if horizontal {
-			canvas.SetRGBA(start.X+i, start.Y, gridColor)
+			canvas.SetRGBA(start.X+i, start.Y, c)
 		} else {
-			canvas.SetRGBA(start.X, start.Y+i, gridColor)
+			canvas.SetRGBA(start.X, start.Y+i, c)
 		}
 	}
 }
 
-func findGridStepSize(step int, horizontal bool) int {
+// findGridStepSize returns the distance in pixels between the ticks on an axis of the given
+// size. The step is a "nice" number (1, 2 or 5 times a power of ten) of at least the minimum
+// step which keeps the number of ticks below gridMaxTicks. Axes too small for two ticks at
+// the minimum step get a tick at the start and the end.
+func findGridStepSize(size int, horizontal bool) int {
 	gridMinStep := gridMinStepY
 	if horizontal {
 		gridMinStep = gridMinStepX
 	}
-	for step > gridMinStep {
-		n := step / 2
-		if n < gridMinStep {
-			return step
+	target := float64(max(gridMinStep, (size+gridMaxTicks-1)/gridMaxTicks))
+	step := 1
+	for decade := math.Pow(10, math.Floor(math.Log10(target))); step == 1; decade *= 10 {
+		for _, m := range []float64{1, 2, 5} {
+			if m*decade >= target {
+				step = int(m * decade)
+				break
+			}
 		}
-		step = n
+	}
+	// Ticks are drawn at multiples of the step smaller than the size.
+	if step > size-1 {
+		return max(size-1, 1)
 	}
 	return step
 }
 
-func DrawGrid(source *image.RGBA, lowFreq, highFreq int64, startTime, endTime time.Time) *image.RGBA {
-	// Enlarge existing image.
-	canvas := image.NewRGBA(image.Rectangle{
-		Min: image.Point{source.Bounds().Min.X, source.Bounds().Min.Y},
-		Max: image.Point{source.Bounds().Max.X - 1 + gridMarginLeft, source.Bounds().Max.Y - 1 + gridMarginTop},
-	})
-	draw.Draw(canvas, canvas.Bounds(), &image.Uniform{gridBackgroundColor}, canvas.Bounds().Min, draw.Src)
-	r := canvas.Bounds()
-	r.Min.X += gridMarginLeft
-	r.Min.Y += gridMarginTop
-	draw.Draw(canvas, r, source, source.Bounds().Min, draw.Src)
+// freqTick is a labelled tick on the frequency axis.
+type freqTick struct {
+	x    int
+	freq int64
+}
 
-	// Draw grid.
+// freqTicks returns the position and frequency of the ticks on the frequency axis.
+// On a logarithmic axis, ticks are placed at 1, 2 and 5 times powers of ten if the
+// range is wide enough and evenly spaced otherwise.
+func freqTicks(lowFreq, highFreq int64, width int, logFreq bool) []freqTick {
+	if logFreq {
+		if ticks := logFreqTicks(lowFreq, highFreq, width); len(ticks) > 2 {
+			return ticks
+		}
+	}
 
-	// Draw X ticks.
-	xStep := findGridStepSize(source.Bounds().Max.X, true)
-	for i := source.Bounds().Min.X; i < source.Bounds().Max.X; i += xStep {
+	var ticks []freqTick
+	step := findGridStepSize(width, true)
+	for i := 0; i < width; i += step {
+		freq := lowFreq + ((int64(i) * (highFreq - lowFreq)) / int64(width))
+		if logFreq {
+			freq = int64(float64(lowFreq) * math.Pow(float64(highFreq)/float64(lowFreq), float64(i)/float64(width)))
+		}
+		ticks = append(ticks, freqTick{i, freq})
+	}
+	return ticks
+}
+
+// logFreqTicks returns ticks at 1, 2 and 5 times powers of ten on a logarithmic axis.
+func logFreqTicks(lowFreq, highFreq int64, width int) []freqTick {
+	ticks := []freqTick{{0, lowFreq}}
+	for decade := math.Pow(10, math.Floor(math.Log10(float64(lowFreq)))); decade <= float64(highFreq); decade *= 10 {
+		for _, m := range []float64{1, 2, 5} {
+			freq := m * decade
+			if freq <= float64(lowFreq) || freq > float64(highFreq) {
+				continue
+			}
+			x := logFreqPosition(freq, lowFreq, highFreq, width)
+			if x-ticks[len(ticks)-1].x < gridMinStepX || x >= width {
+				continue
+			}
+			ticks = append(ticks, freqTick{x, int64(freq)})
+		}
+	}
+	return ticks
+}
+
+// logFreqPosition returns the X position of a frequency on a logarithmic axis.
+func logFreqPosition(freq float64, lowFreq, highFreq int64, width int) int {
+	return int(float64(width) * math.Log(freq/float64(lowFreq)) / math.Log(float64(highFreq)/float64(lowFreq)))
+}
+
+// drawFreqTicks draws and labels the ticks of the frequency axis above the area of the given
+// width starting at the grid margins of the canvas.
+func drawFreqTicks(canvas *image.RGBA, lowFreq, highFreq int64, width int, logFreq bool, colors GridColors) {
+	for _, tick := range freqTicks(lowFreq, highFreq, width, logFreq) {
 		// Draw the tick.
 		drawTick(canvas, image.Point{
-			canvas.Bounds().Min.X + gridMarginLeft + i,
+			canvas.Bounds().Min.X + gridMarginLeft + tick.x,
 			canvas.Bounds().Min.Y + gridMarginTop - gridTickLen,
-		}, gridTickLen, false)
+		}, gridTickLen, false, colors.Grid)
 		// Label the tick.
 		point := fixed.Point26_6{
-			X: fixed.Int26_6((canvas.Bounds().Min.X + gridMarginLeft + i + 5) * 64),
+			X: fixed.Int26_6((canvas.Bounds().Min.X + gridMarginLeft + tick.x + 5) * 64),
 			Y: fixed.Int26_6((canvas.Bounds().Min.Y + gridMarginTop - 2) * 64),
 		}
 		d := &font.Drawer{
 			Dst:  canvas,
-			Src:  image.NewUniform(gridColor),
+			Src:  image.NewUniform(colors.Text),
 			Face: basicfont.Face7x13,
 			Dot:  point,
 		}
-		freq := lowFreq + ((int64(i) * (highFreq - lowFreq)) / int64(source.Bounds().Max.X))
-		d.DrawString(GetReadableFreq(freq))
+		d.DrawString(GetReadableFreq(tick.freq))
 	}
+}
+
+// DrawGrid enlarges the image to the top and left and draws the frequency and time axes as well
+// as the markers within the frequency range.
+func DrawGrid(source *image.RGBA, lowFreq, highFreq int64, logFreq bool, startTime, endTime time.Time, markers []Marker, colors GridColors) *image.RGBA {
+	// Enlarge existing image.
+	canvas := image.NewRGBA(image.Rectangle{
+		Min: image.Point{source.Bounds().Min.X, source.Bounds().Min.Y},
+		Max: image.Point{source.Bounds().Max.X - 1 + gridMarginLeft, source.Bounds().Max.Y - 1 + gridMarginTop},
+	})
+	draw.Draw(canvas, canvas.Bounds(), &image.Uniform{colors.Background}, canvas.Bounds().Min, draw.Src)
+	r := canvas.Bounds()
+	r.Min.X += gridMarginLeft
+	r.Min.Y += gridMarginTop
+	draw.Draw(canvas, r, source, source.Bounds().Min, draw.Src)
+
+	// Draw grid.
+
+	// Draw X ticks.
+	drawFreqTicks(canvas, lowFreq, highFreq, source.Bounds().Dx(), logFreq, colors)
+	drawMarkers(canvas, markers, lowFreq, highFreq, source.Bounds().Dx(), source.Bounds().Dy(), logFreq, colors)
 
 	// Draw Y ticks.
 	yStep := findGridStepSize(source.Bounds().Max.Y, false)
@@ -278,7 +571,7 @@ func DrawGrid(source *image.RGBA, lowFreq, highFreq int64, startTime, endTime ti
 		drawTick(canvas, image.Point{
 			canvas.Bounds().Min.X + gridMarginLeft - gridTickLen,
 			canvas.Bounds().Min.Y + gridMarginTop + i,
-		}, gridTickLen, true)
+		}, gridTickLen, true, colors.Grid)
 		// Label the tick.
 		timePoint := fixed.Point26_6{
 			X: fixed.Int26_6((canvas.Bounds().Min.X + 5) * 64),
@@ -286,7 +579,7 @@ func DrawGrid(source *image.RGBA, lowFreq, highFreq int64, startTime, endTime ti
 		}
 		timeDrawer := &font.Drawer{
 			Dst:  canvas,
-			Src:  image.NewUniform(gridColor),
+			Src:  image.NewUniform(colors.Text),
 			Face: basicfont.Face7x13,
 			Dot:  timePoint,
 		}
@@ -296,7 +589,7 @@ func DrawGrid(source *image.RGBA, lowFreq, highFreq int64, startTime, endTime ti
 		}
 		durDrawer := &font.Drawer{
 			Dst:  canvas,
-			Src:  image.NewUniform(gridColor),
+			Src:  image.NewUniform(colors.Text),
 			Face: basicfont.Face7x13,
 			Dot:  durPoint,
 		}
@@ -309,25 +602,146 @@ func DrawGrid(source *image.RGBA, lowFreq, highFreq int64, startTime, endTime ti
 	return canvas
 }
 
+// DrawColorbar enlarges the image to the right and draws a vertical color scale annotated
+// with the dB values the palette colors correspond to. marginTop defines how many pixels
+// at the top of the source image are not part of the waterfall (e.g. grid labels).
+func DrawColorbar(source *image.RGBA, minDB, maxDB float32, palette string, marginTop int, colors GridColors) *image.RGBA {
+	// Enlarge existing image.
+	bounds := source.Bounds()
+	canvas := image.NewRGBA(image.Rectangle{
+		Min: bounds.Min,
+		Max: image.Point{bounds.Max.X + legendMarginLeft + legendWidth + legendLabelWidth, bounds.Max.Y},
+	})
+	draw.Draw(canvas, canvas.Bounds(), &image.Uniform{colors.Background}, canvas.Bounds().Min, draw.Src)
+	draw.Draw(canvas, bounds, source, bounds.Min, draw.Src)
+
+	height := bounds.Dy() - marginTop
+	if height <= 0 {
+		return canvas
+	}
+	top := bounds.Min.Y + marginTop
+	left := bounds.Max.X + legendMarginLeft
+
+	// Draw color scale, warmest color at the top.
+	for y := 0; y < height; y++ {
+		lvl := uint16(math.MaxUint16 - (y*math.MaxUint16)/max(height-1, 1))
+		c := GetColor(lvl, palette)
+		for x := left; x < left+legendWidth; x++ {
+			canvas.SetRGBA(x, top+y, c)
+		}
+	}
+
+	// Draw and label ticks.
+	step := findGridStepSize(height, false)
+	for y := 0; y < height; y += step {
+		drawTick(canvas, image.Point{left + legendWidth, top + y}, gridTickLen/2, true, colors.Grid)
+		point := fixed.Point26_6{
+			X: fixed.Int26_6((left + legendWidth + gridTickLen/2 + 3) * 64),
+			Y: fixed.Int26_6((top + y + 5) * 64),
+		}
+		d := &font.Drawer{
+			Dst:  canvas,
+			Src:  image.NewUniform(colors.Text),
+			Face: basicfont.Face7x13,
+			Dot:  point,
+		}
+		db := maxDB - float32(y)*(maxDB-minDB)/float32(max(height-1, 1))
+		d.DrawString(fmt.Sprintf("%.1f dB", db))
+	}
+
+	return canvas
+}
+
 type FilterOptions struct {
-	SDR        string
-	Identifier string
-	StartFreq  int64
-	EndFreq    int64
-	StartTime  time.Time
-	EndTime    time.Time
+	SDR        string    `json:"sdr"`
+	Identifier string    `json:"identifier"`
+	StartFreq  int64     `json:"startFreq"`
+	EndFreq    int64     `json:"endFreq"`
+	StartTime  time.Time `json:"startTime"`
+	EndTime    time.Time `json:"endTime"`
+	// Tags optionally restricts the samples to those having all of these key/value pairs
+	// in their metadata.
+	Tags map[string]string `json:"tags"`
 }
 
 type ImageOptions struct {
-	Height int
-	Width  int
+	Height int `json:"imgHeight"`
+	Width  int `json:"imgWidth"`
+
+	// Mode selects what to render (see Mode* constants), defaults to ModeWaterfall.
+	Mode string `json:"mode"`
+
+	// Palette is the name of the color gradient to use (see Palette* constants).
+	Palette string `json:"palette"`
+	// Metric selects which dB value of the samples is rendered (see Metric* constants).
+	// Defaults to the highest dB value in each bucket.
+	Metric string `json:"metric"`
+
+	// MinDB and MaxDB optionally define the dB range the palette is scaled to.
+	// When unset, the minimum and maximum dB found in the selected samples is used.
+	MinDB *float64 `json:"minDB"`
+	MaxDB *float64 `json:"maxDB"`
+
+	AddGrid bool `json:"addGrid"`
+	// LogFreqAxis renders the frequency axis logarithmically instead of linearly.
+	LogFreqAxis bool `json:"logFreq"`
+	// AddLegend adds a color scale with the corresponding dB values to the right of the image.
+	AddLegend bool `json:"addLegend"`
+	// Markers are drawn as labelled vertical lines together with the grid. Markers outside of
+	// the rendered frequency range are skipped.
+	Markers []Marker `json:"markers"`
+	// PeakHold overlays a line with the highest dB per frequency across the whole time window
+	// in the grid color, from the lowest dB of the range at the bottom to the highest at the top.
+	PeakHold bool `json:"peakHold"`
+	// WeightBySampleCount dims the waterfall where buckets aggregate fewer measurements than the
+	// bucket with the most, so sparsely sampled regions stand out from well sampled ones.
+	WeightBySampleCount bool `json:"weightBySampleCount"`
+	// SmoothKernel is the size of the window (e.g. 3 for 3x3 buckets) the dB values are
+	// averaged over before rendering to reduce the noise between adjacent buckets. It needs
+	// to be odd, 0 or 1 disable smoothing.
+	SmoothKernel int `json:"smoothKernel"`
+	// Quality of JPEG images (1-100), defaults to jpeg.DefaultQuality.
+	Quality int `json:"quality"`
+	// Location is the timezone the time axis is labelled in, defaults to UTC.
+	Location *time.Location `json:"-"`
+	// Colors of the grid and legend, defaults to DefaultGridColors.
+	Colors *GridColors `json:"-"`
+	// TransparentBackground draws the background of the grid and legend transparent.
+	// This is only useful for image formats supporting transparency such as PNG.
+	TransparentBackground bool `json:"transparent"`
+}
 
-	AddGrid bool
+// gridColors returns the colors to draw the grid and legend with.
+func (o *ImageOptions) gridColors() GridColors {
+	colors := DefaultGridColors
+	if o.Colors != nil {
+		colors = *o.Colors
+	}
+	if o.TransparentBackground {
+		colors.Background = gridTransparentBackgroundColor
+	}
+	return colors
+}
+
+// ErrNoSamples is returned when no samples match the filters of a render request.
+var ErrNoSamples = errors.New("no samples found for the given filters")
+
+// ErrLimitExceeded is returned (wrapped) when a render request exceeds its RenderLimits.
+var ErrLimitExceeded = errors.New("render limit exceeded")
+
+// RenderLimits caps the resources a render request can use. Zero values disable a limit.
+type RenderLimits struct {
+	// MaxPixels is the maximum size of the waterfall (width * height) in pixels.
+	MaxPixels int
+	// MaxSamples is the maximum number of samples the filters may select from the DB.
+	MaxSamples int64
 }
 
 type RenderRequest struct {
 	Filter *FilterOptions
 	Image  *ImageOptions
+	// Limits is optional, nothing is limited if unset.
+	Limits *RenderLimits
 }
 
 type SourceMetadata struct {
@@ -342,45 +756,117 @@ type RenderMetadata struct {
 	ImageWidth   int
 	FreqPerPixel float64
 	SecPerPixel  float64
+	// RequestedHeight and RequestedWidth are the dimensions asked for in the request, 0 if
+	// they were derived from the data.
+	RequestedHeight int
+	RequestedWidth  int
+	// Clamped is set if the requested dimensions were reduced to the resolution the data in
+	// the DB can provide, i.e. the image is smaller than asked for.
+	Clamped bool
 }
 
 type RenderResult struct {
 	Image image.Image
+	// SVG holds the rendered document when rendered with RenderSVG (Image is nil then).
+	SVG []byte
 
 	SourceMeta *SourceMetadata
 	ImageMeta  *RenderMetadata
 }
 
-func Render(db *sql.DB, req *RenderRequest) (*RenderResult, error) {
-	identifier := req.Filter.Identifier
-	if identifier == "" {
-		identifier = "%"
+// waterfall holds the aggregated dB value per time (row) and frequency (column) bucket.
+type waterfall struct {
+	dbs map[int]map[int]float32
+	// counts holds the number of measurements aggregated in each bucket, maxCount the highest one.
+	counts   map[int]map[int]int64
+	maxCount int64
+	// freqCenters holds the center frequency of each frequency bucket.
+	freqCenters map[int]float64
+	// minDB and maxDB define the dB range the palette is scaled to.
+	minDB float32
+	maxDB float32
+	meta  *SourceMetadata
+	// requestedHeight and requestedWidth are the dimensions asked for before clamping them
+	// to the resolution of the data.
+	requestedHeight int
+	requestedWidth  int
+	clamped         bool
+}
+
+// queryWaterfall validates the request, determines the image dimensions and loads the
+// aggregated dB values of all buckets from the DB.
+func queryWaterfall(db *sql.DB, req *RenderRequest) (*waterfall, error) {
+	if req.Image.Palette == "" {
+		req.Image.Palette = PaletteDefault
+	}
+	if !IsValidPalette(req.Image.Palette) {
+		return nil, fmt.Errorf("unknown palette %q", req.Image.Palette)
+	}
+	if req.Image.Mode == "" {
+		req.Image.Mode = ModeWaterfall
+	}
+	if !IsValidMode(req.Image.Mode) {
+		return nil, fmt.Errorf("unknown mode %q", req.Image.Mode)
+	}
+	if req.Image.Metric == "" {
+		req.Image.Metric = MetricHigh
+		if req.Image.Mode == ModeSpectrum {
+			req.Image.Metric = MetricAvg
+		}
+	}
+	if !IsValidMetric(req.Image.Metric) {
+		return nil, fmt.Errorf("unknown metric %q", req.Image.Metric)
+	}
+	if req.Image.MinDB != nil && req.Image.MaxDB != nil && *req.Image.MinDB >= *req.Image.MaxDB {
+		return nil, fmt.Errorf("minDB (%f) needs to be lower than maxDB (%f)", *req.Image.MinDB, *req.Image.MaxDB)
+	}
+	if req.Image.SmoothKernel < 0 || (req.Image.SmoothKernel > 1 && req.Image.SmoothKernel%2 == 0) {
+		return nil, fmt.Errorf("smooth kernel size (%d) needs to be an odd number", req.Image.SmoothKernel)
 	}
 
-	count, err := GetSampleCount(db, req.Filter.SDR, identifier, req.Filter.StartFreq, req.Filter.EndFreq, req.Filter.StartTime, req.Filter.EndTime)
+	count, err := sampleCount(db, req.Filter)
 	if err != nil {
 		return nil, fmt.Errorf("unable to get sample count from DB: %s", err)
 	}
 	if count == 0 {
-		return nil, errors.New("there are no samples in the DB matching the given filters")
+		return nil, ErrNoSamples
 	}
-
-	maxImgHeight, err := GetMaxImageHeight(db, req.Filter.SDR, identifier, req.Filter.StartFreq, req.Filter.EndFreq, req.Filter.StartTime, req.Filter.EndTime)
-	if err != nil {
-		return nil, fmt.Errorf("unable to query sqlite DB to determine image height: %s", err)
+	// Coarse waterfalls are rendered from the rollup which is faster and selects fewer rows.
+	table := sampleTable
+	if rollupCount, ok := useRollup(db, req); ok {
+		table = rollupTable
+		count = rollupCount
 	}
-	switch {
-	case maxImgHeight == 0:
-		return nil, errors.New("unable to determine optimal/maximal image height")
-	case req.Image.Height == 0:
-		req.Image.Height = maxImgHeight
-	case req.Image.Height > 0 && req.Image.Height > maxImgHeight:
-		glog.Warningf("-imgHeight is set to %d which is more than what the data in the sqlite DB can provide. Reducing image height to %d pixels\n", req.Image.Height, maxImgHeight)
-		req.Image.Height = maxImgHeight
+	if req.Limits != nil && req.Limits.MaxSamples > 0 && int64(count) > req.Limits.MaxSamples {
+		return nil, fmt.Errorf("%w: the filters select %d samples which is more than the maximum of %d, narrow down the time or frequency range", ErrLimitExceeded, count, req.Limits.MaxSamples)
+	}
+
+	requestedHeight, requestedWidth := req.Image.Height, req.Image.Width
+	var clamped bool
+	// The height of a spectrum plot doesn't depend on the number of samples over time.
+	if req.Image.Mode == ModeSpectrum {
+		if req.Image.Height == 0 {
+			req.Image.Height = spectrumDefaultHeight
+		}
+	} else {
+		maxImgHeight, err := maxImageHeight(db, req.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("unable to query DB to determine image height: %s", err)
+		}
+		switch {
+		case maxImgHeight == 0:
+			return nil, errors.New("unable to determine optimal/maximal image height")
+		case req.Image.Height == 0:
+			req.Image.Height = maxImgHeight
+		case req.Image.Height > 0 && req.Image.Height > maxImgHeight:
+			slog.Warn("image height is more than what the data in the DB can provide, reducing it", "requested", req.Image.Height, "height", maxImgHeight)
+			req.Image.Height = maxImgHeight
+			clamped = true
+		}
 	}
-	maxImgWidth, err := GetMaxImageWidth(db, req.Filter.SDR, identifier, req.Filter.StartFreq, req.Filter.EndFreq, req.Filter.StartTime, req.Filter.EndTime)
+	maxImgWidth, err := maxImageWidth(db, req.Filter)
 	if err != nil {
-		return nil, fmt.Errorf("unable to query sqlite DB to determine image width: %s", err)
+		return nil, fmt.Errorf("unable to query DB to determine image width: %s", err)
 	}
 	switch {
 	case maxImgWidth == 0:
@@ -388,15 +874,28 @@ func Render(db *sql.DB, req *RenderRequest) (*RenderResult, error) {
 	case req.Image.Width == 0:
 		req.Image.Width = maxImgWidth
 	case req.Image.Width > 0 && req.Image.Width > maxImgWidth:
-		glog.Warningf("-imgWidth is set to %d which is more than what the data in the sqlite DB can provide. Reducing image width to %d pixels\n", req.Image.Width, maxImgWidth)
+		slog.Warn("image width is more than what the data in the DB can provide, reducing it", "requested", req.Image.Width, "width", maxImgWidth)
 		req.Image.Width = maxImgWidth
+		clamped = true
+	}
+	// Fail before querying the samples and allocating the image.
+	if req.Limits != nil && req.Limits.MaxPixels > 0 && req.Image.Width*req.Image.Height > req.Limits.MaxPixels {
+		return nil, fmt.Errorf("%w: the image would be %d x %d pixels which is more than the maximum of %d pixels, reduce the image width or height", ErrLimitExceeded, req.Image.Width, req.Image.Height, req.Limits.MaxPixels)
 	}
 
-	statement, err := db.Prepare(getImgDataTmpl)
+	tmpl := getImgDataTmpl
+	bucketArgs := []any{req.Image.Height, req.Image.Width}
+	if req.Image.Mode == ModeSpectrum {
+		tmpl = getSpectrumDataTmpl
+		bucketArgs = []any{req.Image.Width}
+	}
+	condition, conditionArgs := sampleFilter(req.Filter)
+	statement, err := db.Prepare(fmt.Sprintf(tmpl, metricAggregations[req.Image.Metric], table, condition))
 	if err != nil {
 		return nil, err
 	}
-	imgData, err := statement.Query(req.Image.Height, req.Image.Width, req.Filter.SDR, identifier, req.Filter.StartFreq, req.Filter.EndFreq, req.Filter.StartTime.UnixMilli(), req.Filter.EndTime.UnixMilli())
+	args := append(append(append(bucketArgs, req.Filter.SDR), conditionArgs...), req.Filter.StartFreq, req.Filter.EndFreq, req.Filter.StartTime.UnixMilli(), req.Filter.EndTime.UnixMilli())
+	imgData, err := statement.Query(args...)
 	if err != nil {
 		return nil, err
 	}
@@ -409,14 +908,18 @@ func Render(db *sql.DB, req *RenderRequest) (*RenderResult, error) {
 	var eTime time.Time
 
 	img := map[int]map[int]float32{}
+	counts := map[int]map[int]int64{}
+	var maxCount int64
+	freqCenters := map[int]float64{}
 	for imgData.Next() {
 		var freqLow, freqHigh int64
 		var timeStart, timeEnd int64
 		var freqCenter float64
 		var db float32
+		var sampleCount sql.NullInt64
 		var rowIdx, colIdx int
-		if err := imgData.Scan(&freqLow, &freqCenter, &freqHigh, &db, &timeStart, &timeEnd, &rowIdx, &colIdx); err != nil {
-			glog.Warningf("unable to get sample from DB: %s\n", err)
+		if err := imgData.Scan(&freqLow, &freqCenter, &freqHigh, &db, &timeStart, &timeEnd, &sampleCount, &rowIdx, &colIdx); err != nil {
+			slog.Warn("unable to get sample from DB", "error", err)
 			continue
 		}
 
@@ -444,10 +947,172 @@ func Render(db *sql.DB, req *RenderRequest) (*RenderResult, error) {
 
 		if _, ok := img[rowIdx]; !ok {
 			img[rowIdx] = map[int]float32{}
+			counts[rowIdx] = map[int]int64{}
 		}
 		img[rowIdx][colIdx] = db
+		counts[rowIdx][colIdx] = sampleCount.Int64
+		maxCount = max(maxCount, sampleCount.Int64)
+		if _, ok := freqCenters[colIdx]; !ok {
+			freqCenters[colIdx] = freqCenter
+		}
 	}
 	imgData.Close()
+	// Samples can disappear between counting and querying them, e.g. when they are pruned.
+	if len(img) == 0 {
+		return nil, ErrNoSamples
+	}
+
+	if req.Image.SmoothKernel > 1 {
+		img = smooth(img, req.Image.SmoothKernel)
+		// Smoothing narrows the dB range, scale the palette to the smoothed values.
+		globalMinDB, globalMaxDB = float32(1000), float32(-1000)
+		for _, row := range img {
+			for _, db := range row {
+				globalMinDB = min(globalMinDB, db)
+				globalMaxDB = max(globalMaxDB, db)
+			}
+		}
+	}
+
+	minDB := globalMinDB
+	if req.Image.MinDB != nil {
+		minDB = float32(*req.Image.MinDB)
+	}
+	maxDB := globalMaxDB
+	if req.Image.MaxDB != nil {
+		maxDB = float32(*req.Image.MaxDB)
+	}
+
+	if req.Image.LogFreqAxis && (lowFreq <= 0 || highFreq <= lowFreq) {
+		return nil, fmt.Errorf("a logarithmic frequency axis needs a frequency range above 0 Hz, got %d - %d Hz", lowFreq, highFreq)
+	}
+
+	loc := time.UTC
+	if req.Image.Location != nil {
+		loc = req.Image.Location
+	}
+
+	return &waterfall{
+		dbs:         img,
+		counts:      counts,
+		maxCount:    maxCount,
+		freqCenters: freqCenters,
+		minDB:       minDB,
+		maxDB:       maxDB,
+		meta: &SourceMetadata{
+			LowFreq:   lowFreq,
+			HighFreq:  highFreq,
+			StartTime: sTime.In(loc),
+			EndTime:   eTime.In(loc),
+		},
+		requestedHeight: requestedHeight,
+		requestedWidth:  requestedWidth,
+		clamped:         clamped,
+	}, nil
+}
+
+// level scales a dB value to the palette, clamping values outside of the range. All values
+// map to the middle of the palette if the range is empty, e.g. when all samples have the same dB.
+func (w *waterfall) level(db float32) uint16 {
+	if w.maxDB <= w.minDB {
+		return math.MaxUint16 / 2
+	}
+	db = float32(math.Min(float64(w.maxDB), math.Max(float64(w.minDB), float64(db))))
+	return uint16((db - w.minDB) * math.MaxUint16 / (w.maxDB - w.minDB))
+}
+
+// color returns the palette color of the bucket's dB value. With WeightBySampleCount, the color
+// is dimmed by the number of measurements in the bucket relative to the bucket with the most.
+func (w *waterfall) color(rowIdx, colIdx int, db float32, opts *ImageOptions) color.RGBA {
+	c := GetColor(w.level(db), opts.Palette)
+	if !opts.WeightBySampleCount || w.maxCount <= 0 {
+		return c
+	}
+	weight := float64(w.counts[rowIdx][colIdx]) / float64(w.maxCount)
+	return color.RGBA{
+		uint8(float64(c.R) * weight),
+		uint8(float64(c.G) * weight),
+		uint8(float64(c.B) * weight),
+		c.A,
+	}
+}
+
+// columns returns the frequency bucket to draw for each pixel column of the image. Buckets are
+// numbered starting at 1. On a logarithmic axis, the bucket closest to the frequency of
+// the pixel is used which stretches the lower and compresses the higher frequencies.
+func (w *waterfall) columns(width int, logFreq bool) []int {
+	columns := make([]int, width)
+	if !logFreq {
+		for x := range columns {
+			columns[x] = x + 1
+		}
+		return columns
+	}
+
+	buckets := make([]int, 0, len(w.freqCenters))
+	for bucket := range w.freqCenters {
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+	ratio := float64(w.meta.HighFreq) / float64(w.meta.LowFreq)
+	for x := range columns {
+		freq := float64(w.meta.LowFreq) * math.Pow(ratio, (float64(x)+0.5)/float64(width))
+		i := sort.Search(len(buckets), func(i int) bool { return w.freqCenters[buckets[i]] >= freq })
+		if i == len(buckets) || (i > 0 && freq-w.freqCenters[buckets[i-1]] < w.freqCenters[buckets[i]]-freq) {
+			i--
+		}
+		columns[x] = buckets[i]
+	}
+	return columns
+}
+
+// peakHold returns the Y position of the highest dB across all rows per pixel column, scaled
+// to the height with the highest dB of the range at the top. Columns without samples are -1.
+func (w *waterfall) peakHold(columns []int, height int) []int {
+	peaks := map[int]float32{}
+	for _, row := range w.dbs {
+		for colIdx, db := range row {
+			if peak, ok := peaks[colIdx]; !ok || db > peak {
+				peaks[colIdx] = db
+			}
+		}
+	}
+	ys := make([]int, len(columns))
+	for x, colIdx := range columns {
+		peak, ok := peaks[colIdx]
+		if !ok {
+			ys[x] = -1
+			continue
+		}
+		ys[x] = int(math.Round(float64(height-1) * (1 - float64(w.level(peak))/math.MaxUint16)))
+	}
+	return ys
+}
+
+// drawPeakHold draws the peak hold line, connecting adjacent columns with vertical segments.
+func drawPeakHold(canvas *image.RGBA, ys []int, c color.RGBA) {
+	for x, y := range ys {
+		if y < 0 {
+			continue
+		}
+		from, to := y, y
+		if x > 0 && ys[x-1] >= 0 {
+			from, to = min(y, ys[x-1]), max(y, ys[x-1])
+		}
+		for i := from; i <= to; i++ {
+			canvas.SetRGBA(x, i, c)
+		}
+	}
+}
+
+func Render(db *sql.DB, req *RenderRequest) (*RenderResult, error) {
+	wf, err := queryWaterfall(db, req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Image.Mode == ModeSpectrum {
+		return renderSpectrum(wf, req), nil
+	}
 
 	// Create image canvas.
 	canvas := image.NewRGBA(image.Rectangle{
@@ -455,41 +1120,73 @@ func Render(db *sql.DB, req *RenderRequest) (*RenderResult, error) {
 		Max: image.Point{req.Image.Width, req.Image.Height},
 	})
 
-	// Draw waterfall.
-	dbRange := globalMaxDB - globalMinDB
-	minlvl := uint16(math.MaxUint16)
-	maxlvl := uint16(0)
-	for rowIdx, row := range img {
-		for columnIdx, db := range row {
-			lvl := uint16((db - globalMinDB) * math.MaxUint16 / dbRange)
-			if lvl < minlvl {
-				minlvl = lvl
-			}
-			if lvl > maxlvl {
-				maxlvl = lvl
-			}
-			canvas.SetRGBA(columnIdx, rowIdx, GetColor(lvl))
-		}
+	// Draw waterfall. Buckets are numbered starting at 1.
+	columns := wf.columns(req.Image.Width, req.Image.LogFreqAxis)
+	wf.draw(canvas, columns, req.Image)
+
+	colors := req.Image.gridColors()
+
+	// Draw peak hold on top of the waterfall.
+	if req.Image.PeakHold {
+		drawPeakHold(canvas, wf.peakHold(columns, req.Image.Height), colors.Grid)
 	}
 
 	// Draw grid.
 	if req.Image.AddGrid {
-		canvas = DrawGrid(canvas, lowFreq, highFreq, sTime, eTime)
+		canvas = DrawGrid(canvas, wf.meta.LowFreq, wf.meta.HighFreq, req.Image.LogFreqAxis, wf.meta.StartTime, wf.meta.EndTime, req.Image.Markers, colors)
+	}
+
+	// Draw legend.
+	if req.Image.AddLegend {
+		marginTop := 0
+		if req.Image.AddGrid {
+			marginTop = gridMarginTop
+		}
+		canvas = DrawColorbar(canvas, wf.minDB, wf.maxDB, req.Image.Palette, marginTop, colors)
 	}
 
 	return &RenderResult{
-		Image: canvas,
-		SourceMeta: &SourceMetadata{
-			LowFreq:   lowFreq,
-			HighFreq:  highFreq,
-			StartTime: sTime,
-			EndTime:   eTime,
-		},
-		ImageMeta: &RenderMetadata{
-			ImageHeight:  req.Image.Height,
-			ImageWidth:   req.Image.Width,
-			FreqPerPixel: float64(highFreq-lowFreq) / float64(req.Image.Width),
-			SecPerPixel:  eTime.Sub(sTime).Seconds() / float64(req.Image.Height),
-		},
+		Image:      canvas,
+		SourceMeta: wf.meta,
+		ImageMeta:  wf.renderMetadata(req.Image),
 	}, nil
 }
+
+// draw draws the buckets of the columns onto the canvas, one row of pixels per time bucket.
+// Rows are drawn in parallel, each worker only writes the pixels of its own rows.
+func (w *waterfall) draw(canvas *image.RGBA, columns []int, opts *ImageOptions) {
+	rows := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rowIdx := range rows {
+				row := w.dbs[rowIdx]
+				for x, columnIdx := range columns {
+					if db, ok := row[columnIdx]; ok {
+						canvas.SetRGBA(x, rowIdx-1, w.color(rowIdx, columnIdx, db, opts))
+					}
+				}
+			}
+		}()
+	}
+	for rowIdx := range w.dbs {
+		rows <- rowIdx
+	}
+	close(rows)
+	wg.Wait()
+}
+
+func (w *waterfall) renderMetadata(opts *ImageOptions) *RenderMetadata {
+	return &RenderMetadata{
+		ImageHeight:  opts.Height,
+		ImageWidth:   opts.Width,
+		FreqPerPixel: float64(w.meta.HighFreq-w.meta.LowFreq) / float64(opts.Width),
+		SecPerPixel:  w.meta.EndTime.Sub(w.meta.StartTime).Seconds() / float64(opts.Height),
+
+		RequestedHeight: w.requestedHeight,
+		RequestedWidth:  w.requestedWidth,
+		Clamped:         w.clamped,
+	}
+}
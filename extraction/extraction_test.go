@@ -0,0 +1,548 @@
+package extraction
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/hb9tf/spectre/export"
+	"github.com/hb9tf/spectre/sdr"
+)
+
+// testStart is the start of the first sample in the DB returned by newTestDB.
+var testStart = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestDB returns a sqlite DB with the samples of two rtlsdr collectors: "a" (tagged with
+// antenna=discone) and "b". Each has 4 bins of 10 Hz from 100 Hz to 140 Hz in 3 consecutive
+// one second intervals. The dB value of a sample is -100 + 10 * bin + interval for "a"
+// and -50 for "b".
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "spectre.db"))
+	if err != nil {
+		t.Fatalf("unable to open DB: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	samples := make(chan sdr.Sample, 24)
+	for interval := 0; interval < 3; interval++ {
+		for bin := 0; bin < 4; bin++ {
+			low := int64(100 + 10*bin)
+			start := testStart.Add(time.Duration(interval) * time.Second)
+			a := sdr.Sample{
+				Source:      "rtlsdr",
+				Identifier:  "a",
+				Metadata:    map[string]string{"antenna": "discone"},
+				FreqLow:     low,
+				FreqHigh:    low + 10,
+				FreqCenter:  low + 5,
+				SampleCount: 1,
+				Start:       start,
+				End:         start.Add(time.Second),
+			}
+			a.DBLow = float64(-100 + 10*bin + interval)
+			a.DBHigh, a.DBAvg = a.DBLow, a.DBLow
+			b := a
+			b.Identifier = "b"
+			b.Metadata = nil
+			b.DBLow, b.DBHigh, b.DBAvg = -50, -50, -50
+			samples <- a
+			samples <- b
+		}
+	}
+	close(samples)
+	if err := (&export.SQL{DB: db}).Write(context.Background(), samples); err != nil {
+		t.Fatalf("unable to store samples: %s", err)
+	}
+	return db
+}
+
+// newTestRequest returns a request for all samples of the identifier in newTestDB.
+func newTestRequest(identifier string) *RenderRequest {
+	return &RenderRequest{
+		Filter: &FilterOptions{
+			SDR:        "rtlsdr",
+			Identifier: identifier,
+			StartFreq:  0,
+			EndFreq:    1000,
+			StartTime:  testStart,
+			EndTime:    testStart.Add(time.Hour),
+		},
+		Image: &ImageOptions{},
+	}
+}
+
+// newTestWaterfall returns a waterfall with random dB values in all buckets.
+func newTestWaterfall(width, height int) *waterfall {
+	r := rand.New(rand.NewSource(1))
+	wf := &waterfall{
+		dbs:    map[int]map[int]float32{},
+		counts: map[int]map[int]int64{},
+		minDB:  -100,
+		maxDB:  0,
+	}
+	for rowIdx := 1; rowIdx <= height; rowIdx++ {
+		wf.dbs[rowIdx] = map[int]float32{}
+		wf.counts[rowIdx] = map[int]int64{}
+		for colIdx := 1; colIdx <= width; colIdx++ {
+			wf.dbs[rowIdx][colIdx] = -100 * r.Float32()
+			wf.counts[rowIdx][colIdx] = r.Int63n(10) + 1
+			wf.maxCount = max(wf.maxCount, wf.counts[rowIdx][colIdx])
+		}
+	}
+	return wf
+}
+
+func TestWaterfallDraw(t *testing.T) {
+	wf := newTestWaterfall(4, 3)
+	delete(wf.dbs[2], 3) // a bucket without samples
+	opts := &ImageOptions{Palette: PaletteDefault}
+	canvas := image.NewRGBA(image.Rect(0, 0, 4, 3))
+	wf.draw(canvas, wf.columns(4, false), opts)
+
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 4; x++ {
+			got := canvas.RGBAAt(x, y)
+			db, ok := wf.dbs[y+1][x+1]
+			if !ok {
+				if got.A != 0 {
+					t.Errorf("pixel (%d, %d) without samples = %v, want transparent", x, y, got)
+				}
+				continue
+			}
+			if want := wf.color(y+1, x+1, db, opts); got != want {
+				t.Errorf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+// BenchmarkRenderWaterfall measures drawing the waterfall rows of Render, i.e. without
+// querying the DB and drawing the grid.
+func BenchmarkRenderWaterfall(b *testing.B) {
+	for _, size := range []struct{ width, height int }{{500, 250}, {2000, 1000}} {
+		b.Run(fmt.Sprintf("%dx%d", size.width, size.height), func(b *testing.B) {
+			wf := newTestWaterfall(size.width, size.height)
+			columns := wf.columns(size.width, false)
+			opts := &ImageOptions{Palette: PaletteDefault}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				canvas := image.NewRGBA(image.Rect(0, 0, size.width, size.height))
+				wf.draw(canvas, columns, opts)
+			}
+		})
+	}
+}
+
+// BenchmarkRenderIndexes measures rendering a few minutes of one collector in a DB holding
+// 160000 samples of 8 collectors with and without the indexes created by export.SQL.
+func BenchmarkRenderIndexes(b *testing.B) {
+	db, err := sql.Open("sqlite3", filepath.Join(b.TempDir(), "spectre.db"))
+	if err != nil {
+		b.Fatalf("unable to open DB: %s", err)
+	}
+	defer db.Close()
+
+	samples := make(chan sdr.Sample, 1000)
+	go func() {
+		defer close(samples)
+		for _, source := range []string{"rtlsdr", "hackrf"} {
+			for identifier := 0; identifier < 4; identifier++ {
+				for interval := 0; interval < 100; interval++ {
+					start := testStart.Add(time.Duration(interval) * time.Minute)
+					for bin := 0; bin < 200; bin++ {
+						low := int64(100_000_000 + 10_000*bin)
+						samples <- sdr.Sample{
+							Source:      source,
+							Identifier:  fmt.Sprint(identifier),
+							FreqLow:     low,
+							FreqHigh:    low + 10_000,
+							FreqCenter:  low + 5_000,
+							DBLow:       -float64(bin % 50),
+							DBHigh:      -float64(bin % 50),
+							DBAvg:       -float64(bin % 50),
+							SampleCount: 1,
+							Start:       start,
+							End:         start.Add(time.Minute),
+						}
+					}
+				}
+			}
+		}
+	}()
+	if err := (&export.SQL{DB: db}).Write(context.Background(), samples); err != nil {
+		b.Fatalf("unable to store samples: %s", err)
+	}
+
+	render := func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			req := newTestRequest("2")
+			req.Filter.StartTime = testStart.Add(45 * time.Minute)
+			req.Filter.EndTime = testStart.Add(55 * time.Minute)
+			req.Filter.StartFreq, req.Filter.EndFreq = 100_000_000, 102_000_000
+			if _, err := Render(db, req); err != nil {
+				b.Fatalf("Render() failed: %s", err)
+			}
+		}
+	}
+	b.Run("indexed", render)
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = 'spectre' AND sql IS NOT NULL")
+	if err != nil {
+		b.Fatalf("unable to list indexes: %s", err)
+	}
+	var indexes []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			b.Fatalf("unable to read index name: %s", err)
+		}
+		indexes = append(indexes, name)
+	}
+	rows.Close()
+	for _, name := range indexes {
+		if _, err := db.Exec("DROP INDEX " + name); err != nil {
+			b.Fatalf("unable to drop index %q: %s", name, err)
+		}
+	}
+	b.Run("unindexed", render)
+}
+
+func TestRender(t *testing.T) {
+	db := newTestDB(t)
+	tests := []struct {
+		name       string
+		identifier string
+		tags       map[string]string
+		modify     func(*ImageOptions)
+		limits     *RenderLimits
+		wantWidth  int
+		wantHeight int
+		wantErr    error
+	}{
+		{name: "data resolution", identifier: "a", wantWidth: 4, wantHeight: 3},
+		{name: "reduced resolution", identifier: "a", modify: func(o *ImageOptions) { o.Width, o.Height = 2, 1 }, wantWidth: 2, wantHeight: 1},
+		{name: "clamped resolution", identifier: "a", modify: func(o *ImageOptions) { o.Width, o.Height = 100, 100 }, wantWidth: 4, wantHeight: 3},
+		{name: "pattern", identifier: "%", wantWidth: 4, wantHeight: 3},
+		{name: "tags", tags: map[string]string{"antenna": "discone"}, wantWidth: 4, wantHeight: 3},
+		{name: "grid and legend", identifier: "a", modify: func(o *ImageOptions) { o.AddGrid, o.AddLegend = true, true }, wantWidth: 4 + gridMarginLeft - 1 + legendMarginLeft + legendWidth + legendLabelWidth, wantHeight: 3 + gridMarginTop - 1},
+		{name: "spectrum", identifier: "a", modify: func(o *ImageOptions) { o.Mode = ModeSpectrum }, wantWidth: 4, wantHeight: spectrumDefaultHeight},
+		{name: "no samples", identifier: "c", wantErr: ErrNoSamples},
+		{name: "no tagged samples", tags: map[string]string{"antenna": "yagi"}, wantErr: ErrNoSamples},
+		{name: "too many pixels", identifier: "a", limits: &RenderLimits{MaxPixels: 11}, wantErr: ErrLimitExceeded},
+		{name: "too many samples", identifier: "a", limits: &RenderLimits{MaxSamples: 11}, wantErr: ErrLimitExceeded},
+		{name: "unknown palette", identifier: "a", modify: func(o *ImageOptions) { o.Palette = "rainbow" }, wantErr: errInvalidRequest},
+		{name: "unknown metric", identifier: "a", modify: func(o *ImageOptions) { o.Metric = "median" }, wantErr: errInvalidRequest},
+		{name: "even smooth kernel", identifier: "a", modify: func(o *ImageOptions) { o.SmoothKernel = 2 }, wantErr: errInvalidRequest},
+		{name: "inverted dB range", identifier: "a", modify: func(o *ImageOptions) { minDB, maxDB := -10.0, -20.0; o.MinDB, o.MaxDB = &minDB, &maxDB }, wantErr: errInvalidRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newTestRequest(tc.identifier)
+			req.Filter.Tags = tc.tags
+			req.Limits = tc.limits
+			if tc.modify != nil {
+				tc.modify(req.Image)
+			}
+			result, err := Render(db, req)
+			switch {
+			case tc.wantErr == errInvalidRequest:
+				if err == nil {
+					t.Fatal("Render() succeeded, want error")
+				}
+				return
+			case !errors.Is(err, tc.wantErr):
+				t.Fatalf("Render() error = %v, want %v", err, tc.wantErr)
+			case err != nil:
+				return
+			}
+			if got := result.Image.Bounds(); got.Dx() != tc.wantWidth || got.Dy() != tc.wantHeight {
+				t.Errorf("image is %dx%d pixels, want %dx%d", got.Dx(), got.Dy(), tc.wantWidth, tc.wantHeight)
+			}
+			if got, want := result.SourceMeta.LowFreq, int64(100); got != want {
+				t.Errorf("low frequency is %d Hz, want %d Hz", got, want)
+			}
+			if got, want := result.SourceMeta.HighFreq, int64(140); got != want {
+				t.Errorf("high frequency is %d Hz, want %d Hz", got, want)
+			}
+		})
+	}
+}
+
+// errInvalidRequest marks test cases expecting any error.
+var errInvalidRequest = errors.New("invalid request")
+
+func TestRenderColors(t *testing.T) {
+	db := newTestDB(t)
+	req := newTestRequest("a")
+	req.Image.Palette = PaletteGrayscale
+	result, err := Render(db, req)
+	if err != nil {
+		t.Fatalf("Render() failed: %s", err)
+	}
+	img := result.Image.(*image.RGBA)
+	// The lowest dB value (first bin, first interval) is black and the highest (last bin,
+	// last interval) white. The first row is the oldest.
+	if got, want := img.RGBAAt(0, 0), (color.RGBA{0, 0, 0, 255}); got != want {
+		t.Errorf("lowest dB is drawn as %v, want %v", got, want)
+	}
+	if got, want := img.RGBAAt(3, 2), (color.RGBA{255, 255, 255, 255}); got != want {
+		t.Errorf("highest dB is drawn as %v, want %v", got, want)
+	}
+	// The first bin is darker than the second in the same interval.
+	if img.RGBAAt(0, 1).R >= img.RGBAAt(1, 1).R {
+		t.Errorf("bin 1 (%v) isn't darker than bin 2 (%v)", img.RGBAAt(0, 1), img.RGBAAt(1, 1))
+	}
+}
+
+func TestRenderTimezone(t *testing.T) {
+	// The time axis is only labelled on images which are high enough, so store 40 intervals.
+	db := newTestDB(t)
+	samples := make(chan sdr.Sample, 80)
+	for interval := 0; interval < 40; interval++ {
+		for bin := 0; bin < 2; bin++ {
+			start := testStart.Add(time.Duration(interval) * time.Minute)
+			samples <- sdr.Sample{
+				Source:      "rtlsdr",
+				Identifier:  "long",
+				FreqLow:     int64(100 + 10*bin),
+				FreqHigh:    int64(110 + 10*bin),
+				FreqCenter:  int64(105 + 10*bin),
+				DBLow:       float64(-interval),
+				DBHigh:      float64(-interval),
+				DBAvg:       float64(-interval),
+				SampleCount: 1,
+				Start:       start,
+				End:         start.Add(time.Minute),
+			}
+		}
+	}
+	close(samples)
+	if err := (&export.SQL{DB: db}).Write(context.Background(), samples); err != nil {
+		t.Fatalf("unable to store samples: %s", err)
+	}
+
+	render := func(loc *time.Location, grid bool) *RenderResult {
+		t.Helper()
+		req := newTestRequest("long")
+		req.Image.AddGrid = grid
+		req.Image.Location = loc
+		result, err := Render(db, req)
+		if err != nil {
+			t.Fatalf("Render() failed: %s", err)
+		}
+		return result
+	}
+	zone := time.FixedZone("UTC+2", 2*60*60)
+	utc := render(time.UTC, true)
+	zoned := render(zone, true)
+
+	if !zoned.SourceMeta.StartTime.Equal(utc.SourceMeta.StartTime) || !zoned.SourceMeta.EndTime.Equal(utc.SourceMeta.EndTime) {
+		t.Errorf("time range in UTC+2 is %s - %s, want %s - %s", zoned.SourceMeta.StartTime, zoned.SourceMeta.EndTime, utc.SourceMeta.StartTime, utc.SourceMeta.EndTime)
+	}
+	if got := zoned.SourceMeta.StartTime.Location(); got != zone {
+		t.Errorf("start time is in %s, want %s", got, zone)
+	}
+	if reflect.DeepEqual(zoned.Image, utc.Image) {
+		t.Error("time axis in UTC+2 is labelled like in UTC")
+	}
+
+	// The time axis in UTC+2 is labelled with the UTC wall clock shifted by two hours.
+	meta := utc.SourceMeta
+	want := DrawGrid(render(time.UTC, false).Image.(*image.RGBA), meta.LowFreq, meta.HighFreq, false, meta.StartTime.Add(2*time.Hour), meta.EndTime.Add(2*time.Hour), nil, DefaultGridColors)
+	if !reflect.DeepEqual(zoned.Image, want) {
+		t.Error("time axis in UTC+2 isn't labelled two hours ahead of UTC")
+	}
+}
+
+func TestRenderMatrix(t *testing.T) {
+	db := newTestDB(t)
+	req := newTestRequest("a")
+	m, err := RenderMatrix(db, req)
+	if err != nil {
+		t.Fatalf("RenderMatrix() failed: %s", err)
+	}
+	if len(m.DB) != 3 || len(m.DB[0]) != 4 {
+		t.Fatalf("matrix is %dx%d, want 3x4", len(m.DB), len(m.DB[0]))
+	}
+	for y, row := range m.DB {
+		for x, db := range row {
+			want := float32(-100 + 10*x + y)
+			if db == nil || *db != want {
+				t.Errorf("bucket (%d, %d) = %v, want %g", x, y, db, want)
+			}
+		}
+	}
+	if want := []float64{105, 115, 125, 135}; !reflect.DeepEqual(m.Freqs, want) {
+		t.Errorf("frequencies = %v, want %v", m.Freqs, want)
+	}
+	if want := []int64{testStart.UnixMilli(), testStart.Add(time.Second).UnixMilli(), testStart.Add(2 * time.Second).UnixMilli()}; !reflect.DeepEqual(m.Times, want) {
+		t.Errorf("times = %v, want %v", m.Times, want)
+	}
+	if m.MinDB != -100 || m.MaxDB != -68 || m.Metric != MetricHigh {
+		t.Errorf("matrix has dB range %g - %g and metric %q, want -100 - -68 and %q", m.MinDB, m.MaxDB, m.Metric, MetricHigh)
+	}
+}
+
+func TestIdentifierFilter(t *testing.T) {
+	tests := []struct {
+		identifier    string
+		wantCondition string
+		wantArgs      []any
+	}{
+		{identifier: "", wantCondition: "1 = 1"},
+		{identifier: " , ", wantCondition: "1 = 1"},
+		{identifier: "a", wantCondition: "(Identifier IN (?))", wantArgs: []any{"a"}},
+		{identifier: "a, b", wantCondition: "(Identifier IN (?, ?))", wantArgs: []any{"a", "b"}},
+		{identifier: "roof-%", wantCondition: "(Identifier LIKE ?)", wantArgs: []any{"roof-%"}},
+		{identifier: "a,roof_1,b,%-2", wantCondition: "(Identifier IN (?, ?) OR Identifier LIKE ? OR Identifier LIKE ?)", wantArgs: []any{"a", "b", "roof_1", "%-2"}},
+	}
+	for _, tc := range tests {
+		condition, args := identifierFilter(tc.identifier)
+		if condition != tc.wantCondition || !reflect.DeepEqual(args, tc.wantArgs) {
+			t.Errorf("identifierFilter(%q) = %q, %v, want %q, %v", tc.identifier, condition, args, tc.wantCondition, tc.wantArgs)
+		}
+	}
+}
+
+func TestTagFilter(t *testing.T) {
+	condition, args := tagFilter(map[string]string{"feed": "roof", "antenna": "50%_dis!cone"})
+	if want := "(Metadata LIKE ? ESCAPE '!' AND Metadata LIKE ? ESCAPE '!')"; condition != want {
+		t.Errorf("condition = %q, want %q", condition, want)
+	}
+	if want := []any{`%"antenna":"50!%!_dis!!cone"%`, `%"feed":"roof"%`}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %q, want %q", args, want)
+	}
+}
+
+func TestGetReadableFreq(t *testing.T) {
+	tests := []struct {
+		freq int64
+		want string
+	}{
+		{freq: 0, want: "0.00 Hz"},
+		{freq: 999, want: "999.00 Hz"},
+		{freq: 1000, want: "1.00 kHz"},
+		{freq: 999999, want: "1.00 MHz"},
+		{freq: 145500000, want: "145.50 MHz"},
+		{freq: 2400000000, want: "2.40 GHz"},
+		{freq: math.MaxInt64, want: "9.22 EHz"},
+	}
+	for _, tc := range tests {
+		if got := GetReadableFreq(tc.freq); got != tc.want {
+			t.Errorf("GetReadableFreq(%d) = %q, want %q", tc.freq, got, tc.want)
+		}
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    color.RGBA
+		wantErr bool
+	}{
+		{input: "#ff8000", want: color.RGBA{255, 128, 0, 255}},
+		{input: "00ff0080", want: color.RGBA{0, 255, 0, 128}},
+		{input: "#FFFFFF", want: color.RGBA{255, 255, 255, 255}},
+		{input: "#fff", wantErr: true},
+		{input: "#gggggg", wantErr: true},
+		{input: "#ff800000ff", wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := ParseColor(tc.input)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("ParseColor(%q) error = %v, want error: %t", tc.input, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseColor(%q) = %v, want %v", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestGetColor(t *testing.T) {
+	tests := []struct {
+		lvl     uint16
+		palette string
+		want    color.RGBA
+	}{
+		{lvl: 0, palette: PaletteDefault, want: color.RGBA{0, 0, 0, 255}},
+		{lvl: math.MaxUint16, palette: PaletteDefault, want: color.RGBA{255, 255, 255, 255}},
+		{lvl: math.MaxUint16 / 2, palette: PaletteDefault, want: color.RGBA{0, 255, 0, 255}},   // green
+		{lvl: math.MaxUint16 / 4, palette: PaletteDefault, want: color.RGBA{0, 127, 255, 255}}, // between blue and cyan
+		{lvl: math.MaxUint16 / 2, palette: PaletteGrayscale, want: color.RGBA{127, 127, 127, 255}},
+		{lvl: 0, palette: "unknown", want: color.RGBA{0, 0, 0, 255}},
+	}
+	for _, tc := range tests {
+		if got := GetColor(tc.lvl, tc.palette); got != tc.want {
+			t.Errorf("GetColor(%d, %q) = %v, want %v", tc.lvl, tc.palette, got, tc.want)
+		}
+	}
+}
+
+func TestFindGridStepSize(t *testing.T) {
+	tests := []struct {
+		size       int
+		horizontal bool
+		want       int
+	}{
+		{size: 1000, horizontal: true, want: 100},
+		{size: 5000, horizontal: true, want: 200},
+		{size: 1000, want: 50},
+		{size: 300, want: 20},
+		{size: 50, horizontal: true, want: 49},
+		{size: 1, want: 1},
+	}
+	for _, tc := range tests {
+		if got := findGridStepSize(tc.size, tc.horizontal); got != tc.want {
+			t.Errorf("findGridStepSize(%d, %t) = %d, want %d", tc.size, tc.horizontal, got, tc.want)
+		}
+	}
+}
+
+func TestWaterfallColumns(t *testing.T) {
+	wf := &waterfall{
+		freqCenters: map[int]float64{1: 15, 2: 150, 3: 1500},
+		meta:        &SourceMetadata{LowFreq: 10, HighFreq: 2000},
+	}
+	if got, want := wf.columns(3, false), []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("linear columns = %v, want %v", got, want)
+	}
+	// The pixel columns are at about 15, 38, 91, 222, 538 and 1307 Hz on the logarithmic axis
+	// and show the nearest bucket.
+	if got, want := wf.columns(6, true), []int{1, 1, 2, 2, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("logarithmic columns = %v, want %v", got, want)
+	}
+}
+
+func TestWaterfallLevel(t *testing.T) {
+	wf := &waterfall{minDB: -100, maxDB: -50}
+	tests := []struct {
+		db   float32
+		want uint16
+	}{
+		{db: -100, want: 0},
+		{db: -50, want: math.MaxUint16},
+		{db: -75, want: math.MaxUint16 / 2},
+		{db: -120, want: 0},
+		{db: 0, want: math.MaxUint16},
+	}
+	for _, tc := range tests {
+		if got := wf.level(tc.db); got != tc.want {
+			t.Errorf("level(%g) = %d, want %d", tc.db, got, tc.want)
+		}
+	}
+	if got, want := (&waterfall{minDB: -50, maxDB: -50}).level(-50), uint16(math.MaxUint16/2); got != want {
+		t.Errorf("level() of an empty range = %d, want %d", got, want)
+	}
+}
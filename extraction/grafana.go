@@ -0,0 +1,116 @@
+package extraction
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// GrafanaSeriesBand returns one series for the whole frequency band.
+	GrafanaSeriesBand = "band"
+	// GrafanaSeriesFreq returns one series per center frequency.
+	GrafanaSeriesFreq = "freq"
+
+	// getGrafanaTmpl needs to be formatted with the time bucket size in milliseconds, the series
+	// name, the aggregation of the dB values (see metricAggregations) and the filter conditions.
+	// Start and End are Unix milliseconds, the time column is returned in Unix seconds.
+	getGrafanaTmpl = `SELECT
+	(Start - (Start %% %[1]s)) / 1000 AS time,
+	%[2]s AS metric,
+	%[3]s AS value
+FROM
+	spectre
+WHERE
+	%[4]s
+GROUP BY
+	1, 2
+ORDER BY
+	1 ASC, 2 ASC`
+	// grafanaIntervalMacro is replaced by Grafana with the interval of the panel in milliseconds.
+	grafanaIntervalMacro = "$__interval_ms"
+	// grafanaStartFilter and grafanaEndFilter restrict the samples to the time range of the dashboard.
+	grafanaStartFilter = "Start >= $__unixEpochFrom() * 1000"
+	grafanaEndFilter   = "End <= $__unixEpochTo() * 1000"
+)
+
+// GrafanaQuery describes a time series query for Grafana panels (e.g. with the MySQL or SQLite
+// data source) returning the columns time, metric and value.
+type GrafanaQuery struct {
+	// SDR and Identifier optionally restrict the samples to a source and identifier.
+	SDR        string
+	Identifier string
+	// StartFreq and EndFreq select the frequency band in Hz.
+	StartFreq int64
+	EndFreq   int64
+	// Metric selects which dB value of the samples is aggregated (see Metric* constants).
+	// Defaults to the highest dB value in each time bucket.
+	Metric string
+	// Series selects whether the band is returned as one series or one per center frequency
+	// (see GrafanaSeries* constants), defaults to GrafanaSeriesBand.
+	Series string
+	// Interval is the size of the time buckets, defaults to the interval of the panel
+	// ($__interval_ms).
+	Interval time.Duration
+}
+
+// SQL returns the query with the time range of the dashboard filled in by Grafana macros.
+// The band series is named after the frequency range, e.g. "430.000MHz - 440.000MHz".
+func (q *GrafanaQuery) SQL() (string, error) {
+	metric := q.Metric
+	if metric == "" {
+		metric = MetricHigh
+	}
+	if !IsValidMetric(metric) {
+		return "", fmt.Errorf("unknown metric %q", metric)
+	}
+	if q.StartFreq < 0 || q.EndFreq <= q.StartFreq {
+		return "", fmt.Errorf("end frequency (%d Hz) needs to be above start frequency (%d Hz)", q.EndFreq, q.StartFreq)
+	}
+
+	var series string
+	switch q.Series {
+	case "", GrafanaSeriesBand:
+		series = sqlQuote(fmt.Sprintf("%s - %s", GetReadableFreq(q.StartFreq), GetReadableFreq(q.EndFreq)))
+	case GrafanaSeriesFreq:
+		series = "FreqCenter"
+	default:
+		return "", fmt.Errorf("unknown series %q, pick one of: band, freq", q.Series)
+	}
+
+	interval := grafanaIntervalMacro
+	if q.Interval > 0 {
+		interval = fmt.Sprint(q.Interval.Milliseconds())
+	}
+	if interval == "0" {
+		return "", errors.New("interval needs to be at least 1ms")
+	}
+
+	conditions := []string{
+		fmt.Sprintf("FreqLow >= %d", q.StartFreq),
+		fmt.Sprintf("FreqHigh <= %d", q.EndFreq),
+		grafanaStartFilter,
+		grafanaEndFilter,
+	}
+	for _, filter := range []struct{ column, value string }{
+		{"Source", q.SDR},
+		{"Identifier", q.Identifier},
+	} {
+		if filter.value == "" {
+			continue
+		}
+		if strings.ContainsAny(filter.value, `'\`) {
+			return "", fmt.Errorf("%s %q must not contain quotes or backslashes", strings.ToLower(filter.column), filter.value)
+		}
+		conditions = append(conditions, fmt.Sprintf("%s = %s", filter.column, sqlQuote(filter.value)))
+	}
+
+	return fmt.Sprintf(getGrafanaTmpl, interval, series, metricAggregations[metric], strings.Join(conditions, "\n\tAND ")), nil
+}
+
+// sqlQuote returns the value as SQL string literal. The value must not contain quotes or
+// backslashes (which MySQL treats as escape character).
+func sqlQuote(value string) string {
+	return "'" + value + "'"
+}
@@ -0,0 +1,47 @@
+package extraction
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGrafanaQuerySQL(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   GrafanaQuery
+		want    []string // substrings of the query
+		wantErr bool
+	}{
+		{
+			name:  "band",
+			query: GrafanaQuery{StartFreq: 430000000, EndFreq: 440000000},
+			want:  []string{"'430.00 MHz - 440.00 MHz' AS metric", "MAX(DBHigh) AS value", "Start % $__interval_ms", "FreqLow >= 430000000", "FreqHigh <= 440000000", grafanaStartFilter, grafanaEndFilter},
+		},
+		{
+			name:  "frequencies with filters",
+			query: GrafanaQuery{SDR: "rtlsdr", Identifier: "roof", StartFreq: 100, EndFreq: 200, Metric: MetricAvg, Series: GrafanaSeriesFreq, Interval: time.Minute},
+			want:  []string{"FreqCenter AS metric", "AVG(DBAvg) AS value", "Start % 60000", "Source = 'rtlsdr'", "Identifier = 'roof'"},
+		},
+		{name: "unknown metric", query: GrafanaQuery{StartFreq: 100, EndFreq: 200, Metric: "median"}, wantErr: true},
+		{name: "empty band", query: GrafanaQuery{StartFreq: 200, EndFreq: 200}, wantErr: true},
+		{name: "negative start", query: GrafanaQuery{StartFreq: -1, EndFreq: 200}, wantErr: true},
+		{name: "unknown series", query: GrafanaQuery{StartFreq: 100, EndFreq: 200, Series: "time"}, wantErr: true},
+		{name: "interval too short", query: GrafanaQuery{StartFreq: 100, EndFreq: 200, Interval: time.Microsecond}, wantErr: true},
+		{name: "quote in identifier", query: GrafanaQuery{StartFreq: 100, EndFreq: 200, Identifier: "x' OR '1'='1"}, wantErr: true},
+		{name: "backslash in source", query: GrafanaQuery{StartFreq: 100, EndFreq: 200, SDR: `x\`}, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.query.SQL()
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("SQL() error = %v, want error: %t", err, tc.wantErr)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("SQL() = %q, want it to contain %q", got, want)
+				}
+			}
+		})
+	}
+}
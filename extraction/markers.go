@@ -0,0 +1,89 @@
+package extraction
+
+import (
+	"fmt"
+	"image"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	markerDashLen  = 4  // pixels
+	markerLabelTop = 15 // pixels below the top of the waterfall
+)
+
+// Marker labels a frequency on the rendered image, e.g. a band edge.
+type Marker struct {
+	Freq  int64  `json:"freq"`
+	Label string `json:"label"`
+}
+
+// ParseMarkers parses a comma separated list of markers in the format "freq:label" with the
+// frequency in Hz, e.g. "430000000:70cm start,440000000:70cm end". The label is optional.
+func ParseMarkers(s string) ([]Marker, error) {
+	var markers []Marker
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		freq, label, _ := strings.Cut(entry, ":")
+		f, err := strconv.ParseInt(strings.TrimSpace(freq), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid marker %q, use freq:label with the frequency in Hz: %s", entry, err)
+		}
+		markers = append(markers, Marker{Freq: f, Label: strings.TrimSpace(label)})
+	}
+	return markers, nil
+}
+
+// markerPosition returns the X position of the marker on a frequency axis of the given width
+// and whether it falls within the rendered range.
+func markerPosition(m Marker, lowFreq, highFreq int64, width int, logFreq bool) (int, bool) {
+	if m.Freq < lowFreq || m.Freq > highFreq || highFreq <= lowFreq {
+		return 0, false
+	}
+	x := int(math.Round(float64(m.Freq-lowFreq) * float64(width) / float64(highFreq-lowFreq)))
+	if logFreq {
+		x = logFreqPosition(float64(m.Freq), lowFreq, highFreq, width)
+	}
+	return min(x, width-1), true
+}
+
+// drawMarkers draws each marker within the frequency range as a dashed vertical line across
+// the area of the given size starting at the grid margins of the canvas and labels it.
+func drawMarkers(canvas *image.RGBA, markers []Marker, lowFreq, highFreq int64, width, height int, logFreq bool, colors GridColors) {
+	left := canvas.Bounds().Min.X + gridMarginLeft
+	top := canvas.Bounds().Min.Y + gridMarginTop
+	for _, m := range markers {
+		x, ok := markerPosition(m, lowFreq, highFreq, width, logFreq)
+		if !ok {
+			slog.Debug("skipping marker outside of the rendered frequency range", "freq", m.Freq, "label", m.Label)
+			continue
+		}
+		for y := 0; y < height; y++ {
+			if (y/markerDashLen)%2 == 0 {
+				canvas.SetRGBA(left+x, top+y, colors.Grid)
+			}
+		}
+		if m.Label == "" {
+			continue
+		}
+		d := &font.Drawer{
+			Dst:  canvas,
+			Src:  image.NewUniform(colors.Text),
+			Face: basicfont.Face7x13,
+			Dot: fixed.Point26_6{
+				X: fixed.Int26_6((left + x + 3) * 64),
+				Y: fixed.Int26_6((top + markerLabelTop) * 64),
+			},
+		}
+		d.DrawString(m.Label)
+	}
+}
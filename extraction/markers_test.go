@@ -0,0 +1,61 @@
+package extraction
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMarkers(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []Marker
+		wantErr bool
+	}{
+		{name: "empty", input: ""},
+		{
+			name:  "markers",
+			input: "430000000:70cm start, 440000000 : 70cm end,",
+			want:  []Marker{{Freq: 430000000, Label: "70cm start"}, {Freq: 440000000, Label: "70cm end"}},
+		},
+		{name: "without label", input: "145000000", want: []Marker{{Freq: 145000000}}},
+		{name: "label with colon", input: "100:a:b", want: []Marker{{Freq: 100, Label: "a:b"}}},
+		{name: "invalid frequency", input: "145MHz:2m", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseMarkers(tc.input)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("ParseMarkers(%q) error = %v, want error: %t", tc.input, err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ParseMarkers(%q) = %+v, want %+v", tc.input, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMarkerPosition(t *testing.T) {
+	tests := []struct {
+		name    string
+		freq    int64
+		logFreq bool
+		wantX   int
+		wantOK  bool
+	}{
+		{name: "low edge", freq: 1000, wantX: 0, wantOK: true},
+		{name: "middle", freq: 5500, wantX: 50, wantOK: true},
+		{name: "high edge is clamped", freq: 10000, wantX: 99, wantOK: true},
+		{name: "below range", freq: 999},
+		{name: "above range", freq: 10001},
+		{name: "logarithmic middle", freq: 3163, logFreq: true, wantX: 50, wantOK: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			x, ok := markerPosition(Marker{Freq: tc.freq}, 1000, 10000, 100, tc.logFreq)
+			if ok != tc.wantOK || (ok && x != tc.wantX) {
+				t.Errorf("markerPosition(%d Hz) = %d, %t, want %d, %t", tc.freq, x, ok, tc.wantX, tc.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package extraction
+
+import (
+	"database/sql"
+	"math"
+	"time"
+)
+
+// FormatJSON returns the waterfall as Matrix instead of an encoded image.
+const FormatJSON = "json"
+
+// Matrix holds the aggregated dB values of a waterfall for clients rendering it themselves.
+type Matrix struct {
+	// DB holds one row per time bucket, oldest first, with one value per frequency bucket.
+	// Buckets without samples are nil. In ModeSpectrum, there is only one row.
+	DB [][]*float32 `json:"db"`
+	// Freqs is the center frequency in Hz of each column.
+	Freqs []float64 `json:"freqs"`
+	// Times is the start time of each row in Unix milliseconds.
+	Times []int64 `json:"times"`
+	// MinDB and MaxDB are the dB range the colors of a rendered image would be scaled to.
+	MinDB  float32 `json:"minDB"`
+	MaxDB  float32 `json:"maxDB"`
+	Metric string  `json:"metric"`
+	// RequestedWidth and RequestedHeight are the dimensions asked for, Clamped is set if they
+	// were reduced to the resolution of the data (see RenderMetadata).
+	RequestedWidth  int  `json:"requestedWidth"`
+	RequestedHeight int  `json:"requestedHeight"`
+	Clamped         bool `json:"clamped"`
+}
+
+// RenderMatrix selects and buckets the samples like Render but returns the dB values
+// instead of drawing them.
+func RenderMatrix(db *sql.DB, req *RenderRequest) (*Matrix, error) {
+	wf, err := queryWaterfall(db, req)
+	if err != nil {
+		return nil, err
+	}
+
+	width := req.Image.Width
+	height := req.Image.Height
+	if req.Image.Mode == ModeSpectrum {
+		height = 1 // the height only applies to the plot
+	}
+	m := &Matrix{
+		DB:     make([][]*float32, height),
+		Freqs:  make([]float64, width),
+		Times:  make([]int64, height),
+		MinDB:  wf.minDB,
+		MaxDB:  wf.maxDB,
+		Metric: req.Image.Metric,
+
+		RequestedWidth:  wf.requestedWidth,
+		RequestedHeight: wf.requestedHeight,
+		Clamped:         wf.clamped,
+	}
+
+	// Buckets are numbered starting at 1.
+	columns := wf.columns(width, req.Image.LogFreqAxis)
+	for y := range m.DB {
+		m.DB[y] = make([]*float32, width)
+		row := wf.dbs[y+1]
+		for x, columnIdx := range columns {
+			if db, ok := row[columnIdx]; ok {
+				m.DB[y][x] = &db
+			}
+		}
+		dur := time.Duration(int64(y) * int64(wf.meta.EndTime.Sub(wf.meta.StartTime)) / int64(height))
+		m.Times[y] = wf.meta.StartTime.Add(dur).UnixMilli()
+	}
+
+	low := float64(wf.meta.LowFreq)
+	high := float64(wf.meta.HighFreq)
+	for x := range m.Freqs {
+		pos := (float64(x) + 0.5) / float64(width)
+		if req.Image.LogFreqAxis {
+			m.Freqs[x] = low * math.Pow(high/low, pos)
+			continue
+		}
+		m.Freqs[x] = low + pos*(high-low)
+	}
+
+	return m, nil
+}
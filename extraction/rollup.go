@@ -0,0 +1,82 @@
+package extraction
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	sampleTable = "spectre"
+	// rollupTable and rollupResolution need to match the rollup maintained by export.UpdateRollup.
+	rollupTable      = "spectre_rollup"
+	rollupResolution = time.Minute
+	// rollupMinBucketsPerRow is the number of rollup buckets each row of a waterfall needs to
+	// cover at least for the rollup to be used instead of the samples.
+	rollupMinBucketsPerRow = 2
+
+	// getTimeRangeTmpl needs to be formatted with the table to query and the identifier condition.
+	getTimeRangeTmpl = `SELECT
+		COUNT(*),
+		MIN(Start),
+		MAX(End)
+	FROM
+		%s
+	WHERE
+		Source = ?
+		AND %s
+		AND FreqLow >= ?
+		AND FreqHigh <= ?
+		AND Start >= ?
+		AND End <= ?;`
+)
+
+// timeRange returns the number of rows in the table matching the filters and the time range
+// they cover in Unix milliseconds.
+func timeRange(db *sql.DB, table string, filter *FilterOptions) (int, int64, int64, error) {
+	var count int
+	var start, end sql.NullInt64
+	condition, identifiers := identifierFilter(filter.Identifier)
+	args := append(append([]any{filter.SDR}, identifiers...), filter.StartFreq, filter.EndFreq, filter.StartTime.UnixMilli(), filter.EndTime.UnixMilli())
+	if err := db.QueryRow(fmt.Sprintf(getTimeRangeTmpl, table, condition), args...).Scan(&count, &start, &end); err != nil {
+		return 0, 0, 0, err
+	}
+	return count, start.Int64, end.Int64, nil
+}
+
+// useRollup returns whether the waterfall can be rendered from the rollup instead of the
+// samples and how many rollup rows the filters select. This is the case for waterfalls with a
+// given height where each row covers at least rollupMinBucketsPerRow rollup buckets, as long as
+// the rollup doesn't miss more than one row worth of samples, e.g. the most recent ones which
+// haven't been aggregated yet. The rollup doesn't keep the metadata, so it can't be filtered by tags.
+func useRollup(db *sql.DB, req *RenderRequest) (int, bool) {
+	if req.Image.Mode != ModeWaterfall || req.Image.Height <= 0 || len(req.Filter.Tags) > 0 {
+		return 0, false
+	}
+	_, start, end, err := timeRange(db, sampleTable, req.Filter)
+	if err != nil {
+		slog.Warn("unable to get the time range of the samples", "error", err)
+		return 0, false
+	}
+	rowDuration := time.Duration(end-start) * time.Millisecond / time.Duration(req.Image.Height)
+	if rowDuration < rollupMinBucketsPerRow*rollupResolution {
+		return 0, false
+	}
+
+	count, rollupStart, rollupEnd, err := timeRange(db, rollupTable, req.Filter)
+	if err != nil {
+		// The rollup only exists if it has been enabled.
+		slog.Debug("unable to query the rollup, rendering from the samples", "error", err)
+		return 0, false
+	}
+	if count == 0 {
+		return 0, false
+	}
+	if missing := time.Duration(rollupStart-start+end-rollupEnd) * time.Millisecond; missing > rowDuration {
+		slog.Debug("the rollup doesn't cover the selected samples, rendering from the samples", "missing", missing, "rowDuration", rowDuration)
+		return 0, false
+	}
+	slog.Debug("rendering from the rollup", "rows", count, "rowDuration", rowDuration)
+	return count, true
+}
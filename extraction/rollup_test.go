@@ -0,0 +1,74 @@
+package extraction
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hb9tf/spectre/export"
+	"github.com/hb9tf/spectre/sdr"
+)
+
+func TestUseRollup(t *testing.T) {
+	// One sample per minute over 10 minutes, each rollup bucket holds one sample.
+	newDB := func(t *testing.T, rollupUntil time.Time) *sql.DB {
+		db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "spectre.db"))
+		if err != nil {
+			t.Fatalf("unable to open DB: %s", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		samples := make(chan sdr.Sample, 10)
+		for i := 0; i < 10; i++ {
+			start := testStart.Add(time.Duration(i) * time.Minute)
+			samples <- sdr.Sample{Source: "rtlsdr", Identifier: "a", FreqLow: 100, FreqHigh: 110, FreqCenter: 105, SampleCount: 1, Start: start, End: start.Add(time.Second)}
+		}
+		close(samples)
+		if err := (&export.SQL{DB: db}).Write(context.Background(), samples); err != nil {
+			t.Fatalf("unable to store samples: %s", err)
+		}
+		if !rollupUntil.IsZero() {
+			if _, err := export.UpdateRollup(db, export.DialectSQLite, rollupUntil); err != nil {
+				t.Fatalf("unable to update rollup: %s", err)
+			}
+		}
+		return db
+	}
+	tests := []struct {
+		name        string
+		rollupUntil time.Time
+		modify      func(*RenderRequest)
+		wantRows    int
+		wantRollup  bool
+	}{
+		{name: "rollup", rollupUntil: testStart.Add(time.Hour), modify: func(r *RenderRequest) { r.Image.Height = 1 }, wantRows: 10, wantRollup: true},
+		{name: "rollup missing a row", rollupUntil: testStart.Add(5 * time.Minute), modify: func(r *RenderRequest) { r.Image.Height = 2 }},
+		{name: "rollup missing less than a row", rollupUntil: testStart.Add(9 * time.Minute), modify: func(r *RenderRequest) { r.Image.Height = 2 }, wantRows: 9, wantRollup: true},
+		{name: "no rollup", modify: func(r *RenderRequest) { r.Image.Height = 1 }},
+		{name: "rows shorter than the resolution", rollupUntil: testStart.Add(time.Hour), modify: func(r *RenderRequest) { r.Image.Height = 9 }},
+		{name: "data resolution", rollupUntil: testStart.Add(time.Hour)},
+		{name: "tags", rollupUntil: testStart.Add(time.Hour), modify: func(r *RenderRequest) {
+			r.Image.Height = 1
+			r.Filter.Tags = map[string]string{"antenna": "discone"}
+		}},
+		{name: "spectrum", rollupUntil: testStart.Add(time.Hour), modify: func(r *RenderRequest) {
+			r.Image.Height = 1
+			r.Image.Mode = ModeSpectrum
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newDB(t, tc.rollupUntil)
+			req := newTestRequest("a")
+			req.Image.Mode = ModeWaterfall
+			if tc.modify != nil {
+				tc.modify(req)
+			}
+			rows, ok := useRollup(db, req)
+			if rows != tc.wantRows || ok != tc.wantRollup {
+				t.Errorf("useRollup() = %d, %t, want %d, %t", rows, ok, tc.wantRows, tc.wantRollup)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package extraction
+
+// smooth returns the dB values of the buckets averaged with their neighbours in a size x size
+// window (moving average / box filter) to make faint signals stand out from the noise of
+// adjacent buckets. The window shrinks at the edges and around empty buckets, i.e. only
+// buckets with samples are averaged, and empty buckets stay empty.
+func smooth(dbs map[int]map[int]float32, size int) map[int]map[int]float32 {
+	if size <= 1 || len(dbs) == 0 {
+		return dbs
+	}
+	radius := size / 2
+
+	// Buckets are numbered starting at 1.
+	var rows, cols int
+	for rowIdx, row := range dbs {
+		rows = max(rows, rowIdx)
+		for colIdx := range row {
+			cols = max(cols, colIdx)
+		}
+	}
+
+	// The box filter is separable: sum up each row within the window first, then sum up
+	// these sums over the rows within the window. Counts keep track of the buckets with samples.
+	rowSums := make([]float64, (rows+1)*(cols+1))
+	rowCounts := make([]int, (rows+1)*(cols+1))
+	for rowIdx, row := range dbs {
+		for colIdx, db := range row {
+			for c := max(1, colIdx-radius); c <= min(cols, colIdx+radius); c++ {
+				rowSums[rowIdx*(cols+1)+c] += float64(db)
+				rowCounts[rowIdx*(cols+1)+c]++
+			}
+		}
+	}
+
+	smoothed := make(map[int]map[int]float32, len(dbs))
+	for rowIdx, row := range dbs {
+		smoothed[rowIdx] = make(map[int]float32, len(row))
+		for colIdx := range row {
+			var sum float64
+			var count int
+			for r := max(1, rowIdx-radius); r <= min(rows, rowIdx+radius); r++ {
+				sum += rowSums[r*(cols+1)+colIdx]
+				count += rowCounts[r*(cols+1)+colIdx]
+			}
+			smoothed[rowIdx][colIdx] = float32(sum / float64(count))
+		}
+	}
+	return smoothed
+}
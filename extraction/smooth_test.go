@@ -0,0 +1,61 @@
+package extraction
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSmooth(t *testing.T) {
+	tests := []struct {
+		name string
+		dbs  map[int]map[int]float32
+		size int
+		want map[int]map[int]float32
+	}{
+		{
+			name: "disabled",
+			dbs:  map[int]map[int]float32{1: {1: -10, 2: -20}},
+			size: 1,
+			want: map[int]map[int]float32{1: {1: -10, 2: -20}},
+		},
+		{
+			name: "single row",
+			dbs:  map[int]map[int]float32{1: {1: -10, 2: -20, 3: -60}},
+			size: 3,
+			want: map[int]map[int]float32{1: {1: -15, 2: -30, 3: -40}},
+		},
+		{
+			name: "spike is spread over its neighbours",
+			dbs: map[int]map[int]float32{
+				1: {1: 0, 2: 0, 3: 0},
+				2: {1: 0, 2: -90, 3: 0},
+				3: {1: 0, 2: 0, 3: 0},
+			},
+			size: 3,
+			want: map[int]map[int]float32{
+				1: {1: -22.5, 2: -15, 3: -22.5},
+				2: {1: -15, 2: -10, 3: -15},
+				3: {1: -22.5, 2: -15, 3: -22.5},
+			},
+		},
+		{
+			name: "empty buckets stay empty and aren't averaged",
+			dbs: map[int]map[int]float32{
+				1: {1: -10, 3: -30},
+				2: {2: -20},
+			},
+			size: 3,
+			want: map[int]map[int]float32{
+				1: {1: -15, 3: -25},
+				2: {2: -20},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := smooth(tc.dbs, tc.size); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("smooth(%d) = %v, want %v", tc.size, got, tc.want)
+			}
+		})
+	}
+}
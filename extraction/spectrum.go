@@ -0,0 +1,95 @@
+package extraction
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// ModeWaterfall renders the dB values over frequency and time as a heatmap.
+	ModeWaterfall = "waterfall"
+	// ModeSpectrum collapses the time axis and plots the dB values over frequency as a line.
+	ModeSpectrum = "spectrum"
+
+	spectrumDefaultHeight = 300 // pixels
+)
+
+// IsValidMode returns whether the given render mode exists.
+func IsValidMode(mode string) bool {
+	return mode == ModeWaterfall || mode == ModeSpectrum
+}
+
+// renderSpectrum draws the single row of a waterfall queried in ModeSpectrum as a line with
+// the lowest dB of the range at the bottom and the highest at the top. The legend is not
+// drawn as the line is not colored by dB.
+func renderSpectrum(wf *waterfall, req *RenderRequest) *RenderResult {
+	colors := req.Image.gridColors()
+	canvas := image.NewRGBA(image.Rectangle{
+		Min: image.Point{0, 0},
+		Max: image.Point{req.Image.Width, req.Image.Height},
+	})
+	draw.Draw(canvas, canvas.Bounds(), &image.Uniform{colors.Background}, canvas.Bounds().Min, draw.Src)
+
+	// With only one row, the peak across all rows is the spectrum itself.
+	columns := wf.columns(req.Image.Width, req.Image.LogFreqAxis)
+	drawPeakHold(canvas, wf.peakHold(columns, req.Image.Height), colors.Grid)
+
+	if req.Image.AddGrid {
+		canvas = drawSpectrumGrid(canvas, wf, req.Image.LogFreqAxis, req.Image.Markers, colors)
+	}
+
+	meta := wf.renderMetadata(req.Image)
+	meta.SecPerPixel = 0 // there is no time axis
+	return &RenderResult{
+		Image:      canvas,
+		SourceMeta: wf.meta,
+		ImageMeta:  meta,
+	}
+}
+
+// drawSpectrumGrid enlarges the image like DrawGrid and labels the frequency axis on top and
+// the dB axis on the left.
+func drawSpectrumGrid(source *image.RGBA, wf *waterfall, logFreq bool, markers []Marker, colors GridColors) *image.RGBA {
+	canvas := image.NewRGBA(image.Rectangle{
+		Min: source.Bounds().Min,
+		Max: image.Point{source.Bounds().Max.X + gridMarginLeft, source.Bounds().Max.Y + gridMarginTop},
+	})
+	draw.Draw(canvas, canvas.Bounds(), &image.Uniform{colors.Background}, canvas.Bounds().Min, draw.Src)
+	r := canvas.Bounds()
+	r.Min.X += gridMarginLeft
+	r.Min.Y += gridMarginTop
+	draw.Draw(canvas, r, source, source.Bounds().Min, draw.Src)
+
+	// Draw X ticks.
+	drawFreqTicks(canvas, wf.meta.LowFreq, wf.meta.HighFreq, source.Bounds().Dx(), logFreq, colors)
+	drawMarkers(canvas, markers, wf.meta.LowFreq, wf.meta.HighFreq, source.Bounds().Dx(), source.Bounds().Dy(), logFreq, colors)
+
+	// Draw Y ticks, highest dB at the top.
+	height := source.Bounds().Dy()
+	step := findGridStepSize(height, false)
+	for y := 0; y < height; y += step {
+		drawTick(canvas, image.Point{
+			canvas.Bounds().Min.X + gridMarginLeft - gridTickLen,
+			canvas.Bounds().Min.Y + gridMarginTop + y,
+		}, gridTickLen, true, colors.Grid)
+		point := fixed.Point26_6{
+			X: fixed.Int26_6((canvas.Bounds().Min.X + 5) * 64),
+			Y: fixed.Int26_6((canvas.Bounds().Min.Y + gridMarginTop + y + 5) * 64),
+		}
+		d := &font.Drawer{
+			Dst:  canvas,
+			Src:  image.NewUniform(colors.Text),
+			Face: basicfont.Face7x13,
+			Dot:  point,
+		}
+		db := wf.maxDB - float32(y)*(wf.maxDB-wf.minDB)/float32(max(height-1, 1))
+		d.DrawString(fmt.Sprintf("%.1f dB", db))
+	}
+
+	return canvas
+}
@@ -0,0 +1,152 @@
+package extraction
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	getStatsTmpl = `SELECT
+		COUNT(*),
+		MIN(FreqLow),
+		MAX(FreqHigh),
+		MIN(Start),
+		MAX(End)
+	FROM
+		spectre;`
+	getSourcesTmpl = `SELECT
+		DISTINCT(Source)
+	FROM
+		spectre
+	ORDER BY
+		Source ASC;`
+	getIdentifierSampleCountsTmpl = `SELECT
+		Identifier,
+		COUNT(*)
+	FROM
+		spectre
+	GROUP BY
+		Identifier
+	ORDER BY
+		Identifier ASC;`
+	getSourceInfosTmpl = `SELECT
+		Source,
+		Identifier,
+		MIN(FreqLow),
+		MAX(FreqHigh),
+		MIN(Start),
+		MAX(End)
+	FROM
+		spectre
+	GROUP BY
+		Source,
+		Identifier
+	ORDER BY
+		Source ASC,
+		Identifier ASC;`
+)
+
+type IdentifierStats struct {
+	Identifier  string
+	SampleCount int64
+}
+
+// SourceInfo describes the samples stored for one source (SDR type) and identifier.
+type SourceInfo struct {
+	Source     string
+	Identifier string
+	LowFreq    int64
+	HighFreq   int64
+	StartTime  time.Time
+	EndTime    time.Time
+}
+
+// Stats summarizes the samples stored in the DB.
+type Stats struct {
+	SampleCount int64
+	Sources     []string
+	Identifiers []IdentifierStats
+
+	// The following are only set if there are samples in the DB.
+	LowFreq   int64
+	HighFreq  int64
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// GetStats queries the DB for an overview of the stored samples.
+func GetStats(db *sql.DB) (*Stats, error) {
+	stats := &Stats{
+		Sources:     []string{},
+		Identifiers: []IdentifierStats{},
+	}
+
+	var lowFreq, highFreq, startTime, endTime sql.NullInt64
+	if err := db.QueryRow(getStatsTmpl).Scan(&stats.SampleCount, &lowFreq, &highFreq, &startTime, &endTime); err != nil {
+		return nil, err
+	}
+	stats.LowFreq = lowFreq.Int64
+	stats.HighFreq = highFreq.Int64
+	if startTime.Valid {
+		stats.StartTime = time.UnixMilli(startTime.Int64)
+	}
+	if endTime.Valid {
+		stats.EndTime = time.UnixMilli(endTime.Int64)
+	}
+
+	sources, err := db.Query(getSourcesTmpl)
+	if err != nil {
+		return nil, err
+	}
+	defer sources.Close()
+	for sources.Next() {
+		var source string
+		if err := sources.Scan(&source); err != nil {
+			return nil, err
+		}
+		stats.Sources = append(stats.Sources, source)
+	}
+	if err := sources.Err(); err != nil {
+		return nil, err
+	}
+
+	identifiers, err := db.Query(getIdentifierSampleCountsTmpl)
+	if err != nil {
+		return nil, err
+	}
+	defer identifiers.Close()
+	for identifiers.Next() {
+		var identifier IdentifierStats
+		if err := identifiers.Scan(&identifier.Identifier, &identifier.SampleCount); err != nil {
+			return nil, err
+		}
+		stats.Identifiers = append(stats.Identifiers, identifier)
+	}
+	if err := identifiers.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// GetSourceInfos queries the DB for all combinations of source and identifier.
+func GetSourceInfos(db *sql.DB) ([]SourceInfo, error) {
+	rows, err := db.Query(getSourceInfosTmpl)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	infos := []SourceInfo{}
+	for rows.Next() {
+		var info SourceInfo
+		var startTime, endTime int64
+		if err := rows.Scan(&info.Source, &info.Identifier, &info.LowFreq, &info.HighFreq, &startTime, &endTime); err != nil {
+			return nil, err
+		}
+		info.StartTime = time.UnixMilli(startTime)
+		info.EndTime = time.UnixMilli(endTime)
+		infos = append(infos, info)
+	}
+	return infos, rows.Err()
+}
@@ -0,0 +1,65 @@
+package extraction
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetStats(t *testing.T) {
+	db := newTestDB(t)
+	stats, err := GetStats(db)
+	if err != nil {
+		t.Fatalf("GetStats() failed: %s", err)
+	}
+	want := &Stats{
+		SampleCount: 24,
+		Sources:     []string{"rtlsdr"},
+		Identifiers: []IdentifierStats{{Identifier: "a", SampleCount: 12}, {Identifier: "b", SampleCount: 12}},
+		LowFreq:     100,
+		HighFreq:    140,
+		StartTime:   time.UnixMilli(testStart.UnixMilli()),
+		EndTime:     time.UnixMilli(testStart.Add(3 * time.Second).UnixMilli()),
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("GetStats() = %+v, want %+v", stats, want)
+	}
+}
+
+func TestGetStatsEmpty(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "spectre.db"))
+	if err != nil {
+		t.Fatalf("unable to open DB: %s", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE spectre (Identifier TEXT, Source TEXT, FreqLow INTEGER, FreqHigh INTEGER, Start INTEGER, End INTEGER);"); err != nil {
+		t.Fatalf("unable to create table: %s", err)
+	}
+	stats, err := GetStats(db)
+	if err != nil {
+		t.Fatalf("GetStats() failed: %s", err)
+	}
+	want := &Stats{Sources: []string{}, Identifiers: []IdentifierStats{}}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("GetStats() = %+v, want %+v", stats, want)
+	}
+}
+
+func TestGetSourceInfos(t *testing.T) {
+	db := newTestDB(t)
+	infos, err := GetSourceInfos(db)
+	if err != nil {
+		t.Fatalf("GetSourceInfos() failed: %s", err)
+	}
+	start := time.UnixMilli(testStart.UnixMilli())
+	end := time.UnixMilli(testStart.Add(3 * time.Second).UnixMilli())
+	want := []SourceInfo{
+		{Source: "rtlsdr", Identifier: "a", LowFreq: 100, HighFreq: 140, StartTime: start, EndTime: end},
+		{Source: "rtlsdr", Identifier: "b", LowFreq: 100, HighFreq: 140, StartTime: start, EndTime: end},
+	}
+	if !reflect.DeepEqual(infos, want) {
+		t.Errorf("GetSourceInfos() = %+v, want %+v", infos, want)
+	}
+}
@@ -0,0 +1,157 @@
+package extraction
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"html"
+	"image/color"
+	"strings"
+	"time"
+)
+
+const (
+	svgFont     = `font-family="monospace" font-size="11"`
+	svgGradient = "colorbar"
+)
+
+// RenderSVG renders the waterfall as a scalable vector graphic. Each time/frequency bucket
+// is drawn as a rectangle (horizontally adjacent buckets of the same color are merged) and
+// the grid and legend are drawn as vector lines and text.
+func RenderSVG(db *sql.DB, req *RenderRequest) (*RenderResult, error) {
+	if req.Image.Mode == ModeSpectrum {
+		return nil, fmt.Errorf("mode %q is not supported for %s images", ModeSpectrum, FormatSVG)
+	}
+	wf, err := queryWaterfall(db, req)
+	if err != nil {
+		return nil, err
+	}
+
+	width := req.Image.Width
+	height := req.Image.Height
+	left, top := 0, 0
+	if req.Image.AddGrid {
+		left, top = gridMarginLeft, gridMarginTop
+	}
+	totalWidth := left + width
+	if req.Image.AddLegend {
+		totalWidth += legendMarginLeft + legendWidth + legendLabelWidth
+	}
+	totalHeight := top + height
+
+	colors := req.Image.gridColors()
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", totalWidth, totalHeight, totalWidth, totalHeight)
+	if colors.Background.A > 0 && (req.Image.AddGrid || req.Image.AddLegend) {
+		fmt.Fprintf(buf, `<rect width="%d" height="%d" fill="%s"/>`+"\n", totalWidth, totalHeight, svgColor(colors.Background))
+	}
+
+	// Draw waterfall. Buckets are numbered starting at 1.
+	fmt.Fprintf(buf, `<g shape-rendering="crispEdges" transform="translate(%d %d)">`+"\n", left, top)
+	columns := wf.columns(width, req.Image.LogFreqAxis)
+	for rowIdx := 1; rowIdx <= height; rowIdx++ {
+		row, ok := wf.dbs[rowIdx]
+		if !ok {
+			continue
+		}
+		fills := make([]string, width)
+		for x, colIdx := range columns {
+			if db, ok := row[colIdx]; ok {
+				fills[x] = svgColor(wf.color(rowIdx, colIdx, db, req.Image))
+			}
+		}
+		for x := 0; x < width; {
+			run := 1
+			for x+run < width && fills[x+run] == fills[x] {
+				run++
+			}
+			if fills[x] != "" {
+				fmt.Fprintf(buf, `<rect x="%d" y="%d" width="%d" height="1" fill="%s"/>`+"\n", x, rowIdx-1, run, fills[x])
+			}
+			x += run
+		}
+	}
+	buf.WriteString("</g>\n")
+
+	// Draw peak hold on top of the waterfall, one polyline per range of columns with samples.
+	if req.Image.PeakHold {
+		fmt.Fprintf(buf, `<g fill="none" stroke="%s" transform="translate(%d %d)">`+"\n", svgColor(colors.Grid), left, top)
+		var points []string
+		ys := append(wf.peakHold(columns, height), -1)
+		for x, y := range ys {
+			if y >= 0 {
+				// Lines go through the center of the pixels.
+				points = append(points, fmt.Sprintf("%d.5,%d.5", x, y))
+				continue
+			}
+			if len(points) > 0 {
+				fmt.Fprintf(buf, `<polyline points="%s"/>`+"\n", strings.Join(points, " "))
+				points = nil
+			}
+		}
+		buf.WriteString("</g>\n")
+	}
+
+	// Draw grid.
+	if req.Image.AddGrid {
+		fmt.Fprintf(buf, `<g stroke="%s" fill="%s" %s>`+"\n", svgColor(colors.Grid), svgColor(colors.Text), svgFont)
+		for _, tick := range freqTicks(wf.meta.LowFreq, wf.meta.HighFreq, width, req.Image.LogFreqAxis) {
+			fmt.Fprintf(buf, `<line x1="%d" y1="%d" x2="%d" y2="%d"/>`+"\n", left+tick.x, top-gridTickLen, left+tick.x, top)
+			fmt.Fprintf(buf, `<text x="%d" y="%d" stroke="none">%s</text>`+"\n", left+tick.x+5, top-2, GetReadableFreq(tick.freq))
+		}
+		for _, m := range req.Image.Markers {
+			x, ok := markerPosition(m, wf.meta.LowFreq, wf.meta.HighFreq, width, req.Image.LogFreqAxis)
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(buf, `<line x1="%d.5" y1="%d" x2="%d.5" y2="%d" stroke-dasharray="%d"/>`+"\n", left+x, top, left+x, top+height, markerDashLen)
+			if m.Label != "" {
+				fmt.Fprintf(buf, `<text x="%d" y="%d" stroke="none">%s</text>`+"\n", left+x+3, top+markerLabelTop, html.EscapeString(m.Label))
+			}
+		}
+		yStep := findGridStepSize(height, false)
+		for i := 0; i < height; i += yStep {
+			fmt.Fprintf(buf, `<line x1="%d" y1="%d" x2="%d" y2="%d"/>`+"\n", left-gridTickLen, top+i, left, top+i)
+			dur := time.Duration((int64(i)*wf.meta.EndTime.Sub(wf.meta.StartTime).Milliseconds())/int64(height)) * time.Millisecond
+			fmt.Fprintf(buf, `<text x="5" y="%d" stroke="none">%s</text>`+"\n", top+i+5, dur)
+			fmt.Fprintf(buf, `<text x="5" y="%d" stroke="none">%s</text>`+"\n", top+i+17, wf.meta.StartTime.Add(dur).Format(timeFmt))
+		}
+		buf.WriteString("</g>\n")
+	}
+
+	// Draw legend, warmest color at the top.
+	if req.Image.AddLegend {
+		gradient, ok := palettes[req.Image.Palette]
+		if !ok {
+			gradient = palettes[PaletteDefault]
+		}
+		legendLeft := left + width + legendMarginLeft
+		fmt.Fprintf(buf, `<defs><linearGradient id="%s" x1="0" y1="1" x2="0" y2="0">`+"\n", svgGradient)
+		for i := 0; i < len(gradient); i++ {
+			fmt.Fprintf(buf, `<stop offset="%.3f" stop-color="%s"/>`+"\n", float64(i)/float64(len(gradient)-1), svgColor(gradient[i]))
+		}
+		buf.WriteString("</linearGradient></defs>\n")
+		fmt.Fprintf(buf, `<rect x="%d" y="%d" width="%d" height="%d" fill="url(#%s)"/>`+"\n", legendLeft, top, legendWidth, height, svgGradient)
+
+		fmt.Fprintf(buf, `<g stroke="%s" fill="%s" %s>`+"\n", svgColor(colors.Grid), svgColor(colors.Text), svgFont)
+		step := findGridStepSize(height, false)
+		for y := 0; y < height; y += step {
+			fmt.Fprintf(buf, `<line x1="%d" y1="%d" x2="%d" y2="%d"/>`+"\n", legendLeft+legendWidth, top+y, legendLeft+legendWidth+gridTickLen/2, top+y)
+			db := wf.maxDB - float32(y)*(wf.maxDB-wf.minDB)/float32(max(height-1, 1))
+			fmt.Fprintf(buf, `<text x="%d" y="%d" stroke="none">%.1f dB</text>`+"\n", legendLeft+legendWidth+gridTickLen/2+3, top+y+5, db)
+		}
+		buf.WriteString("</g>\n")
+	}
+	buf.WriteString("</svg>\n")
+
+	return &RenderResult{
+		SVG:        buf.Bytes(),
+		SourceMeta: wf.meta,
+		ImageMeta:  wf.renderMetadata(req.Image),
+	}, nil
+}
+
+// svgColor returns the color in hex notation, e.g. "#ff0000".
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
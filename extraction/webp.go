@@ -0,0 +1,433 @@
+package extraction
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+// This is a minimal lossless WebP (VP8L) encoder. It only uses backward references
+// and entropy coding (prefix codes) without transforms or color cache which keeps it
+// simple while still compressing the repetitive rows of a waterfall well.
+// See https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification.
+
+const (
+	webpMaxDimension      = 1 << 14
+	webpMaxCodeLength     = 15
+	webpMaxCodeLengthCode = 7
+	webpNumLiteralCodes   = 256
+	webpNumLengthCodes    = 24
+	webpNumDistanceCodes  = 40
+
+	webpMinMatchLength = 3
+	webpMaxMatchLength = 4096
+	webpWindowSize     = 1 << 18
+	webpHashBits       = 16
+	webpMaxChainLength = 32
+	// Distance codes up to 120 refer to a 2D neighborhood, the first two of which
+	// are the pixel above and the pixel to the left.
+	webpDistanceCodeAbove = 1
+	webpDistanceCodeLeft  = 2
+	webpDistanceCodeShift = 120
+)
+
+// webpCodeLengthCodeOrder is the order in which the code length code lengths are stored.
+var webpCodeLengthCodeOrder = []int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// EncodeWebP writes the image to w in the lossless WebP format.
+func EncodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > webpMaxDimension || b.Dy() > webpMaxDimension {
+		return errors.New("WebP images need to be between 1x1 and 16384x16384 pixels")
+	}
+	nrgba := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), img, b.Min, draw.Src)
+
+	argb := make([]uint32, b.Dx()*b.Dy())
+	alpha := false
+	for i := range argb {
+		p := nrgba.Pix[4*i : 4*i+4]
+		argb[i] = uint32(p[3])<<24 | uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+		if p[3] != 0xff {
+			alpha = true
+		}
+	}
+	tokens := webpBackwardReferences(argb, b.Dx())
+
+	// Collect the symbol frequencies of the green (including lengths), red, blue,
+	// alpha and distance alphabets. The order is the one used in the bitstream.
+	freqs := [5][]int{
+		make([]int, webpNumLiteralCodes+webpNumLengthCodes),
+		make([]int, webpNumLiteralCodes),
+		make([]int, webpNumLiteralCodes),
+		make([]int, webpNumLiteralCodes),
+		make([]int, webpNumDistanceCodes),
+	}
+	for _, t := range tokens {
+		if t.length == 0 {
+			freqs[0][(t.argb>>8)&0xff]++
+			freqs[1][(t.argb>>16)&0xff]++
+			freqs[2][t.argb&0xff]++
+			freqs[3][t.argb>>24]++
+			continue
+		}
+		lengthCode, _, _ := webpPrefixEncode(t.length)
+		freqs[0][webpNumLiteralCodes+lengthCode]++
+		distCode, _, _ := webpPrefixEncode(t.distCode)
+		freqs[4][distCode]++
+	}
+
+	bw := &webpBitWriter{}
+	// Header: signature, dimensions, alpha hint and version.
+	bw.writeBits(0x2f, 8)
+	bw.writeBits(uint32(b.Dx()-1), 14)
+	bw.writeBits(uint32(b.Dy()-1), 14)
+	if alpha {
+		bw.writeBits(1, 1)
+	} else {
+		bw.writeBits(0, 1)
+	}
+	bw.writeBits(0, 3)
+	// No transforms, no color cache and no meta prefix codes.
+	bw.writeBits(0, 1)
+	bw.writeBits(0, 1)
+	bw.writeBits(0, 1)
+
+	// Prefix codes for green, red, blue, alpha and distance.
+	var codes [5]webpPrefixCode
+	for i, f := range freqs {
+		codes[i] = bw.writePrefixCode(f)
+	}
+
+	// Pixel data.
+	for _, t := range tokens {
+		if t.length == 0 {
+			codes[0].write(bw, int((t.argb>>8)&0xff))
+			codes[1].write(bw, int((t.argb>>16)&0xff))
+			codes[2].write(bw, int(t.argb&0xff))
+			codes[3].write(bw, int(t.argb>>24))
+			continue
+		}
+		lengthCode, extraBits, extra := webpPrefixEncode(t.length)
+		codes[0].write(bw, webpNumLiteralCodes+lengthCode)
+		bw.writeBits(extra, extraBits)
+		distCode, extraBits, extra := webpPrefixEncode(t.distCode)
+		codes[4].write(bw, distCode)
+		bw.writeBits(extra, extraBits)
+	}
+	data := bw.bytes()
+
+	// RIFF container.
+	chunkSize := len(data)
+	padding := chunkSize % 2
+	header := make([]byte, 20)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(4+8+chunkSize+padding))
+	copy(header[8:12], "WEBP")
+	copy(header[12:16], "VP8L")
+	binary.LittleEndian.PutUint32(header[16:20], uint32(chunkSize))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if padding != 0 {
+		if _, err := w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webpToken is either a literal pixel (length 0) or a backward reference.
+type webpToken struct {
+	argb     uint32
+	length   int
+	distCode int
+}
+
+// webpBackwardReferences finds repetitions using a hash chain and returns the image as tokens.
+func webpBackwardReferences(argb []uint32, width int) []webpToken {
+	hash := func(i int) uint32 {
+		return ((argb[i] * 0x1e35a7bd) ^ (argb[i+1] * 0x9e3779b1)) >> (32 - webpHashBits)
+	}
+	matchLength := func(i, j int) int {
+		n := 0
+		for i+n < len(argb) && n < webpMaxMatchLength && argb[i+n] == argb[j+n] {
+			n++
+		}
+		return n
+	}
+
+	head := make([]int, 1<<webpHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int, len(argb))
+	insert := func(i int) {
+		if i+1 >= len(argb) {
+			return
+		}
+		h := hash(i)
+		prev[i] = head[h]
+		head[h] = i
+	}
+
+	var tokens []webpToken
+	for i := 0; i < len(argb); {
+		bestLength, bestDistance := 0, 0
+		// Always consider the pixel above and the pixel to the left as they are cheap to encode.
+		for _, d := range []int{width, 1} {
+			if i-d >= 0 {
+				if l := matchLength(i, i-d); l > bestLength {
+					bestLength, bestDistance = l, d
+				}
+			}
+		}
+		if i+1 < len(argb) {
+			for j, chain := head[hash(i)], 0; j >= 0 && i-j <= webpWindowSize && chain < webpMaxChainLength; j, chain = prev[j], chain+1 {
+				if l := matchLength(i, j); l > bestLength {
+					bestLength, bestDistance = l, i-j
+				}
+			}
+		}
+
+		if bestLength < webpMinMatchLength {
+			tokens = append(tokens, webpToken{argb: argb[i]})
+			insert(i)
+			i++
+			continue
+		}
+		distCode := bestDistance + webpDistanceCodeShift
+		switch bestDistance {
+		case width:
+			distCode = webpDistanceCodeAbove
+		case 1:
+			distCode = webpDistanceCodeLeft
+		}
+		tokens = append(tokens, webpToken{length: bestLength, distCode: distCode})
+		for k := 0; k < bestLength; k++ {
+			insert(i + k)
+		}
+		i += bestLength
+	}
+	return tokens
+}
+
+// webpPrefixEncode splits a length or distance code into its prefix code and extra bits.
+func webpPrefixEncode(value int) (int, uint, uint32) {
+	v := value - 1
+	if v < 4 {
+		return v, 0, 0
+	}
+	highest := bits.Len(uint(v)) - 1
+	second := (v >> (highest - 1)) & 1
+	extraBits := uint(highest - 1)
+	return 2*highest + second, extraBits, uint32(v) & (1<<extraBits - 1)
+}
+
+// webpBitWriter writes bits LSB first as required by VP8L.
+type webpBitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (bw *webpBitWriter) writeBits(v uint32, n uint) {
+	bw.acc |= uint64(v) << bw.nbits
+	bw.nbits += n
+	for bw.nbits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc >>= 8
+		bw.nbits -= 8
+	}
+}
+
+func (bw *webpBitWriter) bytes() []byte {
+	if bw.nbits > 0 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc = 0
+		bw.nbits = 0
+	}
+	return bw.buf
+}
+
+// webpPrefixCode holds the bit reversed canonical code and its length per symbol.
+type webpPrefixCode struct {
+	codes   []uint32
+	lengths []int
+}
+
+func (c webpPrefixCode) write(bw *webpBitWriter, symbol int) {
+	if c.lengths[symbol] > 0 {
+		bw.writeBits(c.codes[symbol], uint(c.lengths[symbol]))
+	}
+}
+
+// writePrefixCode builds a prefix code from the symbol frequencies, writes it and returns it.
+func (bw *webpBitWriter) writePrefixCode(freqs []int) webpPrefixCode {
+	var used []int
+	for symbol, f := range freqs {
+		if f > 0 {
+			used = append(used, symbol)
+		}
+	}
+
+	// Use a simple code if at most one symbol is used: decoding it then consumes no bits.
+	if len(used) <= 1 && (len(used) == 0 || used[0] < webpNumLiteralCodes) {
+		symbol := 0
+		if len(used) == 1 {
+			symbol = used[0]
+		}
+		bw.writeBits(1, 1) // simple code
+		bw.writeBits(0, 1) // one symbol
+		if symbol < 2 {
+			bw.writeBits(0, 1)
+			bw.writeBits(uint32(symbol), 1)
+		} else {
+			bw.writeBits(1, 1)
+			bw.writeBits(uint32(symbol), 8)
+		}
+		return webpPrefixCode{
+			codes:   make([]uint32, len(freqs)),
+			lengths: make([]int, len(freqs)),
+		}
+	}
+
+	lengths := webpCodeLengths(freqs, webpMaxCodeLength)
+	code := webpPrefixCode{
+		codes:   webpCanonicalCodes(lengths),
+		lengths: lengths,
+	}
+
+	// The code lengths themselves are encoded with the code length code (symbols 0-15 only).
+	clFreqs := make([]int, len(webpCodeLengthCodeOrder))
+	for _, l := range lengths {
+		clFreqs[l]++
+	}
+	clLengths := webpCodeLengths(clFreqs, webpMaxCodeLengthCode)
+	clCodes := webpCanonicalCodes(clLengths)
+
+	numCodeLengths := len(webpCodeLengthCodeOrder)
+	for numCodeLengths > 4 && clLengths[webpCodeLengthCodeOrder[numCodeLengths-1]] == 0 {
+		numCodeLengths--
+	}
+	bw.writeBits(0, 1) // normal code
+	bw.writeBits(uint32(numCodeLengths-4), 4)
+	for i := 0; i < numCodeLengths; i++ {
+		bw.writeBits(uint32(clLengths[webpCodeLengthCodeOrder[i]]), 3)
+	}
+	bw.writeBits(0, 1) // code lengths for all symbols follow
+	for _, l := range lengths {
+		bw.writeBits(clCodes[l], uint(clLengths[l]))
+	}
+
+	return code
+}
+
+// webpCodeLengths computes Huffman code lengths limited to maxLength. At least two
+// symbols are always assigned a length to produce a complete code.
+func webpCodeLengths(freqs []int, maxLength int) []int {
+	f := make([]int, len(freqs))
+	copy(f, freqs)
+	var used int
+	for _, v := range f {
+		if v > 0 {
+			used++
+		}
+	}
+	for i := 0; used < 2 && i < len(f); i++ {
+		if f[i] == 0 {
+			f[i] = 1
+			used++
+		}
+	}
+
+	for {
+		lengths := webpHuffmanLengths(f)
+		max := 0
+		for _, l := range lengths {
+			if l > max {
+				max = l
+			}
+		}
+		if max <= maxLength {
+			return lengths
+		}
+		// Flatten the distribution until the code fits.
+		for i, v := range f {
+			if v > 0 {
+				f[i] = (v + 1) / 2
+			}
+		}
+	}
+}
+
+// webpHuffmanLengths computes the unrestricted Huffman code lengths for the used symbols.
+func webpHuffmanLengths(freqs []int) []int {
+	type node struct {
+		freq        int
+		symbol      int // -1 for inner nodes
+		left, right *node
+	}
+	var nodes []*node
+	for symbol, f := range freqs {
+		if f > 0 {
+			nodes = append(nodes, &node{freq: f, symbol: symbol})
+		}
+	}
+	for len(nodes) > 1 {
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].freq < nodes[j].freq })
+		parent := &node{freq: nodes[0].freq + nodes[1].freq, symbol: -1, left: nodes[0], right: nodes[1]}
+		nodes = append([]*node{parent}, nodes[2:]...)
+	}
+
+	lengths := make([]int, len(freqs))
+	var walk func(n *node, depth int)
+	walk = func(n *node, depth int) {
+		if n.symbol >= 0 {
+			lengths[n.symbol] = depth
+			return
+		}
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	walk(nodes[0], 0)
+	return lengths
+}
+
+// webpCanonicalCodes assigns canonical codes to the code lengths and returns them bit reversed.
+func webpCanonicalCodes(lengths []int) []uint32 {
+	var count [webpMaxCodeLength + 1]uint32
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [webpMaxCodeLength + 1]uint32
+	code := uint32(0)
+	for l := 1; l <= webpMaxCodeLength; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+
+	codes := make([]uint32, len(lengths))
+	for symbol, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		// Reverse the bits as codes are read MSB first but written LSB first.
+		var reversed uint32
+		for i := 0; i < l; i++ {
+			reversed = reversed<<1 | (c>>i)&1
+		}
+		codes[symbol] = reversed
+	}
+	return codes
+}
@@ -0,0 +1,164 @@
+package extraction
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/bits"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestEncodeWebP(t *testing.T) {
+	tests := []struct {
+		name string
+		img  func() image.Image
+	}{
+		{
+			name: "single pixel",
+			img: func() image.Image {
+				img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+				img.Set(0, 0, color.NRGBA{R: 12, G: 34, B: 56, A: 255})
+				return img
+			},
+		},
+		{
+			name: "uniform",
+			img: func() image.Image {
+				img := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+				for i := range img.Pix {
+					img.Pix[i] = 0x80
+				}
+				return img
+			},
+		},
+		{
+			name: "palette with odd width",
+			img: func() image.Image {
+				palette := color.Palette{
+					color.NRGBA{R: 0, G: 0, B: 0, A: 255},
+					color.NRGBA{R: 255, G: 0, B: 0, A: 255},
+					color.NRGBA{R: 0, G: 255, B: 0, A: 255},
+					color.NRGBA{R: 0, G: 0, B: 255, A: 255},
+					color.NRGBA{R: 255, G: 255, B: 255, A: 255},
+				}
+				img := image.NewPaletted(image.Rect(0, 0, 37, 19), palette)
+				for i := range img.Pix {
+					img.Pix[i] = uint8((i * 7 / 3) % len(palette))
+				}
+				return img
+			},
+		},
+		{
+			name: "transparency",
+			img: func() image.Image {
+				img := image.NewNRGBA(image.Rect(0, 0, 33, 17))
+				for y := 0; y < 17; y++ {
+					for x := 0; x < 33; x++ {
+						img.Set(x, y, color.NRGBA{R: uint8(x * 7), G: uint8(y * 13), B: 200, A: uint8((x + y) * 8)})
+					}
+				}
+				return img
+			},
+		},
+		{
+			name: "long runs",
+			img: func() image.Image {
+				// Rows longer than the maximum match length made of few long runs and repeated rows.
+				img := image.NewNRGBA(image.Rect(0, 0, 5001, 9))
+				for y := 0; y < 9; y++ {
+					for x := 0; x < 5001; x++ {
+						c := color.NRGBA{R: 10, G: 20, B: 30, A: 255}
+						if x > 4500 && y%3 == 0 {
+							c = color.NRGBA{R: 250, G: uint8(y), B: 0, A: 255}
+						}
+						img.Set(x, y, c)
+					}
+				}
+				return img
+			},
+		},
+		{
+			name: "noise",
+			img: func() image.Image {
+				r := rand.New(rand.NewSource(1))
+				img := image.NewNRGBA(image.Rect(0, 0, 101, 53))
+				r.Read(img.Pix)
+				return img
+			},
+		},
+		{
+			name: "skewed histogram",
+			img: func() image.Image {
+				// Halving symbol frequencies need code lengths above the limit of 15 bits.
+				img := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+				for i := 0; i < 256*256; i++ {
+					img.Pix[4*i] = uint8(i)
+					img.Pix[4*i+1] = uint8(bits.TrailingZeros(uint(i + 1)))
+					img.Pix[4*i+2] = uint8(i / 256)
+					img.Pix[4*i+3] = 255
+				}
+				return img
+			},
+		},
+		{
+			name: "offset bounds",
+			img: func() image.Image {
+				img := image.NewNRGBA(image.Rect(5, 7, 20, 30))
+				for y := 7; y < 30; y++ {
+					for x := 5; x < 20; x++ {
+						img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: uint8(x * y), A: 255})
+					}
+				}
+				return img
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			img := tc.img()
+			var buf bytes.Buffer
+			if err := EncodeWebP(&buf, img); err != nil {
+				t.Fatalf("EncodeWebP() error = %v", err)
+			}
+			got, err := webp.Decode(&buf)
+			if err != nil {
+				t.Fatalf("webp.Decode() error = %v", err)
+			}
+			b := img.Bounds()
+			if got.Bounds().Dx() != b.Dx() || got.Bounds().Dy() != b.Dy() {
+				t.Fatalf("decoded bounds = %v, want size of %v", got.Bounds(), b)
+			}
+			for y := 0; y < b.Dy(); y++ {
+				for x := 0; x < b.Dx(); x++ {
+					want := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y))
+					if g := color.NRGBAModel.Convert(got.At(got.Bounds().Min.X+x, got.Bounds().Min.Y+y)); g != want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, g, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeWebPInvalidSize(t *testing.T) {
+	tests := []struct {
+		name string
+		rect image.Rectangle
+	}{
+		{name: "empty", rect: image.Rect(0, 0, 0, 10)},
+		{name: "too wide", rect: image.Rect(0, 0, webpMaxDimension+1, 1)},
+		{name: "too high", rect: image.Rect(0, 0, 1, webpMaxDimension+1)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := EncodeWebP(&bytes.Buffer{}, image.NewNRGBA(tc.rect)); err == nil {
+				t.Error("EncodeWebP() error = nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package filter
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+// dedupKey identifies a sample, samples with the same key are duplicates.
+type dedupKey struct {
+	identifier string
+	source     string
+	freqCenter int64
+	start      int64
+}
+
+// FilterDedup ignores samples which have already been seen, e.g. when a batch is resent.
+// It remembers the keys (identifier, source, center frequency and start) of the last Size samples,
+// duplicates of older samples are not detected.
+type FilterDedup struct {
+	// Size is the number of recently seen samples to remember.
+	Size int
+
+	mu sync.Mutex
+	// recent holds the keys with the most recently seen one at the front.
+	recent *list.List
+	seen   map[dedupKey]*list.Element
+}
+
+func (f *FilterDedup) ShouldIgnore(s *sdr.Sample) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.recent == nil {
+		f.recent = list.New()
+		f.seen = map[dedupKey]*list.Element{}
+	}
+
+	key := newDedupKey(s)
+	if elem, ok := f.seen[key]; ok {
+		f.recent.MoveToFront(elem)
+		return true
+	}
+	f.seen[key] = f.recent.PushFront(key)
+	for f.recent.Len() > max(f.Size, 1) {
+		delete(f.seen, f.recent.Remove(f.recent.Back()).(dedupKey))
+	}
+	return false
+}
+
+// Forget removes the sample from the recently seen ones so it isn't ignored when it is seen
+// again, e.g. because it couldn't be processed and is going to be resent.
+func (f *FilterDedup) Forget(s *sdr.Sample) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := newDedupKey(s)
+	if elem, ok := f.seen[key]; ok {
+		f.recent.Remove(elem)
+		delete(f.seen, key)
+	}
+}
+
+func newDedupKey(s *sdr.Sample) dedupKey {
+	return dedupKey{
+		identifier: s.Identifier,
+		source:     s.Source,
+		freqCenter: s.FreqCenter,
+		start:      s.Start.UnixNano(),
+	}
+}
@@ -10,7 +10,10 @@ func Filter(input <-chan sdr.Sample, output chan<- sdr.Sample, filters []Filtere
 	for s := range input {
 		skip := false
 		for _, f := range filters {
-			skip = f.ShouldIgnore(&s)
+			if f.ShouldIgnore(&s) {
+				skip = true
+				break
+			}
 		}
 		if skip {
 			continue
@@ -20,6 +23,8 @@ func Filter(input <-chan sdr.Sample, output chan<- sdr.Sample, filters []Filtere
 	return nil
 }
 
+// FilterFreq ignores samples outside of the frequency range. Samples overlapping one of
+// the boundaries are kept.
 type FilterFreq struct {
 	FreqHigh int64
 	FreqLow  int64
@@ -36,3 +41,22 @@ func (f *FilterFreq) ShouldIgnore(s *sdr.Sample) bool {
 	}
 	return false
 }
+
+// FilterDB ignores samples which are below a given power threshold (noise floor).
+// Set a threshold to math.Inf(-1) to disable it.
+type FilterDB struct {
+	// MinDBAvg is the lowest average power in dB a sample needs to be kept.
+	MinDBAvg float64
+	// MinDBHigh is the lowest peak power in dB a sample needs to be kept.
+	MinDBHigh float64
+}
+
+func (f *FilterDB) ShouldIgnore(s *sdr.Sample) bool {
+	if s.DBAvg < f.MinDBAvg {
+		return true
+	}
+	if s.DBHigh < f.MinDBHigh {
+		return true
+	}
+	return false
+}
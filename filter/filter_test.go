@@ -0,0 +1,163 @@
+package filter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+func TestFilterFreq(t *testing.T) {
+	f := &FilterFreq{FreqLow: 100, FreqHigh: 200}
+	tests := []struct {
+		name       string
+		low, high  int64
+		wantIgnore bool
+	}{
+		{name: "inside", low: 120, high: 130},
+		{name: "covering the range", low: 50, high: 250},
+		{name: "overlapping low boundary", low: 90, high: 110},
+		{name: "overlapping high boundary", low: 190, high: 210},
+		{name: "touching low boundary", low: 90, high: 100},
+		{name: "touching high boundary", low: 200, high: 210},
+		{name: "below", low: 80, high: 99, wantIgnore: true},
+		{name: "above", low: 201, high: 220, wantIgnore: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &sdr.Sample{FreqLow: tc.low, FreqHigh: tc.high}
+			if got := f.ShouldIgnore(s); got != tc.wantIgnore {
+				t.Errorf("ShouldIgnore(%d-%d Hz) = %t, want %t", tc.low, tc.high, got, tc.wantIgnore)
+			}
+		})
+	}
+}
+
+func TestFilterFreqAbove32Bits(t *testing.T) {
+	// The bounds don't fit in 32 bits, run with GOARCH=386 to check 32-bit platforms.
+	f := &FilterFreq{FreqLow: 2400000000, FreqHigh: 2500000000}
+	tests := []struct {
+		name       string
+		low, high  int64
+		wantIgnore bool
+	}{
+		{name: "inside", low: 2450000000, high: 2450100000},
+		{name: "below", low: 2300000000, high: 2399999999, wantIgnore: true},
+		{name: "above", low: 2500000001, high: 2600000000, wantIgnore: true},
+		// 2.4 GHz truncated to 32 bits would be negative.
+		{name: "below 2^31", low: 100000000, high: 200000000, wantIgnore: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &sdr.Sample{FreqLow: tc.low, FreqHigh: tc.high}
+			if got := f.ShouldIgnore(s); got != tc.wantIgnore {
+				t.Errorf("ShouldIgnore(%d-%d Hz) = %t, want %t", tc.low, tc.high, got, tc.wantIgnore)
+			}
+		})
+	}
+}
+
+func TestFilterDB(t *testing.T) {
+	tests := []struct {
+		name       string
+		filter     FilterDB
+		avg, high  float64
+		wantIgnore bool
+	}{
+		{name: "disabled", filter: FilterDB{MinDBAvg: math.Inf(-1), MinDBHigh: math.Inf(-1)}, avg: -120, high: -120},
+		{name: "above thresholds", filter: FilterDB{MinDBAvg: -60, MinDBHigh: -50}, avg: -55, high: -40},
+		{name: "at thresholds", filter: FilterDB{MinDBAvg: -60, MinDBHigh: -50}, avg: -60, high: -50},
+		{name: "average below threshold", filter: FilterDB{MinDBAvg: -60, MinDBHigh: math.Inf(-1)}, avg: -61, high: -10, wantIgnore: true},
+		{name: "peak below threshold", filter: FilterDB{MinDBAvg: math.Inf(-1), MinDBHigh: -50}, avg: -10, high: -51, wantIgnore: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &sdr.Sample{DBAvg: tc.avg, DBHigh: tc.high}
+			if got := tc.filter.ShouldIgnore(s); got != tc.wantIgnore {
+				t.Errorf("ShouldIgnore(avg %g dB, high %g dB) = %t, want %t", tc.avg, tc.high, got, tc.wantIgnore)
+			}
+		})
+	}
+}
+
+func TestFilterDedup(t *testing.T) {
+	start := time.Unix(100, 0)
+	a := sdr.Sample{Identifier: "a", Source: "rtlsdr", FreqCenter: 100, Start: start}
+	b := sdr.Sample{Identifier: "b", Source: "rtlsdr", FreqCenter: 100, Start: start}
+	c := sdr.Sample{Identifier: "a", Source: "rtlsdr", FreqCenter: 200, Start: start}
+	d := sdr.Sample{Identifier: "a", Source: "rtlsdr", FreqCenter: 100, Start: start.Add(time.Second)}
+	e := sdr.Sample{Identifier: "a", Source: "hackrf", FreqCenter: 100, Start: start}
+	tests := []struct {
+		name       string
+		size       int
+		samples    []sdr.Sample
+		wantIgnore []bool
+	}{
+		{name: "distinct samples", size: 10, samples: []sdr.Sample{a, b, c, d, e}, wantIgnore: []bool{false, false, false, false, false}},
+		{name: "duplicate", size: 10, samples: []sdr.Sample{a, b, a}, wantIgnore: []bool{false, false, true}},
+		{name: "forgotten duplicate", size: 2, samples: []sdr.Sample{a, b, c, a}, wantIgnore: []bool{false, false, false, false}},
+		// Seeing a again keeps it while b is forgotten.
+		{name: "recently seen is kept", size: 2, samples: []sdr.Sample{a, b, a, c, a, b}, wantIgnore: []bool{false, false, true, false, true, false}},
+		{name: "zero size remembers the last sample", size: 0, samples: []sdr.Sample{a, a, b, a}, wantIgnore: []bool{false, true, false, false}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &FilterDedup{Size: tc.size}
+			for i, s := range tc.samples {
+				if got := f.ShouldIgnore(&s); got != tc.wantIgnore[i] {
+					t.Errorf("ShouldIgnore(sample %d) = %t, want %t", i, got, tc.wantIgnore[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFilterDedupForget(t *testing.T) {
+	a := sdr.Sample{Identifier: "a", Source: "rtlsdr", FreqCenter: 100, Start: time.Unix(100, 0)}
+	b := a
+	b.Identifier = "b"
+	f := &FilterDedup{Size: 10}
+	f.Forget(&a) // nothing seen yet
+	for _, s := range []sdr.Sample{a, b} {
+		if f.ShouldIgnore(&s) {
+			t.Fatalf("ShouldIgnore(%s) = true for a new sample", s.Identifier)
+		}
+	}
+	f.Forget(&a)
+	if f.ShouldIgnore(&a) {
+		t.Error("ShouldIgnore() = true for a forgotten sample, want false")
+	}
+	if !f.ShouldIgnore(&b) {
+		t.Error("ShouldIgnore() = false for a duplicate, want true")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	input := make(chan sdr.Sample, 4)
+	for _, s := range []sdr.Sample{
+		{FreqLow: 100, FreqHigh: 110, DBAvg: -10, DBHigh: -10},
+		{FreqLow: 300, FreqHigh: 310, DBAvg: -10, DBHigh: -10}, // outside of the frequency range
+		{FreqLow: 120, FreqHigh: 130, DBAvg: -90, DBHigh: -90}, // below the noise floor
+		{FreqLow: 140, FreqHigh: 150, DBAvg: -20, DBHigh: -20},
+	} {
+		input <- s
+	}
+	close(input)
+	output := make(chan sdr.Sample, 4)
+	filters := []Filterer{
+		&FilterFreq{FreqLow: 100, FreqHigh: 200},
+		&FilterDB{MinDBAvg: -50, MinDBHigh: math.Inf(-1)},
+	}
+	if err := Filter(input, output, filters); err != nil {
+		t.Fatalf("Filter() failed: %s", err)
+	}
+	close(output)
+	var got []int64
+	for s := range output {
+		got = append(got, s.FreqLow)
+	}
+	if len(got) != 2 || got[0] != 100 || got[1] != 140 {
+		t.Errorf("Filter() kept samples starting at %v Hz, want [100 140]", got)
+	}
+}
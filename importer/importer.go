@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/hb9tf/spectre/export"
+	"github.com/hb9tf/spectre/logging"
+	"github.com/hb9tf/spectre/sdr"
+
+	// Blind import support for sqlite3 used by the sqlite storage.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Flags
+var (
+	storage = flag.String("storage", "", "Storage solution to import the samples into (one of: sqlite, mysql)")
+
+	// SQL (sqlite and mysql)
+	sqlBatchSize = flag.Int("sqlBatchSize", 1000, "Maximum number of samples to insert in one transaction.")
+	sqlUpsert    = flag.Bool("sqlUpsert", false, "Replace samples which are already stored (same identifier, source, center frequency and start) instead of inserting duplicates, e.g. when importing a file again.")
+
+	// SQLite
+	sqliteFile = flag.String("sqliteFile", "/tmp/spectre", "File path of the sqlite DB file to use.")
+
+	// MySQL
+	mysqlServer       = flag.String("mysqlServer", "127.0.0.1:3306", "MySQL TCP server endpoint to connect to (IP/DNS and port).")
+	mysqlUser         = flag.String("mysqlUser", "", "MySQL DB user.")
+	mysqlPasswordFile = flag.String("mysqlPasswordFile", "", "Path to the file containing the password for the MySQL user.")
+	mysqlDBName       = flag.String("mysqlDBName", "spectre", "Name of the DB to use.")
+
+	// Logging
+	logFormat = flag.String("logFormat", logging.FormatText, "Log format (one of: text, json).")
+	logLevel  = flag.String("logLevel", "info", "Minimum level of messages to log (one of: debug, info, warn, error).")
+)
+
+func main() {
+	ctx := context.Background()
+	// Parse flags globally.
+	flag.Parse()
+	if err := logging.Setup(*logFormat, *logLevel); err != nil {
+		logging.Exit("unable to set up logging", "error", err)
+	}
+
+	files := flag.Args()
+	if len(files) == 0 {
+		logging.Exit("no CSV files to import given, pass them as arguments")
+	}
+
+	// Storage setup
+	var db *sql.DB
+	var dialect string
+	switch strings.ToLower(*storage) {
+	case "sqlite":
+		var err error
+		db, err = sql.Open("sqlite3", *sqliteFile)
+		if err != nil {
+			logging.Exit("unable to open sqlite DB", "file", *sqliteFile, "error", err)
+		}
+		dialect = export.DialectSQLite
+	case "mysql":
+		pass, err := os.ReadFile(*mysqlPasswordFile)
+		if err != nil {
+			logging.Exit("unable to read MySQL password file", "file", *mysqlPasswordFile, "error", err)
+		}
+		cfg := mysql.Config{
+			User:   *mysqlUser,
+			Passwd: strings.TrimSpace(string(pass)),
+			Net:    "tcp",
+			Addr:   *mysqlServer,
+			DBName: *mysqlDBName,
+		}
+		db, err = sql.Open("mysql", cfg.FormatDSN())
+		if err != nil {
+			logging.Exit("unable to open MySQL DB", "server", *mysqlServer, "error", err)
+		}
+		db.SetConnMaxLifetime(3 * time.Minute)
+		dialect = export.DialectMySQL
+	default:
+		logging.Exit("unsupported storage, pick one of: sqlite, mysql", "storage", *storage)
+	}
+	defer db.Close()
+
+	// The exporter is only called from its own goroutine, so the counter needs no locking.
+	var failed int
+	exporter := &export.SQL{
+		DB:      db,
+		Dialect: dialect,
+		OnInsertError: func(sdr.Sample, error) {
+			failed++
+		},
+		BatchSize: *sqlBatchSize,
+		Upsert:    *sqlUpsert,
+	}
+	samples := make(chan sdr.Sample, *sqlBatchSize)
+	exportErr := make(chan error, 1)
+	go func() {
+		exportErr <- exporter.Write(ctx, samples)
+	}()
+
+	var read, failedFiles int
+	for _, file := range files {
+		count, err := importFile(ctx, file, samples)
+		read += count
+		if err != nil {
+			slog.Error("unable to import file", "file", file, "error", err)
+			failedFiles++
+			continue
+		}
+		fmt.Printf("Read %d samples from %q\n", count, file)
+	}
+	close(samples)
+	if err := <-exportErr; err != nil {
+		logging.Exit("unable to store samples", "error", err)
+	}
+
+	fmt.Printf("Imported %d of %d samples (%d failed) from %d of %d files\n", read-failed, read, failed, len(files)-failedFiles, len(files))
+	if failed > 0 || failedFiles > 0 {
+		os.Exit(1)
+	}
+}
+
+// importFile reads all samples of the CSV file into the samples channel.
+func importFile(ctx context.Context, file string, samples chan<- sdr.Sample) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return export.ReadCSV(ctx, f, samples)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+func TestImportFile(t *testing.T) {
+	dir := t.TempDir()
+	header := "Source,Identifier,FreqCenter,FreqLow,FreqHigh,StartUnixMilli,EndUnixMilli,dBLow,dBHigh,dbAvg,SampleCount\n"
+	tests := []struct {
+		name      string
+		content   *string // nil for a missing file
+		wantCount int
+		wantErr   bool
+	}{
+		{name: "samples", content: ptr(header + "rtlsdr,a,150,100,200,1000,2000,-60,-40,-50,1\nrtlsdr,a,250,200,300,1000,2000,-60,-40,-50,1\n"), wantCount: 2},
+		{name: "malformed row is skipped", content: ptr(header + "rtlsdr,a,150,100,200,1000,2000,-60,-40,-50,1\nrtlsdr,a,x\n"), wantCount: 1},
+		{name: "unexpected header", content: ptr("a,b,c\n"), wantErr: true},
+		{name: "missing file", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			file := filepath.Join(dir, tc.name+".csv")
+			if tc.content != nil {
+				if err := os.WriteFile(file, []byte(*tc.content), 0o600); err != nil {
+					t.Fatalf("unable to write CSV file: %s", err)
+				}
+			}
+			samples := make(chan sdr.Sample, 10)
+			count, err := importFile(context.Background(), file, samples)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("importFile() error = %v, want error: %t", err, tc.wantErr)
+			}
+			if count != tc.wantCount || len(samples) != tc.wantCount {
+				t.Errorf("importFile() returned a count of %d and sent %d samples, want %d", count, len(samples), tc.wantCount)
+			}
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
@@ -0,0 +1,41 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Setup replaces the default slog logger with one writing to stderr in the given format
+// (see Format* constants) which drops messages below level (debug, info, warn or error).
+func Setup(format, level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q: %s", level, err)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case FormatText:
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("%q is not a supported log format, pick one of: text, json", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// Exit logs the message and attributes as error and exits the program with status 1.
+func Exit(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,39 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+)
+
+func TestSetup(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	tests := []struct {
+		format    string
+		level     string
+		wantLevel slog.Level
+		wantErr   bool
+	}{
+		{format: "text", level: "info", wantLevel: slog.LevelInfo},
+		{format: "JSON", level: "debug", wantLevel: slog.LevelDebug},
+		{format: "json", level: "WARN", wantLevel: slog.LevelWarn},
+		{format: "text", level: "error", wantLevel: slog.LevelError},
+		{format: "xml", level: "info", wantErr: true},
+		{format: "text", level: "verbose", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.format+"/"+tc.level, func(t *testing.T) {
+			err := Setup(tc.format, tc.level)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("Setup(%q, %q) error = %v, want error: %t", tc.format, tc.level, err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			logger := slog.Default()
+			if !logger.Enabled(context.Background(), tc.wantLevel) || logger.Enabled(context.Background(), tc.wantLevel-1) {
+				t.Errorf("Setup(%q, %q) logs from a different level than %s", tc.format, tc.level, tc.wantLevel)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/hb9tf/spectre/export"
+	"github.com/hb9tf/spectre/logging"
+
+	// Blind import support for sqlite3 used by the sqlite storage.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Flags
+var (
+	storage    = flag.String("storage", "", "Storage solution to prune (one of: sqlite, mysql)")
+	maxAge     = flag.Duration("maxAge", 0, "Delete samples which ended longer ago than this, e.g. 720h for 30 days.")
+	source     = flag.String("source", "", "Only delete samples of this source (SDR type), e.g. rtlsdr or hackrf.")
+	identifier = flag.String("identifier", "", "Only delete samples of this source instance.")
+	vacuum     = flag.Bool("vacuum", true, "Reclaim the disk space of the deleted samples (sqlite only).")
+
+	// SQLite
+	sqliteFile = flag.String("sqliteFile", "/tmp/spectre", "File path of the sqlite DB file to use.")
+
+	// MySQL
+	mysqlServer       = flag.String("mysqlServer", "127.0.0.1:3306", "MySQL TCP server endpoint to connect to (IP/DNS and port).")
+	mysqlUser         = flag.String("mysqlUser", "", "MySQL DB user.")
+	mysqlPasswordFile = flag.String("mysqlPasswordFile", "", "Path to the file containing the password for the MySQL user.")
+	mysqlDBName       = flag.String("mysqlDBName", "spectre", "Name of the DB to use.")
+
+	// Logging
+	logFormat = flag.String("logFormat", logging.FormatText, "Log format (one of: text, json).")
+	logLevel  = flag.String("logLevel", "info", "Minimum level of messages to log (one of: debug, info, warn, error).")
+)
+
+func main() {
+	// Parse flags globally.
+	flag.Parse()
+	if err := logging.Setup(*logFormat, *logLevel); err != nil {
+		logging.Exit("unable to set up logging", "error", err)
+	}
+
+	// Require a max age so a missing flag doesn't delete all samples.
+	if *maxAge <= 0 {
+		logging.Exit("-maxAge needs to be a positive duration")
+	}
+
+	// Storage setup
+	var db *sql.DB
+	var dialect string
+	switch strings.ToLower(*storage) {
+	case "sqlite":
+		var err error
+		db, err = sql.Open("sqlite3", *sqliteFile)
+		if err != nil {
+			logging.Exit("unable to open sqlite DB", "file", *sqliteFile, "error", err)
+		}
+		dialect = export.DialectSQLite
+	case "mysql":
+		pass, err := os.ReadFile(*mysqlPasswordFile)
+		if err != nil {
+			logging.Exit("unable to read MySQL password file", "file", *mysqlPasswordFile, "error", err)
+		}
+		cfg := mysql.Config{
+			User:   *mysqlUser,
+			Passwd: strings.TrimSpace(string(pass)),
+			Net:    "tcp",
+			Addr:   *mysqlServer,
+			DBName: *mysqlDBName,
+		}
+		db, err = sql.Open("mysql", cfg.FormatDSN())
+		if err != nil {
+			logging.Exit("unable to open MySQL DB", "server", *mysqlServer, "error", err)
+		}
+		dialect = export.DialectMySQL
+	default:
+		logging.Exit("unsupported storage, pick one of: sqlite, mysql", "storage", *storage)
+	}
+	defer db.Close()
+
+	before := time.Now().Add(-*maxAge)
+	deleted, err := export.DeleteSamples(db, export.DeleteFilter{
+		Source:     *source,
+		Identifier: *identifier,
+		Before:     before,
+	})
+	if err != nil {
+		logging.Exit("unable to delete samples", "error", err)
+	}
+	fmt.Printf("Deleted %d samples which ended before %s\n", deleted, before.Format(time.RFC3339))
+
+	if *vacuum && deleted > 0 {
+		if err := export.Vacuum(db, dialect); err != nil {
+			logging.Exit("unable to vacuum DB", "error", err)
+		}
+	}
+}
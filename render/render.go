@@ -5,19 +5,22 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"image/color"
 	"image/jpeg"
-	"image/png"
+	"log/slog"
 	"math"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
-	"github.com/golang/glog"
 
 	"github.com/hb9tf/spectre/extraction"
+	"github.com/hb9tf/spectre/logging"
+	spectresdr "github.com/hb9tf/spectre/sdr"
 
-	// Blind import support for sqlite3 used by sqlite.go.
+	// Blind import support for sqlite3 used by the sqlite storage.
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -35,17 +38,44 @@ var (
 
 	// Filter options
 	sdr          = flag.String("sdr", "", "Source type, e.g. rtlsdr or hackrf.")
-	identifier   = flag.String("identifier", "", "Identifier of the station to render the data for (typically a UUID4).")
+	identifier   = flag.String("identifier", "", "Comma separated identifiers of the stations to render the data for (typically a UUID4), identifiers containing % or _ are matched as SQL LIKE patterns.")
+	tags         = flag.String("tags", "", "Comma separated key=value tags the samples need to have in their metadata, e.g. antenna=discone.")
 	startFreq    = flag.Int64("startFreq", 0, "Select samples starting with this frequency in Hz.")
 	endFreq      = flag.Int64("endFreq", math.MaxInt64, "Select samples up to this frequency in Hz.")
 	startTimeRaw = flag.String("startTime", "1970-01-01T00:00:00", "Select samples collected after this time. Format: 2006-01-02T15:04:05")
 	endTimeRaw   = flag.String("endTime", "2100-01-02T15:04:05", "Select samples collected before this time. Format: 2006-01-02T15:04:05")
+	last         = flag.Duration("last", 0, "Select samples collected within this duration before now, e.g. 1h. Overrides -startTime and -endTime when set.")
 
 	// Image rendering options
-	addGrid   = flag.Bool("addGrid", true, "Adds a grid to the output image for reference when set.")
-	imgPath   = flag.String("imgPath", "/tmp/out.jpg", "Path where the rendered image should be written to.")
-	imgWidth  = flag.Int("imgWidth", 0, "Width of output image in pixels.")
-	imgHeight = flag.Int("imgHeight", 0, "Height of output image in pixels.")
+	addGrid     = flag.Bool("addGrid", true, "Adds a grid to the output image for reference when set.")
+	addLegend   = flag.Bool("addLegend", false, "Adds a color scale with dB values to the output image when set.")
+	logFreq     = flag.Bool("logFreq", false, "Renders the frequency axis logarithmically instead of linearly when set.")
+	mode        = flag.String("mode", extraction.ModeWaterfall, "What to render (one of: waterfall, spectrum). spectrum plots the dB per frequency over the whole time window.")
+	markers     = flag.String("markers", "", "Comma separated frequencies to mark as freq:label with the frequency in Hz, e.g. 430000000:70cm,440000000 (drawn with the grid).")
+	peakHold    = flag.Bool("peakHold", false, "Overlays a line with the highest dB per frequency across the time window when set.")
+	weightCount = flag.Bool("weightBySampleCount", false, "Dims the waterfall where buckets aggregate fewer measurements than the best sampled bucket when set.")
+	smooth      = flag.Int("smoothKernel", 0, "Size of the window (odd, e.g. 3 for 3x3 buckets) to average the dB values over to reduce noise, 0 disables smoothing.")
+	imgPath     = flag.String("imgPath", "/tmp/out.jpg", "Path where the rendered image should be written to.")
+	imgWidth    = flag.Int("imgWidth", 0, "Width of output image in pixels.")
+	imgHeight   = flag.Int("imgHeight", 0, "Height of output image in pixels.")
+	minDB       = flag.Float64("minDB", math.NaN(), "Lower end of the dB range to scale colors to (defaults to the lowest dB in the selected samples).")
+	maxDB       = flag.Float64("maxDB", math.NaN(), "Upper end of the dB range to scale colors to (defaults to the highest dB in the selected samples).")
+	palette     = flag.String("palette", extraction.PaletteDefault, "Color palette to use (one of: default, viridis, grayscale, inferno).")
+	timezone    = flag.String("tz", "UTC", "Timezone to label the time axis in, e.g. Europe/Zurich or Local.")
+	metric      = flag.String("metric", "", "dB value of the samples to render (one of: high, avg, low), defaults to high for waterfalls and avg for spectrums.")
+	gridColor   = flag.String("gridColor", "", "Color of the grid ticks as #rrggbb or #rrggbbaa (default white).")
+	textColor   = flag.String("textColor", "", "Color of the grid and legend labels as #rrggbb or #rrggbbaa (default white).")
+	bgColor     = flag.String("backgroundColor", "", "Background color of the grid and legend as #rrggbb or #rrggbbaa (default black).")
+	jpegQuality = flag.Int("jpegQuality", jpeg.DefaultQuality, "Quality of JPEG images (1-100).")
+	transparent = flag.Bool("transparent", false, "Draws the background of grid and legend transparent (PNG, WebP, TIFF and SVG only).")
+
+	// Grafana
+	grafanaQuery  = flag.Bool("grafanaQuery", false, "Prints an SQL query for Grafana time series panels for -sdr, -identifier, -startFreq, -endFreq and -metric instead of rendering an image.")
+	grafanaSeries = flag.String("grafanaSeries", extraction.GrafanaSeriesBand, "Series returned by the Grafana query (one of: band, freq). freq returns one series per center frequency.")
+
+	// Logging
+	logFormat = flag.String("logFormat", logging.FormatText, "Log format (one of: text, json).")
+	logLevel  = flag.String("logLevel", "info", "Minimum level of messages to log (one of: debug, info, warn, error).")
 )
 
 const (
@@ -53,37 +83,72 @@ const (
 )
 
 func main() {
-	// Set defaults for glog flags. Can be overridden via cmdline.
-	flag.Set("logtostderr", "false")
-	flag.Set("stderrthreshold", "WARNING")
-	flag.Set("v", "1")
 	// Parse flags globally.
 	flag.Parse()
+	if err := logging.Setup(*logFormat, *logLevel); err != nil {
+		logging.Exit("unable to set up logging", "error", err)
+	}
+
+	if *grafanaQuery {
+		query := &extraction.GrafanaQuery{
+			SDR:        *sdr,
+			Identifier: *identifier,
+			StartFreq:  *startFreq,
+			EndFreq:    *endFreq,
+			Metric:     strings.ToLower(*metric),
+			Series:     strings.ToLower(*grafanaSeries),
+		}
+		q, err := query.SQL()
+		if err != nil {
+			logging.Exit("unable to build Grafana query", "error", err)
+		}
+		fmt.Println(q)
+		return
+	}
+
+	if *jpegQuality < 1 || *jpegQuality > 100 {
+		slog.Warn("-jpegQuality needs to be between 1 and 100, using default quality", "jpegQuality", *jpegQuality, "default", jpeg.DefaultQuality)
+		*jpegQuality = jpeg.DefaultQuality
+	}
+	format, err := imageFormat(*imgPath)
+	if err != nil {
+		logging.Exit("unsupported image format", "error", err)
+	}
+	if *transparent && !extraction.SupportsTransparency(format) {
+		logging.Exit("-transparent is only supported for PNG, WebP, TIFF and SVG images", "imgPath", *imgPath)
+	}
 
 	startTime, err := time.Parse(timeFmt, *startTimeRaw)
 	if err != nil {
-		glog.Exitf("unable to parse startTime (value: %q, format: %q): %s", *startTimeRaw, timeFmt, err)
+		logging.Exit("unable to parse startTime", "value", *startTimeRaw, "format", timeFmt, "error", err)
 	}
 	endTime, err := time.Parse(timeFmt, *endTimeRaw)
 	if err != nil {
-		glog.Exitf("unable to parse endTime (value: %q, format: %q): %s", *endTimeRaw, timeFmt, err)
+		logging.Exit("unable to parse endTime", "value", *endTimeRaw, "format", timeFmt, "error", err)
+	}
+	if *last < 0 {
+		logging.Exit("-last needs to be a positive duration", "last", *last)
+	}
+	if *last > 0 {
+		endTime = time.Now()
+		startTime = endTime.Add(-*last)
 	}
 
 	var db *sql.DB
 	switch strings.ToLower(*source) {
 	case "sqlite":
 		if _, err := os.Stat(*sqliteFile); errors.Is(err, os.ErrNotExist) {
-			glog.Exitf("unable to open sqlite DB %q: %s", sqliteFile, err)
+			logging.Exit("unable to open sqlite DB", "file", *sqliteFile, "error", err)
 		}
 		var err error
 		db, err = sql.Open("sqlite3", *sqliteFile)
 		if err != nil {
-			glog.Exitf("unable to open sqlite DB %q: %s", *sqliteFile, err)
+			logging.Exit("unable to open sqlite DB", "file", *sqliteFile, "error", err)
 		}
 	case "mysql":
 		pass, err := os.ReadFile(*mysqlPasswordFile)
 		if err != nil {
-			glog.Exitf("unable to read MySQL password file %q: %s\n", *mysqlPasswordFile, err)
+			logging.Exit("unable to read MySQL password file", "file", *mysqlPasswordFile, "error", err)
 		}
 		cfg := mysql.Config{
 			User:   *mysqlUser,
@@ -94,20 +159,79 @@ func main() {
 		}
 		db, err = sql.Open("mysql", cfg.FormatDSN())
 		if err != nil {
-			glog.Exitf("unable to open MySQL DB %q: %s", *mysqlServer, err)
+			logging.Exit("unable to open MySQL DB", "server", *mysqlServer, "error", err)
 		}
 		db.SetConnMaxLifetime(3 * time.Minute)
 		db.SetMaxOpenConns(10)
 		db.SetMaxIdleConns(10)
 	default:
-		glog.Exitf("%q is not a supported source, pick one of: sqlite", *source)
+		logging.Exit("unsupported source, pick one of: sqlite, mysql", "source", *source)
+	}
+
+	var minDBOpt, maxDBOpt *float64
+	if !math.IsNaN(*minDB) {
+		minDBOpt = minDB
+	}
+	if !math.IsNaN(*maxDB) {
+		maxDBOpt = maxDB
+	}
+
+	loc, err := time.LoadLocation(*timezone)
+	if err != nil {
+		logging.Exit("unable to load timezone", "tz", *timezone, "error", err)
+	}
+
+	colors := extraction.DefaultGridColors
+	for flagName, opt := range map[string]struct {
+		value  string
+		target *color.RGBA
+	}{
+		"gridColor":       {*gridColor, &colors.Grid},
+		"textColor":       {*textColor, &colors.Text},
+		"backgroundColor": {*bgColor, &colors.Background},
+	} {
+		if opt.value == "" {
+			continue
+		}
+		parsed, err := extraction.ParseColor(opt.value)
+		if err != nil {
+			logging.Exit("unable to parse color", "flag", flagName, "error", err)
+		}
+		*opt.target = parsed
+	}
+
+	markerOpts, err := extraction.ParseMarkers(*markers)
+	if err != nil {
+		logging.Exit("unable to parse -markers", "error", err)
+	}
+	tagFilter, err := spectresdr.ParseMetadata(*tags)
+	if err != nil {
+		logging.Exit("unable to parse -tags", "error", err)
 	}
 
-	result, err := extraction.Render(db, &extraction.RenderRequest{
+	render := extraction.Render
+	if format == extraction.FormatSVG {
+		render = extraction.RenderSVG
+	}
+	result, err := render(db, &extraction.RenderRequest{
 		Image: &extraction.ImageOptions{
-			Height:  *imgHeight,
-			Width:   *imgWidth,
-			AddGrid: *addGrid,
+			Height:                *imgHeight,
+			Mode:                  strings.ToLower(*mode),
+			Width:                 *imgWidth,
+			AddGrid:               *addGrid,
+			AddLegend:             *addLegend,
+			LogFreqAxis:           *logFreq,
+			PeakHold:              *peakHold,
+			WeightBySampleCount:   *weightCount,
+			SmoothKernel:          *smooth,
+			Markers:               markerOpts,
+			TransparentBackground: *transparent,
+			Colors:                &colors,
+			Location:              loc,
+			Palette:               strings.ToLower(*palette),
+			Metric:                strings.ToLower(*metric),
+			MinDB:                 minDBOpt,
+			MaxDB:                 maxDBOpt,
 		},
 		Filter: &extraction.FilterOptions{
 			SDR:        *sdr,
@@ -116,10 +240,11 @@ func main() {
 			EndFreq:    *endFreq,
 			StartTime:  startTime,
 			EndTime:    endTime,
+			Tags:       tagFilter,
 		},
 	})
 	if err != nil {
-		glog.Exitf("Unable to render image: %s\n", err)
+		logging.Exit("unable to render image", "error", err)
 	}
 
 	fmt.Println("Selected source metadata:")
@@ -129,16 +254,45 @@ func main() {
 	fmt.Printf("  - End time: %s (%d)\n", result.SourceMeta.EndTime.Format(timeFmt), result.SourceMeta.EndTime.Unix())
 	fmt.Printf("  - Duration: %s\n", result.SourceMeta.EndTime.Sub(result.SourceMeta.StartTime))
 	fmt.Printf("Rendered image (%d x %d)\n", result.ImageMeta.ImageWidth, result.ImageMeta.ImageHeight)
+	if meta := result.ImageMeta; meta.Clamped {
+		requested := func(size int) string {
+			if size == 0 {
+				return "auto"
+			}
+			return fmt.Sprint(size)
+		}
+		fmt.Printf("  - Reduced from the requested %s x %s to the resolution of the data\n", requested(meta.RequestedWidth), requested(meta.RequestedHeight))
+	}
 	fmt.Printf("  - Frequency resolution: %s per pixel\n", extraction.GetReadableFreq(int64(result.ImageMeta.FreqPerPixel)))
-	fmt.Printf("  - Time resolution: %.2f seconds per pixel\n", result.ImageMeta.SecPerPixel)
+	if result.ImageMeta.SecPerPixel > 0 {
+		fmt.Printf("  - Time resolution: %.2f seconds per pixel\n", result.ImageMeta.SecPerPixel)
+	}
 
 	fmt.Printf("Writing image to %q\n", *imgPath)
-	f, _ := os.Create(*imgPath)
+	f, err := os.Create(*imgPath)
+	if err != nil {
+		logging.Exit("unable to create image file", "imgPath", *imgPath, "error", err)
+	}
 	defer f.Close()
-	switch {
-	case strings.HasSuffix(*imgPath, ".png"):
-		png.Encode(f, result.Image)
-	case strings.HasSuffix(*imgPath, ".jpg"):
-		jpeg.Encode(f, result.Image, &jpeg.Options{Quality: jpeg.DefaultQuality})
+	if err := extraction.Encode(f, result, format, *jpegQuality); err != nil {
+		logging.Exit("unable to write image", "imgPath", *imgPath, "error", err)
+	}
+	if err := f.Close(); err != nil {
+		logging.Exit("unable to write image", "imgPath", *imgPath, "error", err)
+	}
+}
+
+// imageFormat returns the image format matching the extension of the path.
+func imageFormat(path string) (string, error) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	switch ext {
+	case "jpeg":
+		return extraction.FormatJPEG, nil
+	case "tif":
+		return extraction.FormatTIFF, nil
+	}
+	if extraction.ContentType(ext) == "" {
+		return "", fmt.Errorf("unsupported image extension %q of -imgPath %q, use one of: .jpg, .jpeg, .png, .webp, .tiff, .tif, .svg", ext, path)
 	}
+	return ext, nil
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hb9tf/spectre/extraction"
+)
+
+func TestImageFormat(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "/tmp/waterfall.jpg", want: extraction.FormatJPEG},
+		{path: "waterfall.JPEG", want: extraction.FormatJPEG},
+		{path: "waterfall.png", want: extraction.FormatPNG},
+		{path: "waterfall.webp", want: extraction.FormatWebP},
+		{path: "waterfall.tif", want: extraction.FormatTIFF},
+		{path: "waterfall.tiff", want: extraction.FormatTIFF},
+		{path: "waterfall.svg", want: extraction.FormatSVG},
+		{path: "waterfall.gif", wantErr: true},
+		{path: "waterfall", wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := imageFormat(tc.path)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("imageFormat(%q) error = %v, want error: %t", tc.path, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("imageFormat(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
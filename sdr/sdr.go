@@ -1,6 +1,11 @@
 package sdr
 
 import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -8,6 +13,12 @@ type Sample struct {
 	// Metadata
 	Identifier string
 	Source     string
+	// Lat and Lon are the optional location of the collector in decimal degrees (WGS 84),
+	// nil if unknown.
+	Lat *float64 `json:",omitempty"`
+	Lon *float64 `json:",omitempty"`
+	// Metadata holds optional free-form tags describing the capture, e.g. the antenna used.
+	Metadata map[string]string `json:",omitempty"`
 
 	// Radio Data
 	FreqCenter  int64
@@ -23,7 +34,10 @@ type Sample struct {
 
 type SDR interface {
 	Name() string
-	Sweep(opts *Options, samples chan<- Sample) error
+	// Sweep runs the sweep and writes the samples to the channel until the sweep ends
+	// or the context is cancelled. The samples channel is closed once all samples
+	// have been written.
+	Sweep(ctx context.Context, opts *Options, samples chan<- Sample) error
 }
 
 type Options struct {
@@ -38,4 +52,138 @@ type Options struct {
 
 	// IntegrationInterval is the duration during which to collect information per frequency.
 	IntegrationInterval time.Duration
+	// IntervalOverrides optionally set different integration intervals for frequency segments
+	// (HackRF and RTL-SDR only).
+	IntervalOverrides []IntervalOverride
+
+	// SkipDCBin drops the center bin of each sweep segment which contains the
+	// artificial DC spike of the tuner (rtl_power style sources only, i.e. RTL-SDR,
+	// Airspy and SDRplay, hackrf_sweep avoids the spike by tuning with an offset).
+	SkipDCBin bool
+
+	// AmpEnable enables the RX RF amplifier (HackRF only).
+	AmpEnable bool
+	// LNAGain is the RX LNA (IF) gain in dB, 0-40dB in 8dB steps (HackRF only).
+	LNAGain int
+	// VGAGain is the RX VGA (baseband) gain in dB, 0-62dB in 2dB steps (HackRF only).
+	VGAGain int
+
+	// TunerGain is the tuner gain in dB, automatic gain control is used if negative (RTL-SDR only).
+	TunerGain float64
+	// BiasTee powers an active antenna or LNA through the antenna input (RTL-SDR only).
+	BiasTee bool
+	// PPM is the frequency correction of the oscillator in parts per million (RTL-SDR only).
+	PPM int
+
+	// IFGainReduction is the IF gain reduction in dB, 20-59dB (SDRplay only).
+	IFGainReduction int
+	// LNAState is the RF gain reduction step of the LNA, the range depends on the model (SDRplay only).
+	LNAState int
+}
+
+// Validate returns an error if the frequency range, bin size or integration interval can't
+// be used for a sweep.
+func (o *Options) Validate() error {
+	if o.HighFreq <= o.LowFreq {
+		return fmt.Errorf("high frequency (%d Hz) needs to be above low frequency (%d Hz)", o.HighFreq, o.LowFreq)
+	}
+	if o.BinSize <= 0 {
+		return fmt.Errorf("bin size (%d Hz) needs to be positive", o.BinSize)
+	}
+	if span := o.HighFreq - o.LowFreq; o.BinSize > span {
+		return fmt.Errorf("bin size (%d Hz) needs to be at most the frequency span (%d Hz)", o.BinSize, span)
+	}
+	if o.IntegrationInterval <= 0 {
+		return fmt.Errorf("integration interval (%s) needs to be positive", o.IntegrationInterval)
+	}
+	return nil
+}
+
+// IntervalOverride sets the integration interval for the frequencies between FreqLow and FreqHigh.
+type IntervalOverride struct {
+	FreqLow  int64
+	FreqHigh int64
+	Interval time.Duration
+}
+
+// ParseIntervalOverrides parses a comma separated list of overrides in the format
+// "freqLow:freqHigh:interval" with the frequencies in Hz, e.g. "430000000:440000000:1s".
+func ParseIntervalOverrides(s string) ([]IntervalOverride, error) {
+	var overrides []IntervalOverride
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid interval override %q, use freqLow:freqHigh:interval", entry)
+		}
+		low, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid low frequency in interval override %q: %s", entry, err)
+		}
+		high, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid high frequency in interval override %q: %s", entry, err)
+		}
+		interval, err := time.ParseDuration(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval in interval override %q: %s", entry, err)
+		}
+		if low >= high {
+			return nil, fmt.Errorf("low frequency needs to be below high frequency in interval override %q", entry)
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("interval needs to be positive in interval override %q", entry)
+		}
+		overrides = append(overrides, IntervalOverride{FreqLow: low, FreqHigh: high, Interval: interval})
+	}
+	return overrides, nil
+}
+
+// ParseMetadata parses a comma separated list of tags in the format "key=value",
+// e.g. "antenna=discone,feed=roof".
+func ParseMetadata(s string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(entry, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid tag %q, use key=value", entry)
+		}
+		metadata[key] = strings.TrimSpace(value)
+	}
+	return metadata, nil
+}
+
+// IntervalFor returns the integration interval of the first override containing the
+// frequency, or IntegrationInterval if there is none.
+func (o *Options) IntervalFor(freq int64) time.Duration {
+	for _, override := range o.IntervalOverrides {
+		if freq >= override.FreqLow && freq <= override.FreqHigh {
+			return override.Interval
+		}
+	}
+	return o.IntegrationInterval
+}
+
+// MinInterval returns the shortest of all integration intervals. Sweeps run with this
+// interval and the other intervals are rounded to multiples of it.
+func (o *Options) MinInterval() time.Duration {
+	interval := o.IntegrationInterval
+	for _, override := range o.IntervalOverrides {
+		interval = min(interval, override.Interval)
+	}
+	return interval
+}
+
+// IntervalMultiple returns how many of the shortest intervals make up the integration
+// interval of the frequency, at least 1.
+func (o *Options) IntervalMultiple(freq int64) int {
+	return max(1, int(math.Round(float64(o.IntervalFor(freq))/float64(o.MinInterval()))))
 }
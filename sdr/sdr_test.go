@@ -0,0 +1,169 @@
+package sdr
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSampleJSON(t *testing.T) {
+	// The frequencies don't fit in 32 bits, run with GOARCH=386 to check 32-bit platforms.
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	want := Sample{
+		Identifier:  "id",
+		Source:      "hackrf",
+		FreqCenter:  2400000000,
+		FreqLow:     2399995000,
+		FreqHigh:    2400005000,
+		DBHigh:      -10,
+		DBLow:       -30,
+		DBAvg:       -20,
+		SampleCount: 3,
+		Start:       start,
+		End:         start.Add(time.Second),
+	}
+	if want.FreqCenter <= math.MaxInt32 {
+		t.Fatalf("test frequency %d Hz fits in 32 bits", want.FreqCenter)
+	}
+	encoded, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("json.Marshal() failed: %s", err)
+	}
+	var got Sample
+	if err := json.Unmarshal(encoded, &got); err != nil {
+		t.Fatalf("json.Unmarshal() failed: %s", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round-tripped sample = %+v, want %+v", got, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Options{LowFreq: 100, HighFreq: 200, BinSize: 10, IntegrationInterval: time.Second}
+	tests := []struct {
+		name    string
+		modify  func(*Options)
+		wantErr bool
+	}{
+		{name: "valid", modify: func(*Options) {}},
+		{name: "bin size equals span", modify: func(o *Options) { o.BinSize = 100 }},
+		{name: "high below low", modify: func(o *Options) { o.HighFreq = 50 }, wantErr: true},
+		{name: "high equals low", modify: func(o *Options) { o.HighFreq = 100 }, wantErr: true},
+		{name: "zero bin size", modify: func(o *Options) { o.BinSize = 0 }, wantErr: true},
+		{name: "bin size above span", modify: func(o *Options) { o.BinSize = 101 }, wantErr: true},
+		{name: "zero interval", modify: func(o *Options) { o.IntegrationInterval = 0 }, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := valid
+			tc.modify(&opts)
+			err := opts.Validate()
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("Validate() error = %v, want error: %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseIntervalOverrides(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []IntervalOverride
+		wantErr bool
+	}{
+		{name: "empty", input: ""},
+		{
+			name:  "single",
+			input: "430000000:440000000:1s",
+			want:  []IntervalOverride{{FreqLow: 430000000, FreqHigh: 440000000, Interval: time.Second}},
+		},
+		{
+			name:  "multiple with spaces",
+			input: " 100:200:500ms , 300:400:2m,",
+			want: []IntervalOverride{
+				{FreqLow: 100, FreqHigh: 200, Interval: 500 * time.Millisecond},
+				{FreqLow: 300, FreqHigh: 400, Interval: 2 * time.Minute},
+			},
+		},
+		{name: "missing interval", input: "100:200", wantErr: true},
+		{name: "invalid low frequency", input: "1e6:200:1s", wantErr: true},
+		{name: "invalid high frequency", input: "100:x:1s", wantErr: true},
+		{name: "invalid interval", input: "100:200:1", wantErr: true},
+		{name: "low above high", input: "200:100:1s", wantErr: true},
+		{name: "zero interval", input: "100:200:0s", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseIntervalOverrides(tc.input)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("ParseIntervalOverrides(%q) error = %v, want error: %t", tc.input, err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ParseIntervalOverrides(%q) = %+v, want %+v", tc.input, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", input: "", want: map[string]string{}},
+		{name: "tags", input: "antenna=discone, feed = roof", want: map[string]string{"antenna": "discone", "feed": "roof"}},
+		{name: "empty value", input: "antenna=", want: map[string]string{"antenna": ""}},
+		{name: "value with equals sign", input: "note=a=b", want: map[string]string{"note": "a=b"}},
+		{name: "missing equals sign", input: "antenna", wantErr: true},
+		{name: "missing key", input: "=discone", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseMetadata(tc.input)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("ParseMetadata(%q) error = %v, want error: %t", tc.input, err, tc.wantErr)
+			}
+			if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ParseMetadata(%q) = %v, want %v", tc.input, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIntervals(t *testing.T) {
+	opts := &Options{
+		IntegrationInterval: 3 * time.Second,
+		IntervalOverrides: []IntervalOverride{
+			{FreqLow: 100, FreqHigh: 200, Interval: time.Second},
+			{FreqLow: 150, FreqHigh: 300, Interval: 10 * time.Second},
+			{FreqLow: 400, FreqHigh: 500, Interval: 2500 * time.Millisecond},
+		},
+	}
+	if got, want := opts.MinInterval(), time.Second; got != want {
+		t.Errorf("MinInterval() = %s, want %s", got, want)
+	}
+	tests := []struct {
+		freq         int64
+		wantInterval time.Duration
+		wantMultiple int
+	}{
+		{freq: 50, wantInterval: 3 * time.Second, wantMultiple: 3},
+		{freq: 100, wantInterval: time.Second, wantMultiple: 1},
+		{freq: 175, wantInterval: time.Second, wantMultiple: 1}, // the first override wins
+		{freq: 250, wantInterval: 10 * time.Second, wantMultiple: 10},
+		{freq: 450, wantInterval: 2500 * time.Millisecond, wantMultiple: 3}, // rounded
+	}
+	for _, tc := range tests {
+		if got := opts.IntervalFor(tc.freq); got != tc.wantInterval {
+			t.Errorf("IntervalFor(%d) = %s, want %s", tc.freq, got, tc.wantInterval)
+		}
+		if got := opts.IntervalMultiple(tc.freq); got != tc.wantMultiple {
+			t.Errorf("IntervalMultiple(%d) = %d, want %d", tc.freq, got, tc.wantMultiple)
+		}
+	}
+}
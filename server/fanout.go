@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+var droppedSamples = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "spectre_dropped_samples_total",
+	Help: "Number of received samples not passed on to a subscriber (e.g. live connections) because it couldn't keep up.",
+}, []string{"subscriber"})
+
+// subscriber receives the samples published to a fanOut on its channel.
+type subscriber struct {
+	name    string
+	samples chan sdr.Sample
+	// lossless subscribers never miss samples, publish rejects samples instead when their
+	// buffer is full. The other subscribers drop the samples they have no room for.
+	lossless bool
+}
+
+// fanOut broadcasts the received samples to all subscribers without blocking, e.g. to the
+// exporter storing them and to live connections. Subscribers can come and go at any time.
+type fanOut struct {
+	subscribers map[*subscriber]bool
+	mu          sync.Mutex
+}
+
+func newFanOut() *fanOut {
+	return &fanOut{
+		subscribers: map[*subscriber]bool{},
+	}
+}
+
+// subscribe registers a subscriber with a buffer for the given number of samples. The name
+// identifies it in the metrics.
+func (f *fanOut) subscribe(name string, buffer int, lossless bool) *subscriber {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sub := &subscriber{
+		name:     name,
+		samples:  make(chan sdr.Sample, buffer),
+		lossless: lossless,
+	}
+	f.subscribers[sub] = true
+	return sub
+}
+
+// unsubscribe removes the subscriber, it doesn't receive any more samples afterwards. Its
+// channel is not closed.
+func (f *fanOut) unsubscribe(sub *subscriber) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subscribers, sub)
+}
+
+// publish passes the samples on to all subscribers. Either all samples are published or,
+// if a lossless subscriber doesn't have room for all of them, none and false is returned.
+func (f *fanOut) publish(samples []sdr.Sample) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Only publish sends to the channels, so the space can only grow meanwhile.
+	for sub := range f.subscribers {
+		if sub.lossless && cap(sub.samples)-len(sub.samples) < len(samples) {
+			return false
+		}
+	}
+	for sub := range f.subscribers {
+		for _, sample := range samples {
+			if sub.lossless {
+				sub.samples <- sample
+				continue
+			}
+			select {
+			case sub.samples <- sample:
+			default:
+				droppedSamples.WithLabelValues(sub.name).Inc()
+			}
+		}
+	}
+	return true
+}
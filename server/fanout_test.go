@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hb9tf/spectre/sdr"
+)
+
+func TestFanOutPublish(t *testing.T) {
+	samples := func(n int) []sdr.Sample {
+		return make([]sdr.Sample, n)
+	}
+	tests := []struct {
+		name         string
+		lossless     int // buffer of the lossless subscriber
+		lossy        int // buffer of the lossy subscriber
+		publish      []int
+		wantOK       []bool
+		wantLossless int
+		wantLossy    int
+	}{
+		{name: "room for all", lossless: 10, lossy: 10, publish: []int{3, 4}, wantOK: []bool{true, true}, wantLossless: 7, wantLossy: 7},
+		{name: "lossless full rejects all", lossless: 5, lossy: 10, publish: []int{3, 3}, wantOK: []bool{true, false}, wantLossless: 3, wantLossy: 3},
+		{name: "lossy full drops", lossless: 10, lossy: 4, publish: []int{3, 3}, wantOK: []bool{true, true}, wantLossless: 6, wantLossy: 4},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFanOut()
+			lossless := f.subscribe("lossless", tc.lossless, true)
+			lossy := f.subscribe("lossy", tc.lossy, false)
+			for i, n := range tc.publish {
+				if got := f.publish(samples(n)); got != tc.wantOK[i] {
+					t.Errorf("publish(%d samples) = %t, want %t", n, got, tc.wantOK[i])
+				}
+			}
+			if got := len(lossless.samples); got != tc.wantLossless {
+				t.Errorf("lossless subscriber received %d samples, want %d", got, tc.wantLossless)
+			}
+			if got := len(lossy.samples); got != tc.wantLossy {
+				t.Errorf("lossy subscriber received %d samples, want %d", got, tc.wantLossy)
+			}
+		})
+	}
+}
+
+func TestFanOutUnsubscribe(t *testing.T) {
+	f := newFanOut()
+	a := f.subscribe("a", 10, true)
+	b := f.subscribe("b", 1, true)
+	// b doesn't have room for the samples, so they are rejected until it unsubscribes.
+	if f.publish(make([]sdr.Sample, 2)) {
+		t.Error("publish() succeeded although a lossless subscriber has no room")
+	}
+	f.unsubscribe(b)
+	if !f.publish(make([]sdr.Sample, 2)) {
+		t.Error("publish() failed after the full subscriber unsubscribed")
+	}
+	if len(a.samples) != 2 || len(b.samples) != 0 {
+		t.Errorf("subscribers received %d and %d samples, want 2 and 0", len(a.samples), len(b.samples))
+	}
+}
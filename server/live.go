@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/hb9tf/spectre/extraction"
+	"github.com/hb9tf/spectre/sdr"
+)
+
+const (
+	liveFormatJSON = "json"
+	liveFormatPNG  = "png"
+
+	defaultLiveWidth    = 1000
+	maxLiveWidth        = 10000
+	defaultLiveInterval = 5 * time.Second
+	minLiveInterval     = 100 * time.Millisecond
+	// liveBuffer is the number of samples buffered per live connection. Samples are dropped
+	// for connections which can't keep up.
+	liveBuffer = 10000
+	// liveWriteTimeout is how long writing a message to a client may take before the
+	// connection is closed.
+	liveWriteTimeout = 10 * time.Second
+	// livePingInterval is how often clients are pinged to detect dead connections, they
+	// need to respond within livePongTimeout.
+	livePingInterval = 30 * time.Second
+	livePongTimeout  = 2 * livePingInterval
+)
+
+var liveConnections = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "spectre_live_connections",
+	Help: "Number of open live WebSocket connections.",
+})
+
+var liveUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// liveRequest holds the options of a live connection.
+type liveRequest struct {
+	SDR         string   `form:"sdr"`
+	Identifier  string   `form:"identifier"`
+	Tags        string   `form:"tags"`
+	StartFreq   int64    `form:"startFreq"`
+	EndFreq     int64    `form:"endFreq"`
+	ImgWidth    int      `form:"imgWidth"`
+	Interval    string   `form:"interval"`
+	Format      string   `form:"format"`
+	Metric      string   `form:"metric"`
+	Palette     string   `form:"palette"`
+	MinDB       *float64 `form:"minDB"`
+	MaxDB       *float64 `form:"maxDB"`
+	identifiers map[string]bool
+	tags        map[string]string
+	interval    time.Duration
+}
+
+// validate checks the options and fills in the defaults.
+func (r *liveRequest) validate() error {
+	if r.EndFreq <= r.StartFreq || r.StartFreq < 0 {
+		return fmt.Errorf("endFreq (%d Hz) needs to be above startFreq (%d Hz)", r.EndFreq, r.StartFreq)
+	}
+	if r.ImgWidth == 0 {
+		r.ImgWidth = defaultLiveWidth
+	}
+	if r.ImgWidth < 0 || r.ImgWidth > maxLiveWidth {
+		return fmt.Errorf("imgWidth needs to be between 1 and %d, got %d", maxLiveWidth, r.ImgWidth)
+	}
+	r.interval = defaultLiveInterval
+	if r.Interval != "" {
+		interval, err := time.ParseDuration(r.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid interval: %s", err)
+		}
+		if interval < minLiveInterval {
+			return fmt.Errorf("interval needs to be at least %s, got %s", minLiveInterval, interval)
+		}
+		r.interval = interval
+	}
+	r.Format = strings.ToLower(r.Format)
+	switch r.Format {
+	case "":
+		r.Format = liveFormatJSON
+	case liveFormatJSON:
+	case liveFormatPNG:
+		if r.MinDB == nil || r.MaxDB == nil || *r.MinDB >= *r.MaxDB {
+			return errors.New("png rows need minDB and maxDB with minDB lower than maxDB to scale the colors")
+		}
+	default:
+		return fmt.Errorf("unknown format %q, pick one of: json, png", r.Format)
+	}
+	r.Metric = strings.ToLower(r.Metric)
+	if r.Metric == "" {
+		r.Metric = extraction.MetricHigh
+	}
+	if !extraction.IsValidMetric(r.Metric) {
+		return fmt.Errorf("unknown metric %q", r.Metric)
+	}
+	r.Palette = strings.ToLower(r.Palette)
+	if r.Palette == "" {
+		r.Palette = extraction.PaletteDefault
+	}
+	if !extraction.IsValidPalette(r.Palette) {
+		return fmt.Errorf("unknown palette %q", r.Palette)
+	}
+	r.identifiers = map[string]bool{}
+	for _, id := range strings.Split(r.Identifier, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			r.identifiers[id] = true
+		}
+	}
+	tags, err := sdr.ParseMetadata(r.Tags)
+	if err != nil {
+		return err
+	}
+	r.tags = tags
+	return nil
+}
+
+// matches returns whether the sample is selected by the filters.
+func (r *liveRequest) matches(s *sdr.Sample) bool {
+	if r.SDR != "" && s.Source != r.SDR {
+		return false
+	}
+	if len(r.identifiers) > 0 && !r.identifiers[s.Identifier] {
+		return false
+	}
+	if s.FreqLow < r.StartFreq || s.FreqHigh > r.EndFreq {
+		return false
+	}
+	for key, value := range r.tags {
+		if s.Metadata[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// liveRow aggregates the samples received during one interval into frequency buckets.
+type liveRow struct {
+	start  time.Time
+	dbs    []float64
+	counts []int
+}
+
+func newLiveRow(width int) *liveRow {
+	return &liveRow{
+		dbs:    make([]float64, width),
+		counts: make([]int, width),
+	}
+}
+
+// add aggregates the sample into its bucket with the metric of the request.
+func (row *liveRow) add(s *sdr.Sample, req *liveRequest) {
+	idx := int((s.FreqCenter - req.StartFreq) * int64(len(row.dbs)) / (req.EndFreq - req.StartFreq))
+	idx = min(max(idx, 0), len(row.dbs)-1)
+	if row.start.IsZero() || s.Start.Before(row.start) {
+		row.start = s.Start
+	}
+	first := row.counts[idx] == 0
+	row.counts[idx]++
+	switch req.Metric {
+	case extraction.MetricHigh:
+		if first || s.DBHigh > row.dbs[idx] {
+			row.dbs[idx] = s.DBHigh
+		}
+	case extraction.MetricLow:
+		if first || s.DBLow < row.dbs[idx] {
+			row.dbs[idx] = s.DBLow
+		}
+	default:
+		// Running average of the sample averages.
+		row.dbs[idx] += (s.DBAvg - row.dbs[idx]) / float64(row.counts[idx])
+	}
+}
+
+func (row *liveRow) empty() bool {
+	return row.start.IsZero()
+}
+
+// json returns the row with nil for buckets without samples.
+func (row *liveRow) json() gin.H {
+	dbs := make([]*float64, len(row.dbs))
+	for i := range row.dbs {
+		if row.counts[i] > 0 {
+			dbs[i] = &row.dbs[i]
+		}
+	}
+	return gin.H{
+		"time": row.start.UnixMilli(),
+		"db":   dbs,
+	}
+}
+
+// png returns the row as PNG image one pixel high, buckets without samples are transparent.
+func (row *liveRow) png(req *liveRequest) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, len(row.dbs), 1))
+	for x, db := range row.dbs {
+		if row.counts[x] == 0 {
+			continue
+		}
+		lvl := (db - *req.MinDB) / (*req.MaxDB - *req.MinDB)
+		lvl = min(max(lvl, 0), 1)
+		img.SetRGBA(x, 0, extraction.GetColor(uint16(lvl*math.MaxUint16), req.Palette))
+	}
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// liveHandler upgrades the request to a WebSocket connection and sends a row with the
+// samples received during each interval matching the filters. The first message describes
+// the rows, rows without samples are skipped.
+func (s *SpectreServer) liveHandler(c *gin.Context) {
+	req := &liveRequest{}
+	if err := c.ShouldBindQuery(req); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	conn, err := liveUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader already responded with an error.
+		slog.Warn("unable to upgrade live connection", "error", err)
+		return
+	}
+	defer conn.Close()
+	liveConnections.Inc()
+	defer liveConnections.Dec()
+
+	sub := s.Samples.subscribe("live", liveBuffer, false)
+	defer s.Samples.unsubscribe(sub)
+
+	// Clients aren't expected to send anything, but reading is needed to process control
+	// messages and to notice when the connection is closed.
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	conn.SetReadLimit(1024)
+	conn.SetReadDeadline(time.Now().Add(livePongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(livePongTimeout))
+	})
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	freqs := make([]float64, req.ImgWidth)
+	for x := range freqs {
+		freqs[x] = float64(req.StartFreq) + (float64(x)+0.5)*float64(req.EndFreq-req.StartFreq)/float64(req.ImgWidth)
+	}
+	conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+	if err := conn.WriteJSON(gin.H{
+		"format":   req.Format,
+		"metric":   req.Metric,
+		"interval": req.interval.Milliseconds(),
+		"freqs":    freqs,
+	}); err != nil {
+		slog.Debug("unable to send live header", "error", err)
+		return
+	}
+
+	ticker := time.NewTicker(req.interval)
+	defer ticker.Stop()
+	ping := time.NewTicker(livePingInterval)
+	defer ping.Stop()
+	row := newLiveRow(req.ImgWidth)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sample := <-sub.samples:
+			if req.matches(&sample) {
+				row.add(&sample, req)
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(liveWriteTimeout)); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if row.empty() {
+				continue
+			}
+			messageType := websocket.TextMessage
+			var message []byte
+			var err error
+			if req.Format == liveFormatPNG {
+				messageType = websocket.BinaryMessage
+				message, err = row.png(req)
+			} else {
+				message, err = json.Marshal(row.json())
+			}
+			if err != nil {
+				slog.Warn("unable to encode live row", "error", err)
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+			if err := conn.WriteMessage(messageType, message); err != nil {
+				slog.Debug("closing live connection", "error", err)
+				return
+			}
+			row = newLiveRow(req.ImgWidth)
+		}
+	}
+}
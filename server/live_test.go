@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/hb9tf/spectre/extraction"
+	"github.com/hb9tf/spectre/sdr"
+)
+
+func TestLiveRequestValidate(t *testing.T) {
+	minDB, maxDB := -100.0, -20.0
+	tests := []struct {
+		name         string
+		req          liveRequest
+		wantErr      bool
+		wantWidth    int
+		wantInterval time.Duration
+		wantFormat   string
+	}{
+		{name: "defaults", req: liveRequest{StartFreq: 100, EndFreq: 200}, wantWidth: defaultLiveWidth, wantInterval: defaultLiveInterval, wantFormat: liveFormatJSON},
+		{name: "options", req: liveRequest{StartFreq: 100, EndFreq: 200, ImgWidth: 50, Interval: "1s", Format: "PNG", MinDB: &minDB, MaxDB: &maxDB}, wantWidth: 50, wantInterval: time.Second, wantFormat: liveFormatPNG},
+		{name: "end below start", req: liveRequest{StartFreq: 200, EndFreq: 100}, wantErr: true},
+		{name: "negative start", req: liveRequest{StartFreq: -1, EndFreq: 100}, wantErr: true},
+		{name: "too wide", req: liveRequest{StartFreq: 100, EndFreq: 200, ImgWidth: maxLiveWidth + 1}, wantErr: true},
+		{name: "invalid interval", req: liveRequest{StartFreq: 100, EndFreq: 200, Interval: "5"}, wantErr: true},
+		{name: "interval too short", req: liveRequest{StartFreq: 100, EndFreq: 200, Interval: "10ms"}, wantErr: true},
+		{name: "png without dB range", req: liveRequest{StartFreq: 100, EndFreq: 200, Format: liveFormatPNG}, wantErr: true},
+		{name: "png with inverted dB range", req: liveRequest{StartFreq: 100, EndFreq: 200, Format: liveFormatPNG, MinDB: &maxDB, MaxDB: &minDB}, wantErr: true},
+		{name: "unknown format", req: liveRequest{StartFreq: 100, EndFreq: 200, Format: "jpg"}, wantErr: true},
+		{name: "unknown metric", req: liveRequest{StartFreq: 100, EndFreq: 200, Metric: "median"}, wantErr: true},
+		{name: "unknown palette", req: liveRequest{StartFreq: 100, EndFreq: 200, Palette: "rainbow"}, wantErr: true},
+		{name: "invalid tags", req: liveRequest{StartFreq: 100, EndFreq: 200, Tags: "antenna"}, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.req.validate()
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("validate() error = %v, want error: %t", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if tc.req.ImgWidth != tc.wantWidth || tc.req.interval != tc.wantInterval || tc.req.Format != tc.wantFormat {
+				t.Errorf("validate() set width %d, interval %s and format %q, want %d, %s and %q", tc.req.ImgWidth, tc.req.interval, tc.req.Format, tc.wantWidth, tc.wantInterval, tc.wantFormat)
+			}
+		})
+	}
+}
+
+func TestLiveRequestMatches(t *testing.T) {
+	req := &liveRequest{SDR: "rtlsdr", Identifier: "a, b", Tags: "antenna=discone", StartFreq: 100, EndFreq: 200}
+	if err := req.validate(); err != nil {
+		t.Fatalf("validate() failed: %s", err)
+	}
+	match := sdr.Sample{Source: "rtlsdr", Identifier: "b", FreqLow: 100, FreqHigh: 110, Metadata: map[string]string{"antenna": "discone", "feed": "roof"}}
+	tests := []struct {
+		name   string
+		modify func(*sdr.Sample)
+		want   bool
+	}{
+		{name: "match", modify: func(*sdr.Sample) {}, want: true},
+		{name: "other source", modify: func(s *sdr.Sample) { s.Source = "hackrf" }},
+		{name: "other identifier", modify: func(s *sdr.Sample) { s.Identifier = "c" }},
+		{name: "below range", modify: func(s *sdr.Sample) { s.FreqLow = 90 }},
+		{name: "above range", modify: func(s *sdr.Sample) { s.FreqHigh = 210 }},
+		{name: "other tag value", modify: func(s *sdr.Sample) { s.Metadata = map[string]string{"antenna": "yagi"} }},
+		{name: "no tags", modify: func(s *sdr.Sample) { s.Metadata = nil }},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := match
+			tc.modify(&s)
+			if got := req.matches(&s); got != tc.want {
+				t.Errorf("matches() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLiveRow(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+	samples := []sdr.Sample{
+		{FreqCenter: 105, DBLow: -60, DBHigh: -40, DBAvg: -50, Start: start.Add(time.Second)},
+		{FreqCenter: 110, DBLow: -70, DBHigh: -30, DBAvg: -40, Start: start},
+		{FreqCenter: 190, DBLow: -80, DBHigh: -80, DBAvg: -80, Start: start},
+		{FreqCenter: 500, DBLow: -90, DBHigh: -90, DBAvg: -90, Start: start}, // clamped to the last bucket
+	}
+	tests := []struct {
+		metric string
+		want   []*float64
+	}{
+		{metric: extraction.MetricHigh, want: []*float64{ptr(-30.0), nil, nil, nil, ptr(-80.0)}},
+		{metric: extraction.MetricLow, want: []*float64{ptr(-70.0), nil, nil, nil, ptr(-90.0)}},
+		{metric: extraction.MetricAvg, want: []*float64{ptr(-45.0), nil, nil, nil, ptr(-85.0)}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.metric, func(t *testing.T) {
+			req := &liveRequest{StartFreq: 100, EndFreq: 200, Metric: tc.metric}
+			row := newLiveRow(5)
+			if !row.empty() {
+				t.Error("new row isn't empty")
+			}
+			for _, s := range samples {
+				row.add(&s, req)
+			}
+			got := row.json()
+			if ms := got["time"]; ms != start.UnixMilli() {
+				t.Errorf("row starts at %v, want the earliest sample at %d", ms, start.UnixMilli())
+			}
+			dbs := got["db"].([]*float64)
+			for i := range tc.want {
+				if (dbs[i] == nil) != (tc.want[i] == nil) || (dbs[i] != nil && *dbs[i] != *tc.want[i]) {
+					t.Errorf("bucket %d = %v, want %v", i, deref(dbs[i]), deref(tc.want[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestLiveRowPNG(t *testing.T) {
+	minDB, maxDB := -100.0, -50.0
+	req := &liveRequest{StartFreq: 100, EndFreq: 200, Metric: extraction.MetricHigh, Palette: extraction.PaletteGrayscale, MinDB: &minDB, MaxDB: &maxDB}
+	row := newLiveRow(3)
+	row.add(&sdr.Sample{FreqCenter: 110, DBHigh: -120}, req) // below the range
+	row.add(&sdr.Sample{FreqCenter: 190, DBHigh: -40}, req)  // above the range
+	b, err := row.png(req)
+	if err != nil {
+		t.Fatalf("png() failed: %s", err)
+	}
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("unable to decode PNG: %s", err)
+	}
+	if got := img.Bounds().Size(); got.X != 3 || got.Y != 1 {
+		t.Fatalf("row is %s pixels, want 3x1", got)
+	}
+	for x, want := range []uint32{0x0000, 0, 0xffff} {
+		r, _, _, a := img.At(x, 0).RGBA()
+		wantAlpha := uint32(0xffff)
+		if x == 1 {
+			wantAlpha = 0 // no samples
+		}
+		if r != want || a != wantAlpha {
+			t.Errorf("pixel %d has red %#x and alpha %#x, want %#x and %#x", x, r, a, want, wantAlpha)
+		}
+	}
+}
+
+func ptr(f float64) *float64 {
+	return &f
+}
+
+func deref(f *float64) any {
+	if f == nil {
+		return nil
+	}
+	return *f
+}
@@ -2,33 +2,59 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"flag"
-	"image/jpeg"
-	"image/png"
+	"fmt"
+	"image/color"
+	"io"
+	"log/slog"
 	"math"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-sql-driver/mysql"
-	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/hb9tf/spectre/config"
 	"github.com/hb9tf/spectre/export"
 	"github.com/hb9tf/spectre/extraction"
+	"github.com/hb9tf/spectre/filter"
+	"github.com/hb9tf/spectre/logging"
 	"github.com/hb9tf/spectre/sdr"
 
-	// Blind import support for sqlite3 used by sqlite.go.
+	// Blind import support for sqlite3 used by the sqlite storage.
 	_ "github.com/mattn/go-sqlite3"
 )
 
 var (
+	configFile = flag.String("config", "", "Path to a YAML or JSON file with flag values, flags given on the command line take precedence.")
+
+	// Logging
+	logFormat = flag.String("logFormat", logging.FormatText, "Log format (one of: text, json).")
+	logLevel  = flag.String("logLevel", "info", "Minimum level of messages to log (one of: debug, info, warn, error).")
+
 	listen  = flag.String("listen", ":8080", "")
 	storage = flag.String("storage", "", "Storage solutions to use (one of: sqlite, mysql)")
 
+	// SQL (sqlite and mysql)
+	sqlBatchSize     = flag.Int("sqlBatchSize", 1000, "Maximum number of samples to insert in one transaction.")
+	sqlFlushInterval = flag.Duration("sqlFlushInterval", 5*time.Second, "Maximum duration to keep samples before inserting them.")
+	sqlUpsert        = flag.Bool("sqlUpsert", false, "Replace samples which are already stored (same identifier, source, center frequency and start) instead of inserting duplicates.")
+
 	// SQLite
 	sqliteFile = flag.String("sqliteFile", "/tmp/spectre", "File path of the sqlite DB file to use.")
 
@@ -37,29 +63,349 @@ var (
 	mysqlUser         = flag.String("mysqlUser", "", "MySQL DB user.")
 	mysqlPasswordFile = flag.String("mysqlPasswordFile", "", "Path to the file containing the password for the MySQL user.")
 	mysqlDBName       = flag.String("mysqlDBName", "spectre", "Name of the DB to use.")
+
+	// Authentication
+	apiKeyFile = flag.String("apiKeyFile", "", "Path to the file containing the API keys (one per line) collectors need to send samples. Authentication is disabled when unset.")
+
+	// TLS
+	certFile     = flag.String("certFile", "", "Path to the TLS certificate file, HTTPS is served when set together with keyFile.")
+	keyFile      = flag.String("keyFile", "", "Path to the TLS private key file.")
+	clientCAFile = flag.String("clientCAFile", "", "Path to a PEM file with the CA certificates client certificates are verified against. Clients without a valid certificate are rejected when set (requires certFile and keyFile).")
+
+	// HTTP server limits
+	readTimeout  = flag.Duration("readTimeout", 30*time.Second, "Maximum duration to read a request including its body.")
+	writeTimeout = flag.Duration("writeTimeout", 5*time.Minute, "Maximum duration to write a response, needs to cover the rendering of large images.")
+	idleTimeout  = flag.Duration("idleTimeout", 2*time.Minute, "Maximum duration to keep idle keep-alive connections open.")
+	maxBodySize  = flag.Int64("maxBodySize", 10<<20, "Maximum size in bytes of the (uncompressed) body of collect requests.")
+
+	dedupWindow  = flag.Int("dedupWindow", 0, "Number of recently received samples to remember to drop duplicates (same identifier, source, center frequency and start), e.g. from resent batches, 0 to disable.")
+	sampleBuffer = flag.Int("sampleBuffer", 10000, "Number of received samples to buffer until they are stored, collect requests are rejected when it is full.")
+
+	// Rate limiting
+	collectRate  = flag.Float64("collectRate", 0, "Maximum number of samples per second accepted per collector (identifier), 0 to disable.")
+	collectBurst = flag.Int("collectBurst", 10000, "Maximum number of samples a collector can send at once when it hasn't used its rate, needs to cover a batch.")
+
+	// Additional render backends
+	backends = flag.String("backends", "", "Additional named DBs to render from as comma separated name=driver:DSN pairs, e.g. site1=sqlite:/data/site1.db (driver one of: sqlite, mysql).")
+
+	// Render limits
+	maxPixels  = flag.Int("maxPixels", 25000000, "Maximum size (width * height) of rendered waterfalls in pixels, 0 to disable.")
+	maxSamples = flag.Int64("maxSamples", 50000000, "Maximum number of samples a render request may select, 0 to disable.")
+
+	// Rollup
+	rollupInterval = flag.Duration("rollupInterval", 0, "Interval to aggregate samples into the 1 minute resolution rollup table used for coarse renders, 0 to disable. Only one server may maintain the rollup of a DB.")
+	rollupDelay    = flag.Duration("rollupDelay", 5*time.Minute, "Minimum age of samples before they are aggregated into the rollup, samples stored later are missing in the rollup.")
+
+	// Compression
+	gzipRender = flag.Bool("gzipRender", true, "Compress JSON and SVG render responses with gzip if the client supports it.")
+
+	// Metrics
+	activeCollectorWindow = flag.Duration("activeCollectorWindow", 10*time.Minute, "Duration after which a collector which hasn't sent samples is no longer considered active.")
 )
 
 const (
 	collectEndpoint = "/spectre/v1/collect"
 	renderEndpoint  = "/spectre/v1/render"
+	statsEndpoint   = "/spectre/v1/stats"
+	sourcesEndpoint = "/spectre/v1/sources"
+	samplesEndpoint = "/spectre/v1/samples"
+	liveEndpoint    = "/spectre/v1/live"
+	metricsEndpoint = "/metrics"
+	healthEndpoint  = "/healthz"
+	readyEndpoint   = "/readyz"
+
+	// readyTimeout is how long the readiness check waits for the DB to respond.
+	readyTimeout = 2 * time.Second
+
+	// imageSizeHeader holds the dimensions of the rendered image as <width>x<height> and
+	// imageClampedHeader whether they were reduced to the resolution of the data.
+	imageSizeHeader    = "X-Spectre-Image-Size"
+	imageClampedHeader = "X-Spectre-Clamped"
+
+	// otherSource is the source label of the received samples from unknown sources.
+	otherSource = "other"
 )
 
+var (
+	receivedSamples = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "spectre_received_samples_total",
+		Help: "Number of samples received from collectors.",
+	}, []string{"source"})
+	// metricSources are the sources of the SDRs supported by the collector. The source of the
+	// received samples is set by the clients, all others are counted as otherSource so a client
+	// can't create an unbounded number of time series.
+	metricSources = map[string]bool{
+		"airspy":  true,
+		"fake":    true,
+		"hackrf":  true,
+		"replay":  true,
+		"rtlsdr":  true,
+		"sdrplay": true,
+	}
+	rejectedSamples = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "spectre_rejected_samples_total",
+		Help: "Number of received samples rejected because the sample buffer was full.",
+	})
+	failedInserts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "spectre_failed_inserts_total",
+		Help: "Number of samples which could not be stored.",
+	})
+	renderLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "spectre_render_duration_seconds",
+		Help:    "Time it takes to render and encode an image.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 14), // 10ms to ~80s
+	})
+)
+
+// collectorTracker keeps track of when collectors (identifiers) were last seen.
+type collectorTracker struct {
+	lastSeen map[string]time.Time
+	mu       sync.Mutex
+}
+
+func (t *collectorTracker) seen(identifier string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastSeen[identifier] = time.Now()
+}
+
+// active returns the number of collectors seen within the window and forgets the others.
+func (t *collectorTracker) active(window time.Duration) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for identifier, last := range t.lastSeen {
+		if time.Since(last) > window {
+			delete(t.lastSeen, identifier)
+		}
+	}
+	return len(t.lastSeen)
+}
+
+// rateLimiter is a token bucket per collector (identifier) which refills with rate tokens
+// per second up to burst tokens.
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	mu      sync.Mutex
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes the given number of tokens (samples) per identifier if all buckets have
+// enough of them. Otherwise nothing is taken and false is returned.
+func (l *rateLimiter) allow(counts map[string]int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for identifier, count := range counts {
+		b, ok := l.buckets[identifier]
+		if !ok {
+			b = &bucket{tokens: l.burst, last: now}
+			l.buckets[identifier] = b
+		}
+		b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+		if b.tokens < float64(count) {
+			return false
+		}
+	}
+	for identifier, count := range counts {
+		l.buckets[identifier].tokens -= float64(count)
+	}
+	// Forget buckets which have been refilled completely, they start out full anyway.
+	for identifier, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, identifier)
+		}
+	}
+	return true
+}
+
 type SpectreServer struct {
-	Server  *http.Server
-	DB      *sql.DB
-	Samples chan sdr.Sample
+	Server *http.Server
+	DB     *sql.DB
+	// Samples passes the received samples on to the exporter and live connections.
+	Samples    *fanOut
+	Collectors *collectorTracker
+	// APIKeys are the keys accepted from collectors. Authentication is disabled when empty.
+	APIKeys []string
+	// MaxBodySize is the maximum size of collect requests in bytes, unlimited if 0.
+	MaxBodySize int64
+	// RateLimiter limits the samples accepted per collector, unlimited if nil.
+	RateLimiter *rateLimiter
+	// Backends are additional named DBs render requests can select with the backend parameter.
+	Backends map[string]*sql.DB
+	// RenderLimits caps the resources used by render requests.
+	RenderLimits *extraction.RenderLimits
+	// Dedup drops recently received duplicates before they are stored or passed on to live
+	// connections, disabled if nil.
+	Dedup *filter.FilterDedup
+
+	// dedupMu makes deduplicating and publishing the samples of a request atomic.
+	dedupMu sync.Mutex
+}
+
+// authMiddleware rejects requests which don't provide a valid API key in the Authorization header.
+func (s *SpectreServer) authMiddleware(c *gin.Context) {
+	if len(s.APIKeys) == 0 {
+		return
+	}
+	key, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if ok {
+		for _, valid := range s.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(valid)) == 1 {
+				return
+			}
+		}
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"status": "unauthorized",
+	})
+}
+
+// readAPIKeys reads API keys from a file, one per line. Empty lines and lines starting with # are ignored.
+func readAPIKeys(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no API keys found")
+	}
+	return keys, nil
+}
+
+// clientAuthConfig returns a TLS config which requires client certificates signed by one of
+// the PEM encoded CA certificates in the file at path.
+func clientAuthConfig(path string) (*tls.Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, errors.New("no PEM encoded certificates found")
+	}
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// parseBackends opens the DBs of a comma separated list of name=driver:DSN pairs.
+func parseBackends(spec string) (map[string]*sql.DB, error) {
+	backends := map[string]*sql.DB{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, source, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("backend %q is not in the format name=driver:DSN", entry)
+		}
+		if _, ok := backends[name]; ok {
+			return nil, fmt.Errorf("backend %q is defined more than once", name)
+		}
+		driver, dsn, ok := strings.Cut(source, ":")
+		if !ok || dsn == "" {
+			return nil, fmt.Errorf("backend %q is not in the format name=driver:DSN", entry)
+		}
+		switch strings.ToLower(driver) {
+		case "sqlite":
+			if _, err := os.Stat(dsn); err != nil {
+				return nil, fmt.Errorf("unable to open sqlite DB %q of backend %q: %s", dsn, name, err)
+			}
+			driver = "sqlite3"
+		case "mysql":
+		default:
+			return nil, fmt.Errorf("driver %q of backend %q is not supported, pick one of: sqlite, mysql", driver, name)
+		}
+		db, err := sql.Open(driver, dsn)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open DB of backend %q: %s", name, err)
+		}
+		backends[name] = db
+	}
+	return backends, nil
+}
+
+// backendDB returns the DB to render from, the storage DB if no backend is given.
+func (s *SpectreServer) backendDB(backend string) (*sql.DB, error) {
+	if backend == "" {
+		if s.DB == nil {
+			return nil, errors.New("the configured storage does not support queries")
+		}
+		return s.DB, nil
+	}
+	db, ok := s.Backends[backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q", backend)
+	}
+	return db, nil
 }
 
 func (s *SpectreServer) collectHandler(c *gin.Context) {
-	samples := []sdr.Sample{}
+	if s.MaxBodySize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, s.MaxBodySize)
+	}
+	if strings.EqualFold(c.GetHeader("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(c.Request.Body)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		defer zr.Close()
+		c.Request.Body = zr
+		// Also limit the uncompressed size which can be a lot larger.
+		if s.MaxBodySize > 0 {
+			c.Request.Body = http.MaxBytesReader(c.Writer, zr, s.MaxBodySize)
+		}
+	}
 
-	if err := c.BindJSON(&samples); err != nil {
-		c.AbortWithStatus(http.StatusBadRequest)
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.AbortWithError(http.StatusRequestEntityTooLarge, fmt.Errorf("request body is larger than %d bytes", maxBytesErr.Limit))
+			return
+		}
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	samples, err := decodeSamples(body)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
 		return
 	}
 
-	for _, sample := range samples {
-		s.Samples <- sample
+	if s.RateLimiter != nil {
+		counts := map[string]int{}
+		for _, sample := range samples {
+			counts[sample.Identifier]++
+		}
+		if !s.RateLimiter.allow(counts) {
+			c.AbortWithError(http.StatusTooManyRequests, errors.New("collector exceeded its sample rate"))
+			return
+		}
+	}
+
+	if !s.enqueue(samples) {
+		rejectedSamples.Add(float64(len(samples)))
+		c.AbortWithError(http.StatusServiceUnavailable, errors.New("sample buffer is full, retry later"))
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -68,18 +414,161 @@ func (s *SpectreServer) collectHandler(c *gin.Context) {
 	})
 }
 
+// enqueue buffers all samples if there is enough space left, otherwise none are buffered
+// so the client can resend them without creating duplicates.
+func (s *SpectreServer) enqueue(samples []sdr.Sample) bool {
+	if s.Dedup != nil {
+		s.dedupMu.Lock()
+		defer s.dedupMu.Unlock()
+		var unique []sdr.Sample
+		for _, sample := range samples {
+			if !s.Dedup.ShouldIgnore(&sample) {
+				unique = append(unique, sample)
+			}
+		}
+		samples = unique
+	}
+	if !s.Samples.publish(samples) {
+		// The samples are going to be resent, so they must not be dropped as duplicates then.
+		if s.Dedup != nil {
+			for _, sample := range samples {
+				s.Dedup.Forget(&sample)
+			}
+		}
+		return false
+	}
+	for _, sample := range samples {
+		receivedSamples.WithLabelValues(sourceLabel(sample.Source)).Inc()
+		s.Collectors.seen(sample.Identifier)
+	}
+	return true
+}
+
+// sourceLabel returns the value of the source label of the received samples metric.
+func sourceLabel(source string) string {
+	if metricSources[source] {
+		return source
+	}
+	return otherSource
+}
+
+// gzipContentTypes are the render responses which benefit from compression, the other image
+// types already are compressed.
+var gzipContentTypes = map[string]bool{
+	"application/json":                           true,
+	extraction.ContentType(extraction.FormatSVG): true,
+}
+
+// gzipWriter compresses the response if its content type is one of the gzipContentTypes.
+type gzipWriter struct {
+	gin.ResponseWriter
+	zw *gzip.Writer
+	// decided is set on the first write, compress when the response is compressed.
+	decided  bool
+	compress bool
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		// Only decide once there is a body, responses aborted without one stay as they are.
+		w.decided = true
+		mediaType, _, _ := strings.Cut(w.Header().Get("Content-Type"), ";")
+		w.compress = gzipContentTypes[strings.TrimSpace(mediaType)]
+		if w.compress {
+			w.Header().Del("Content-Length")
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+	}
+	if !w.compress {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.zw.Write(b)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Written also reports data buffered by the compressor which hasn't reached the client yet.
+func (w *gzipWriter) Written() bool {
+	return w.compress || w.ResponseWriter.Written()
+}
+
+// gzipMiddleware compresses JSON and SVG render responses for clients accepting gzip.
+func gzipMiddleware(c *gin.Context) {
+	if !strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+		c.Next()
+		return
+	}
+	c.Header("Vary", "Accept-Encoding")
+	w := &gzipWriter{
+		ResponseWriter: c.Writer,
+		zw:             gzip.NewWriter(c.Writer),
+	}
+	c.Writer = w
+	defer func() {
+		if w.compress {
+			if err := w.zw.Close(); err != nil {
+				slog.Warn("error compressing response", "error", err)
+			}
+		}
+		c.Writer = w.ResponseWriter
+	}()
+	c.Next()
+}
+
+// decodeSamples decodes either a JSON array of samples or a single sample object.
+func decodeSamples(body []byte) ([]sdr.Sample, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '{' {
+		var sample sdr.Sample
+		if err := json.Unmarshal(body, &sample); err != nil {
+			return nil, err
+		}
+		return []sdr.Sample{sample}, nil
+	}
+	samples := []sdr.Sample{}
+	if err := json.Unmarshal(body, &samples); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
 func (s *SpectreServer) renderHandler(c *gin.Context) {
+	timer := prometheus.NewTimer(renderLatency)
+	defer timer.ObserveDuration()
+
 	type queryParameters struct {
-		SDR        string `form:"sdr"`
-		Identifier string `form:"identifier"`
-		StartFreq  int64  `form:"startFreq"`
-		EndFreq    int64  `form:"endFreq"`
-		StartTime  int64  `form:"startTime"`
-		EndTime    int64  `form:"endTime"`
-		AddGrid    string `form:"addGrid"`
-		ImgWidth   int    `form:"imgWidth"`
-		ImgHeight  int    `form:"imgHeight"`
-		ImageType  string `form:"imageType"`
+		SDR         string   `form:"sdr"`
+		Identifier  string   `form:"identifier"`
+		Tags        string   `form:"tags"`
+		Backend     string   `form:"backend"`
+		StartFreq   int64    `form:"startFreq"`
+		EndFreq     int64    `form:"endFreq"`
+		StartTime   int64    `form:"startTime"`
+		EndTime     int64    `form:"endTime"`
+		Last        string   `form:"last"`
+		AddGrid     string   `form:"addGrid"`
+		AddLegend   string   `form:"addLegend"`
+		LogFreq     string   `form:"logFreq"`
+		PeakHold    string   `form:"peakHold"`
+		WeightCount string   `form:"weightBySampleCount"`
+		Smooth      int      `form:"smoothKernel"`
+		Mode        string   `form:"mode"`
+		Markers     string   `form:"markers"`
+		ImgWidth    int      `form:"imgWidth"`
+		ImgHeight   int      `form:"imgHeight"`
+		ImageType   string   `form:"imageType"`
+		Quality     int      `form:"quality"`
+		Transparent string   `form:"transparent"`
+		GridColor   string   `form:"gridColor"`
+		TextColor   string   `form:"textColor"`
+		BgColor     string   `form:"backgroundColor"`
+		Palette     string   `form:"palette"`
+		Metric      string   `form:"metric"`
+		Timezone    string   `form:"tz"`
+		MinDB       *float64 `form:"minDB"`
+		MaxDB       *float64 `form:"maxDB"`
 	}
 
 	parsedQueryParameters := queryParameters{}
@@ -88,6 +577,12 @@ func (s *SpectreServer) renderHandler(c *gin.Context) {
 		return
 	}
 
+	db, err := s.backendDB(parsedQueryParameters.Backend)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
 	var startFreq int64 // default to the lowest possible frequency
 	if parsedQueryParameters.StartFreq != 0 {
 		startFreq = parsedQueryParameters.StartFreq
@@ -108,11 +603,40 @@ func (s *SpectreServer) renderHandler(c *gin.Context) {
 		endTime = time.Unix(0, parsedQueryParameters.EndTime*1000000) // from milli to nano
 	}
 
+	// A relative time window overrides the absolute start and end times.
+	if parsedQueryParameters.Last != "" {
+		startTime, endTime, err = parseLast(parsedQueryParameters.Last)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+	}
+
 	addGrid := true
 	if parsedQueryParameters.AddGrid == "0" || parsedQueryParameters.AddGrid == "false" {
 		addGrid = false
 	}
 
+	addLegend := false
+	if parsedQueryParameters.AddLegend == "1" || parsedQueryParameters.AddLegend == "true" {
+		addLegend = true
+	}
+
+	logFreq := false
+	if parsedQueryParameters.LogFreq == "1" || parsedQueryParameters.LogFreq == "true" {
+		logFreq = true
+	}
+
+	peakHold := false
+	if parsedQueryParameters.PeakHold == "1" || parsedQueryParameters.PeakHold == "true" {
+		peakHold = true
+	}
+
+	weightBySampleCount := false
+	if parsedQueryParameters.WeightCount == "1" || parsedQueryParameters.WeightCount == "true" {
+		weightBySampleCount = true
+	}
+
 	var imgWidth int
 	if parsedQueryParameters.ImgWidth != 0 {
 		imgWidth = parsedQueryParameters.ImgWidth
@@ -123,51 +647,411 @@ func (s *SpectreServer) renderHandler(c *gin.Context) {
 		imgHeight = parsedQueryParameters.ImgHeight
 	}
 
-	result, err := extraction.Render(s.DB, &extraction.RenderRequest{
+	transparent := false
+	if parsedQueryParameters.Transparent == "1" || parsedQueryParameters.Transparent == "true" {
+		transparent = true
+	}
+
+	loc, err := time.LoadLocation(parsedQueryParameters.Timezone) // empty is UTC
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	colors, err := parseGridColors(parsedQueryParameters.GridColor, parsedQueryParameters.TextColor, parsedQueryParameters.BgColor)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	markers, err := extraction.ParseMarkers(parsedQueryParameters.Markers)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	tags, err := sdr.ParseMetadata(parsedQueryParameters.Tags)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	req := &extraction.RenderRequest{
 		Image: &extraction.ImageOptions{
-			Height:  imgHeight,
-			Width:   imgWidth,
-			AddGrid: addGrid,
+			Height:                imgHeight,
+			Mode:                  strings.ToLower(parsedQueryParameters.Mode),
+			Width:                 imgWidth,
+			AddGrid:               addGrid,
+			AddLegend:             addLegend,
+			LogFreqAxis:           logFreq,
+			PeakHold:              peakHold,
+			WeightBySampleCount:   weightBySampleCount,
+			SmoothKernel:          parsedQueryParameters.Smooth,
+			Markers:               markers,
+			TransparentBackground: transparent,
+			Colors:                &colors,
+			Location:              loc,
+			Palette:               strings.ToLower(parsedQueryParameters.Palette),
+			Metric:                strings.ToLower(parsedQueryParameters.Metric),
+			MinDB:                 parsedQueryParameters.MinDB,
+			MaxDB:                 parsedQueryParameters.MaxDB,
+			Quality:               parsedQueryParameters.Quality,
 		},
 		Filter: &extraction.FilterOptions{
 			SDR:        parsedQueryParameters.SDR,
 			Identifier: parsedQueryParameters.Identifier,
+			Tags:       tags,
 			StartFreq:  startFreq,
 			EndFreq:    endFreq,
 			StartTime:  startTime,
 			EndTime:    endTime,
 		},
-	})
+	}
+	s.render(c, db, req, parsedQueryParameters.ImageType)
+}
+
+// renderJSONHandler is the POST variant of renderHandler which takes the filter and image
+// options as JSON body for requests which are awkward to express as query parameters.
+func (s *SpectreServer) renderJSONHandler(c *gin.Context) {
+	timer := prometheus.NewTimer(renderLatency)
+	defer timer.ObserveDuration()
+
+	type renderBody struct {
+		Backend   string                   `json:"backend"`
+		Last      string                   `json:"last"`
+		ImageType string                   `json:"imageType"`
+		Timezone  string                   `json:"tz"`
+		GridColor string                   `json:"gridColor"`
+		TextColor string                   `json:"textColor"`
+		BgColor   string                   `json:"backgroundColor"`
+		Filter    extraction.FilterOptions `json:"filter"`
+		Image     extraction.ImageOptions  `json:"image"`
+	}
+
+	if s.MaxBodySize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, s.MaxBodySize)
+	}
+	body := renderBody{
+		Image: extraction.ImageOptions{AddGrid: true}, // same default as the GET variant
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	db, err := s.backendDB(body.Backend)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
 		return
 	}
 
-	buf := new(bytes.Buffer)
-	contentType := ""
-	switch strings.ToLower(parsedQueryParameters.ImageType) {
-	case "png":
-		contentType = "image/png"
-		png.Encode(buf, result.Image)
-	default:
-		contentType = "image/jpeg"
-		jpeg.Encode(buf, result.Image, &jpeg.Options{Quality: jpeg.DefaultQuality})
+	// Same defaults as the GET variant.
+	if body.Filter.EndFreq == 0 {
+		body.Filter.EndFreq = math.MaxInt64
+	}
+	if body.Filter.EndTime.IsZero() {
+		body.Filter.EndTime = time.Now().Add(24 * time.Hour)
+	}
+	if body.Last != "" {
+		body.Filter.StartTime, body.Filter.EndTime, err = parseLast(body.Last)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	loc, err := time.LoadLocation(body.Timezone) // empty is UTC
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	colors, err := parseGridColors(body.GridColor, body.TextColor, body.BgColor)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	body.Image.Location = loc
+	body.Image.Colors = &colors
+	body.Image.Mode = strings.ToLower(body.Image.Mode)
+	body.Image.Palette = strings.ToLower(body.Image.Palette)
+	body.Image.Metric = strings.ToLower(body.Image.Metric)
+
+	s.render(c, db, &extraction.RenderRequest{
+		Filter: &body.Filter,
+		Image:  &body.Image,
+	}, body.ImageType)
+}
+
+// parseLast returns the time window of the given duration ending now.
+func parseLast(raw string) (time.Time, time.Time, error) {
+	last, err := time.ParseDuration(raw)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if last <= 0 {
+		return time.Time{}, time.Time{}, fmt.Errorf("last needs to be a positive duration, got %s", last)
+	}
+	end := time.Now()
+	return end.Add(-last), end, nil
+}
+
+// parseGridColors returns the default grid colors overridden by the non-empty colors.
+func parseGridColors(grid, text, background string) (extraction.GridColors, error) {
+	colors := extraction.DefaultGridColors
+	for _, opt := range []struct {
+		value  string
+		target *color.RGBA
+	}{
+		{grid, &colors.Grid},
+		{text, &colors.Text},
+		{background, &colors.Background},
+	} {
+		if opt.value == "" {
+			continue
+		}
+		parsed, err := extraction.ParseColor(opt.value)
+		if err != nil {
+			return colors, err
+		}
+		*opt.target = parsed
+	}
+	return colors, nil
+}
+
+// render renders the request in the image type (JPEG for unknown types) and responds with it.
+func (s *SpectreServer) render(c *gin.Context, db *sql.DB, req *extraction.RenderRequest, imageType string) {
+	imageType = strings.ToLower(imageType)
+	if extraction.ContentType(imageType) == "" && imageType != extraction.FormatJSON {
+		imageType = extraction.FormatJPEG // default to JPEG for unknown image types
+	}
+	if req.Image.TransparentBackground && !extraction.SupportsTransparency(imageType) {
+		c.AbortWithError(http.StatusBadRequest, errors.New("transparency is only supported for PNG, WebP, TIFF and SVG images"))
+		return
+	}
+	req.Limits = s.RenderLimits
+
+	if imageType == extraction.FormatJSON {
+		matrix, err := extraction.RenderMatrix(db, req)
+		if err != nil {
+			s.abortRender(c, err)
+			return
+		}
+		setSizeHeaders(c, len(matrix.Freqs), len(matrix.Times), matrix.Clamped)
+		c.JSON(http.StatusOK, matrix)
+		return
+	}
+
+	result, err := extraction.RenderFormat(db, req, imageType)
+	if err != nil {
+		s.abortRender(c, err)
+		return
+	}
+	// The image is streamed to the client while being encoded.
+	setSizeHeaders(c, result.ImageMeta.ImageWidth, result.ImageMeta.ImageHeight, result.ImageMeta.Clamped)
+	c.Header("Content-Type", extraction.ContentType(imageType))
+	if err := extraction.Encode(c.Writer, result, imageType, req.Image.Quality); err != nil {
+		slog.Warn("error streaming rendered image", "error", err)
+	}
+}
+
+// setSizeHeaders tells clients the dimensions of the rendered image and whether they are
+// smaller than requested because the data doesn't provide a higher resolution.
+func setSizeHeaders(c *gin.Context, width, height int, clamped bool) {
+	c.Header(imageSizeHeader, fmt.Sprintf("%dx%d", width, height))
+	c.Header(imageClampedHeader, strconv.FormatBool(clamped))
+}
+
+// abortRender responds with the status matching the render error.
+func (s *SpectreServer) abortRender(c *gin.Context, err error) {
+	if errors.Is(err, extraction.ErrNoSamples) {
+		c.Error(err)
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"status": "not found",
+			"error":  err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, extraction.ErrLimitExceeded) {
+		c.Error(err)
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+			"status": "limit exceeded",
+			"error":  err.Error(),
+		})
+		return
+	}
+	c.AbortWithError(http.StatusBadRequest, err)
+}
+
+func (s *SpectreServer) statsHandler(c *gin.Context) {
+	if s.DB == nil {
+		c.AbortWithError(http.StatusNotImplemented, errors.New("the configured storage does not support queries"))
+		return
+	}
+
+	stats, err := extraction.GetStats(s.DB)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	identifiers := []gin.H{}
+	for _, identifier := range stats.Identifiers {
+		identifiers = append(identifiers, gin.H{
+			"identifier":  identifier.Identifier,
+			"sampleCount": identifier.SampleCount,
+		})
+	}
+	resp := gin.H{
+		"sampleCount": stats.SampleCount,
+		"sources":     stats.Sources,
+		"identifiers": identifiers,
+	}
+	if stats.SampleCount > 0 {
+		resp["lowFreq"] = stats.LowFreq
+		resp["highFreq"] = stats.HighFreq
+		resp["startTime"] = stats.StartTime.UnixMilli()
+		resp["endTime"] = stats.EndTime.UnixMilli()
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *SpectreServer) sourcesHandler(c *gin.Context) {
+	if s.DB == nil {
+		c.AbortWithError(http.StatusNotImplemented, errors.New("the configured storage does not support queries"))
+		return
+	}
+
+	infos, err := extraction.GetSourceInfos(s.DB)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	sources := []gin.H{}
+	for _, info := range infos {
+		sources = append(sources, gin.H{
+			"source":     info.Source,
+			"identifier": info.Identifier,
+			"freqLow":    info.LowFreq,
+			"freqHigh":   info.HighFreq,
+			"startTime":  info.StartTime.UnixMilli(),
+			"endTime":    info.EndTime.UnixMilli(),
+		})
+	}
+	c.JSON(http.StatusOK, sources)
+}
+
+// deleteSamplesHandler deletes all samples of an identifier, optionally limited to a source.
+func (s *SpectreServer) deleteSamplesHandler(c *gin.Context) {
+	if s.DB == nil {
+		c.AbortWithError(http.StatusNotImplemented, errors.New("the configured storage does not support queries"))
+		return
+	}
+	// authMiddleware lets all requests pass without API keys which is fine for collecting
+	// samples but not for deleting them.
+	if len(s.APIKeys) == 0 {
+		c.AbortWithError(http.StatusForbidden, errors.New("deleting samples requires API keys to be configured"))
+		return
+	}
+	identifier := c.Query("identifier")
+	// Don't let an empty filter delete the whole table.
+	if identifier == "" {
+		c.AbortWithError(http.StatusBadRequest, errors.New("identifier is required"))
+		return
+	}
+
+	deleted, err := export.DeleteSamples(s.DB, export.DeleteFilter{
+		Source:     c.Query("source"),
+		Identifier: identifier,
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	slog.Info("deleted samples", "source", c.Query("source"), "identifier", identifier, "count", deleted)
+	c.JSON(http.StatusOK, gin.H{
+		"status":       "success",
+		"deletedCount": deleted,
+	})
+}
+
+// healthHandler reports that the process is up.
+func (s *SpectreServer) healthHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+	})
+}
+
+// requestLogger logs every request once it has been handled.
+func requestLogger(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	attrs := []any{
+		"method", c.Request.Method,
+		"path", c.Request.URL.Path,
+		"status", c.Writer.Status(),
+		"latency", time.Since(start),
+		"clientIP", c.ClientIP(),
+	}
+	if errs := c.Errors.ByType(gin.ErrorTypeAny); len(errs) > 0 {
+		attrs = append(attrs, "error", errs.String())
 	}
+	slog.Info("handled request", attrs...)
+}
+
+// readyHandler reports whether the storage DB can be reached.
+func (s *SpectreServer) readyHandler(c *gin.Context) {
+	if s.DB != nil {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), readyTimeout)
+		defer cancel()
+		if err := s.DB.PingContext(ctx); err != nil {
+			c.Error(err)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"status": "unavailable",
+				"error":  err.Error(),
+			})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+	})
+}
+
+// runRollup periodically aggregates the samples which are older than delay into the rollup.
+func runRollup(db *sql.DB, dialect string, interval, delay time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		rows, err := export.UpdateRollup(db, dialect, time.Now().Add(-delay))
+		if err != nil {
+			slog.Warn("error updating rollup", "error", err)
+			continue
+		}
+		slog.Debug("updated rollup", "rows", rows)
+	}
+}
 
-	c.Data(http.StatusOK, contentType, buf.Bytes())
+func onInsertError(sdr.Sample, error) {
+	failedInserts.Inc()
 }
 
 func main() {
 	ctx := context.Background()
-	// Set defaults for glog flags. Can be overridden via cmdline.
-	flag.Set("logtostderr", "false")
-	flag.Set("stderrthreshold", "WARNING")
-	flag.Set("v", "1")
 	// Parse flags globally.
 	flag.Parse()
+	if *configFile != "" {
+		if err := config.Load(flag.CommandLine, *configFile, os.Args[1:]); err != nil {
+			logging.Exit("unable to load config", "error", err)
+		}
+	}
+	if err := logging.Setup(*logFormat, *logLevel); err != nil {
+		logging.Exit("unable to set up logging", "error", err)
+	}
 
 	// Exporter and storage setup
 	var db *sql.DB
+	var dialect string
 	var exporter export.Exporter
 	switch strings.ToLower(*storage) {
 	case "csv": // CSV is a silent option as it only exports data but can't be used to render.
@@ -176,15 +1060,21 @@ func main() {
 		var err error
 		db, err = sql.Open("sqlite3", *sqliteFile)
 		if err != nil {
-			glog.Exitf("unable to open sqlite DB %q: %s", *sqliteFile, err)
+			logging.Exit("unable to open sqlite DB", "file", *sqliteFile, "error", err)
 		}
+		dialect = export.DialectSQLite
 		exporter = &export.SQL{
-			DB: db,
+			DB:            db,
+			Dialect:       dialect,
+			OnInsertError: onInsertError,
+			BatchSize:     *sqlBatchSize,
+			FlushInterval: *sqlFlushInterval,
+			Upsert:        *sqlUpsert,
 		}
 	case "mysql":
 		pass, err := os.ReadFile(*mysqlPasswordFile)
 		if err != nil {
-			glog.Exitf("unable to read MySQL password file %q: %s\n", *mysqlPasswordFile, err)
+			logging.Exit("unable to read MySQL password file", "file", *mysqlPasswordFile, "error", err)
 		}
 		cfg := mysql.Config{
 			User:   *mysqlUser,
@@ -195,41 +1085,133 @@ func main() {
 		}
 		db, err = sql.Open("mysql", cfg.FormatDSN())
 		if err != nil {
-			glog.Exitf("unable to open MySQL DB %q: %s", *mysqlServer, err)
+			logging.Exit("unable to open MySQL DB", "server", *mysqlServer, "error", err)
 		}
 		db.SetConnMaxLifetime(3 * time.Minute)
 		db.SetMaxOpenConns(10)
 		db.SetMaxIdleConns(10)
+		dialect = export.DialectMySQL
 		exporter = &export.SQL{
-			DB: db,
+			DB:            db,
+			Dialect:       dialect,
+			OnInsertError: onInsertError,
+			BatchSize:     *sqlBatchSize,
+			FlushInterval: *sqlFlushInterval,
+			Upsert:        *sqlUpsert,
 		}
 	default:
-		glog.Exitf("%q is not a supported export method, pick one of: sqlite, mysql", *storage)
+		logging.Exit("unsupported export method, pick one of: sqlite, mysql", "storage", *storage)
 	}
 
 	// Export samples.
-	samples := make(chan sdr.Sample, 1000)
+	if *sampleBuffer < 1 {
+		logging.Exit("-sampleBuffer needs to be at least 1", "sampleBuffer", *sampleBuffer)
+	}
+	// The exporter never misses samples, collect requests are rejected instead when its
+	// buffer is full.
+	samples := newFanOut()
+	exported := samples.subscribe("exporter", *sampleBuffer, true)
 	go func() {
-		if err := exporter.Write(ctx, samples); err != nil {
-			glog.Fatal(err)
+		if err := exporter.Write(ctx, exported.samples); err != nil {
+			logging.Exit("error exporting samples", "error", err)
 		}
 	}()
 
+	if *rollupInterval > 0 {
+		if db == nil {
+			logging.Exit("-rollupInterval requires sqlite or mysql storage", "storage", *storage)
+		}
+		go runRollup(db, dialect, *rollupInterval, *rollupDelay)
+	}
+
+	var apiKeys []string
+	if *apiKeyFile != "" {
+		var err error
+		apiKeys, err = readAPIKeys(*apiKeyFile)
+		if err != nil {
+			logging.Exit("unable to read API key file", "file", *apiKeyFile, "error", err)
+		}
+	}
+
+	var limiter *rateLimiter
+	if *collectRate > 0 {
+		limiter = &rateLimiter{
+			rate:    *collectRate,
+			burst:   float64(*collectBurst),
+			buckets: map[string]*bucket{},
+		}
+	}
+
+	renderBackends, err := parseBackends(*backends)
+	if err != nil {
+		logging.Exit("unable to set up backends", "error", err)
+	}
+
 	// Configure and run webserver.
 	gin.SetMode(gin.ReleaseMode)
-	router := gin.Default()
+	router := gin.New()
+	router.Use(requestLogger, gin.Recovery())
 	s := SpectreServer{
 		Server: &http.Server{
-			Addr:    *listen,
-			Handler: router, // use `http.DefaultServeMux`
+			Addr:         *listen,
+			Handler:      router, // use `http.DefaultServeMux`
+			ReadTimeout:  *readTimeout,
+			WriteTimeout: *writeTimeout,
+			IdleTimeout:  *idleTimeout,
 		},
 		DB:      db,
 		Samples: samples,
+		Collectors: &collectorTracker{
+			lastSeen: map[string]time.Time{},
+		},
+		APIKeys:     apiKeys,
+		MaxBodySize: *maxBodySize,
+		RateLimiter: limiter,
+		Backends:    renderBackends,
+		RenderLimits: &extraction.RenderLimits{
+			MaxPixels:  *maxPixels,
+			MaxSamples: *maxSamples,
+		},
+	}
+	if *dedupWindow > 0 {
+		s.Dedup = &filter.FilterDedup{Size: *dedupWindow}
 	}
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "spectre_active_collectors",
+		Help: "Number of distinct collectors (identifiers) which recently sent samples.",
+	}, func() float64 {
+		return float64(s.Collectors.active(*activeCollectorWindow))
+	})
 
-	router.POST(collectEndpoint, s.collectHandler)
-	router.GET(renderEndpoint, s.renderHandler)
+	router.POST(collectEndpoint, s.authMiddleware, s.collectHandler)
+	var renderMiddleware []gin.HandlerFunc
+	if *gzipRender {
+		renderMiddleware = append(renderMiddleware, gzipMiddleware)
+	}
+	router.GET(renderEndpoint, append(renderMiddleware, s.renderHandler)...)
+	router.POST(renderEndpoint, append(renderMiddleware, s.renderJSONHandler)...)
+	router.GET(statsEndpoint, s.statsHandler)
+	router.GET(sourcesEndpoint, s.sourcesHandler)
+	router.DELETE(samplesEndpoint, s.authMiddleware, s.deleteSamplesHandler)
+	router.GET(liveEndpoint, s.liveHandler)
+	router.GET(metricsEndpoint, gin.WrapH(promhttp.Handler()))
+	router.GET(healthEndpoint, s.healthHandler)
+	router.GET(readyEndpoint, s.readyHandler)
 
-	glog.Fatal(s.Server.ListenAndServe())
-	glog.Flush()
+	if *certFile == "" || *keyFile == "" {
+		if *clientCAFile != "" {
+			logging.Exit("clientCAFile requires certFile and keyFile to be set")
+		}
+		slog.Info("resorting to serving HTTP because there was no certificate and key defined", "listen", *listen)
+		logging.Exit("error serving HTTP", "error", s.Server.ListenAndServe())
+	}
+	if *clientCAFile != "" {
+		tlsConfig, err := clientAuthConfig(*clientCAFile)
+		if err != nil {
+			logging.Exit("unable to read client CA file", "file", *clientCAFile, "error", err)
+		}
+		s.Server.TLSConfig = tlsConfig
+	}
+	slog.Info("serving HTTPS", "listen", *listen, "clientCerts", *clientCAFile != "")
+	logging.Exit("error serving HTTPS", "error", s.Server.ListenAndServeTLS(*certFile, *keyFile))
 }
@@ -0,0 +1,685 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"image/png"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/hb9tf/spectre/export"
+	"github.com/hb9tf/spectre/extraction"
+	"github.com/hb9tf/spectre/filter"
+	"github.com/hb9tf/spectre/sdr"
+)
+
+// testStart is the start of the samples stored by newTestServer.
+var testStart = time.UnixMilli(1700000000000)
+
+// newTestServer returns a server storing in a sqlite DB which holds a sample per 10 Hz bin
+// from 100 Hz to 140 Hz in 3 consecutive one second intervals from the "rtlsdr" collector
+// "a". The handlers are registered like in main, the router is returned to send requests to.
+func newTestServer(t *testing.T) (*SpectreServer, *gin.Engine) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "spectre.db"))
+	if err != nil {
+		t.Fatalf("unable to open DB: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	samples := make(chan sdr.Sample, 12)
+	for interval := 0; interval < 3; interval++ {
+		for bin := int64(0); bin < 4; bin++ {
+			start := testStart.Add(time.Duration(interval) * time.Second)
+			db := float64(-100 + 10*bin)
+			samples <- sdr.Sample{Source: "rtlsdr", Identifier: "a", FreqLow: 100 + 10*bin, FreqHigh: 110 + 10*bin, FreqCenter: 105 + 10*bin, DBLow: db, DBHigh: db, DBAvg: db, SampleCount: 1, Start: start, End: start.Add(time.Second)}
+		}
+	}
+	close(samples)
+	if err := (&export.SQL{DB: db}).Write(context.Background(), samples); err != nil {
+		t.Fatalf("unable to store samples: %s", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	s := &SpectreServer{
+		DB:           db,
+		Samples:      newFanOut(),
+		Collectors:   &collectorTracker{lastSeen: map[string]time.Time{}},
+		RenderLimits: &extraction.RenderLimits{},
+	}
+	router.POST(collectEndpoint, s.authMiddleware, s.collectHandler)
+	router.GET(renderEndpoint, gzipMiddleware, s.renderHandler)
+	router.POST(renderEndpoint, gzipMiddleware, s.renderJSONHandler)
+	router.GET(statsEndpoint, s.statsHandler)
+	router.GET(sourcesEndpoint, s.sourcesHandler)
+	router.DELETE(samplesEndpoint, s.authMiddleware, s.deleteSamplesHandler)
+	router.GET(healthEndpoint, s.healthHandler)
+	router.GET(readyEndpoint, s.readyHandler)
+	return s, router
+}
+
+// serve sends the request to the router and returns the response.
+func serve(router http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func gzipped(t *testing.T, body string) io.Reader {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	if _, err := zw.Write([]byte(body)); err != nil {
+		t.Fatalf("unable to compress body: %s", err)
+	}
+	zw.Close()
+	return buf
+}
+
+func TestCollectHandler(t *testing.T) {
+	sample := `{"Source":"rtlsdr","Identifier":"a","FreqCenter":100}`
+	tests := []struct {
+		name        string
+		body        string
+		gzip        bool
+		apiKeys     []string
+		auth        string
+		maxBodySize int64
+		rate        *rateLimiter
+		buffer      int
+		wantStatus  int
+		wantSamples int
+	}{
+		{name: "single sample", body: sample, buffer: 10, wantStatus: http.StatusOK, wantSamples: 1},
+		{name: "array", body: "[" + sample + "," + sample + "]", buffer: 10, wantStatus: http.StatusOK, wantSamples: 2},
+		{name: "gzip", body: "[" + sample + "," + sample + "]", gzip: true, buffer: 10, wantStatus: http.StatusOK, wantSamples: 2},
+		{name: "invalid JSON", body: "[", buffer: 10, wantStatus: http.StatusBadRequest},
+		{name: "valid API key", body: sample, apiKeys: []string{"k1", "k2"}, auth: "Bearer k2", buffer: 10, wantStatus: http.StatusOK, wantSamples: 1},
+		{name: "invalid API key", body: sample, apiKeys: []string{"k1"}, auth: "Bearer k2", buffer: 10, wantStatus: http.StatusUnauthorized},
+		{name: "missing API key", body: sample, apiKeys: []string{"k1"}, buffer: 10, wantStatus: http.StatusUnauthorized},
+		{name: "body too large", body: "[" + sample + "," + sample + "]", maxBodySize: int64(len(sample)), buffer: 10, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "uncompressed body too large", body: "[" + sample + "," + sample + "]", gzip: true, maxBodySize: int64(len(sample)), buffer: 10, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "rate limited", body: "[" + sample + "," + sample + "]", rate: &rateLimiter{rate: 1, burst: 1, buckets: map[string]*bucket{}}, buffer: 10, wantStatus: http.StatusTooManyRequests},
+		{name: "buffer full", body: "[" + sample + "," + sample + "]", buffer: 1, wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, router := newTestServer(t)
+			s.APIKeys = tc.apiKeys
+			s.MaxBodySize = tc.maxBodySize
+			s.RateLimiter = tc.rate
+			sub := s.Samples.subscribe("test", tc.buffer, true)
+
+			var body io.Reader = strings.NewReader(tc.body)
+			if tc.gzip {
+				body = gzipped(t, tc.body)
+			}
+			req := httptest.NewRequest(http.MethodPost, collectEndpoint, body)
+			if tc.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+			if tc.auth != "" {
+				req.Header.Set("Authorization", tc.auth)
+			}
+			w := serve(router, req)
+			if w.Code != tc.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tc.wantStatus)
+			}
+			if got := len(sub.samples); got != tc.wantSamples {
+				t.Errorf("%d samples were enqueued, want %d", got, tc.wantSamples)
+			}
+		})
+	}
+}
+
+func TestEnqueueDedup(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.Dedup = &filter.FilterDedup{Size: 10}
+	live := s.Samples.subscribe("live", 10, false)
+	exported := s.Samples.subscribe("exporter", 3, true)
+	sample := func(source, identifier string) sdr.Sample {
+		return sdr.Sample{Source: source, Identifier: identifier, FreqCenter: 100, Start: testStart}
+	}
+	a, b, c := sample("rtlsdr", "a"), sample("rtlsdr", "b"), sample("hackrf", "a")
+	d, e, f, g := sample("rtlsdr", "d"), sample("rtlsdr", "e"), sample("rtlsdr", "f"), sample("rtlsdr", "g")
+
+	// The steps run in order, each one sees the samples enqueued by the ones before.
+	steps := []struct {
+		name    string
+		samples []sdr.Sample
+		wantOK  bool
+		want    []sdr.Sample
+	}{
+		{name: "duplicate in request", samples: []sdr.Sample{a, b, a}, wantOK: true, want: []sdr.Sample{a, b}},
+		{name: "resent request", samples: []sdr.Sample{a, b}, wantOK: true},
+		{name: "other source", samples: []sdr.Sample{c, a}, wantOK: true, want: []sdr.Sample{c}},
+		{name: "rejected request", samples: []sdr.Sample{d, e, f, g}},
+		{name: "resent rejected request", samples: []sdr.Sample{d, e, a}, wantOK: true, want: []sdr.Sample{d, e}},
+	}
+	for _, step := range steps {
+		if got := s.enqueue(step.samples); got != step.wantOK {
+			t.Errorf("%s: enqueue() = %t, want %t", step.name, got, step.wantOK)
+		}
+		// Live connections see the same samples as the exporter.
+		for _, sub := range []*subscriber{exported, live} {
+			var got []sdr.Sample
+			for len(sub.samples) > 0 {
+				got = append(got, <-sub.samples)
+			}
+			if !reflect.DeepEqual(got, step.want) {
+				t.Errorf("%s: %s received %+v, want %+v", step.name, sub.name, got, step.want)
+			}
+		}
+	}
+}
+
+func TestRenderHandler(t *testing.T) {
+	_, router := newTestServer(t)
+	window := "&startTime=" + strconvMilli(testStart) + "&endTime=" + strconvMilli(testStart.Add(time.Hour))
+	tests := []struct {
+		name            string
+		query           string
+		wantStatus      int
+		wantContentType string
+		wantSize        string
+	}{
+		{name: "png", query: "sdr=rtlsdr&identifier=a&imageType=png&addGrid=false" + window, wantStatus: http.StatusOK, wantContentType: "image/png", wantSize: "4x3"},
+		{name: "unknown type defaults to jpeg", query: "sdr=rtlsdr&identifier=a&imageType=gif&addGrid=false" + window, wantStatus: http.StatusOK, wantContentType: "image/jpeg", wantSize: "4x3"},
+		{name: "json", query: "sdr=rtlsdr&identifier=a&imageType=json" + window, wantStatus: http.StatusOK, wantContentType: "application/json; charset=utf-8", wantSize: "4x3"},
+		{name: "no samples", query: "sdr=rtlsdr&identifier=b&imageType=png" + window, wantStatus: http.StatusNotFound},
+		{name: "transparent jpeg", query: "sdr=rtlsdr&identifier=a&imageType=jpg&transparent=true" + window, wantStatus: http.StatusBadRequest},
+		{name: "invalid color", query: "sdr=rtlsdr&identifier=a&gridColor=red" + window, wantStatus: http.StatusBadRequest},
+		{name: "invalid last", query: "sdr=rtlsdr&identifier=a&last=-1h", wantStatus: http.StatusBadRequest},
+		{name: "invalid timezone", query: "sdr=rtlsdr&identifier=a&tz=Mars/Olympus" + window, wantStatus: http.StatusBadRequest},
+		{name: "unknown backend", query: "sdr=rtlsdr&identifier=a&backend=site1" + window, wantStatus: http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serve(router, httptest.NewRequest(http.MethodGet, renderEndpoint+"?"+tc.query, nil))
+			if w.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tc.wantStatus, w.Body)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			if got := w.Header().Get("Content-Type"); got != tc.wantContentType {
+				t.Errorf("content type = %q, want %q", got, tc.wantContentType)
+			}
+			if got := w.Header().Get(imageSizeHeader); got != tc.wantSize {
+				t.Errorf("image size = %q, want %q", got, tc.wantSize)
+			}
+		})
+	}
+}
+
+func TestRenderJSONHandler(t *testing.T) {
+	_, router := newTestServer(t)
+	window := `"startTime":"` + testStart.Format(time.RFC3339) + `","endTime":"` + testStart.Add(time.Hour).Format(time.RFC3339) + `"`
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantSize   string
+	}{
+		{name: "png", body: `{"imageType":"png","filter":{"sdr":"rtlsdr","identifier":"a",` + window + `},"image":{"imgWidth":2}}`, wantStatus: http.StatusOK, wantSize: "2x3"},
+		{name: "no samples", body: `{"imageType":"png","filter":{"sdr":"rtlsdr","identifier":"b",` + window + `}}`, wantStatus: http.StatusNotFound},
+		{name: "invalid JSON", body: `{"imageType":`, wantStatus: http.StatusBadRequest},
+		{name: "unknown backend", body: `{"backend":"site1"}`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serve(router, httptest.NewRequest(http.MethodPost, renderEndpoint, strings.NewReader(tc.body)))
+			if w.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tc.wantStatus, w.Body)
+			}
+			// The grid is drawn by default, so only check the waterfall size.
+			if got := w.Header().Get(imageSizeHeader); tc.wantSize != "" && got != tc.wantSize {
+				t.Errorf("image size = %q, want %q", got, tc.wantSize)
+			}
+		})
+	}
+}
+
+func TestRenderHandlerGzip(t *testing.T) {
+	_, router := newTestServer(t)
+	query := "?sdr=rtlsdr&identifier=a&startTime=" + strconvMilli(testStart) + "&endTime=" + strconvMilli(testStart.Add(time.Hour))
+	tests := []struct {
+		imageType    string
+		wantEncoding string
+	}{
+		{imageType: "json", wantEncoding: "gzip"},
+		{imageType: "svg", wantEncoding: "gzip"},
+		{imageType: "png"}, // already compressed
+	}
+	for _, tc := range tests {
+		t.Run(tc.imageType, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, renderEndpoint+query+"&imageType="+tc.imageType, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			w := serve(router, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tc.wantEncoding {
+				t.Fatalf("content encoding = %q, want %q", got, tc.wantEncoding)
+			}
+			body := io.Reader(w.Body)
+			if tc.wantEncoding == "gzip" {
+				zr, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatalf("response isn't compressed: %s", err)
+				}
+				body = zr
+			}
+			content, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("unable to read response: %s", err)
+			}
+			if tc.imageType == "png" {
+				if _, err := png.Decode(bytes.NewReader(content)); err != nil {
+					t.Errorf("response isn't a PNG image: %s", err)
+				}
+			} else if len(content) == 0 {
+				t.Error("response is empty")
+			}
+		})
+	}
+}
+
+func TestStatsAndSourcesHandlers(t *testing.T) {
+	_, router := newTestServer(t)
+	w := serve(router, httptest.NewRequest(http.MethodGet, statsEndpoint, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("stats status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var stats map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("unable to decode stats: %s", err)
+	}
+	if stats["sampleCount"] != 12.0 || stats["lowFreq"] != 100.0 || stats["highFreq"] != 140.0 {
+		t.Errorf("stats = %v, want 12 samples from 100 to 140 Hz", stats)
+	}
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, sourcesEndpoint, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("sources status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var sources []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &sources); err != nil {
+		t.Fatalf("unable to decode sources: %s", err)
+	}
+	if len(sources) != 1 || sources[0]["source"] != "rtlsdr" || sources[0]["identifier"] != "a" {
+		t.Errorf("sources = %v, want rtlsdr collector a", sources)
+	}
+}
+
+func TestDeleteSamplesHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		apiKeys     []string
+		query       string
+		wantStatus  int
+		wantDeleted float64
+	}{
+		{name: "delete", apiKeys: []string{"k"}, query: "?identifier=a", wantStatus: http.StatusOK, wantDeleted: 12},
+		{name: "other source", apiKeys: []string{"k"}, query: "?identifier=a&source=hackrf", wantStatus: http.StatusOK},
+		{name: "missing identifier", apiKeys: []string{"k"}, wantStatus: http.StatusBadRequest},
+		{name: "without API keys", query: "?identifier=a", wantStatus: http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, router := newTestServer(t)
+			s.APIKeys = tc.apiKeys
+			req := httptest.NewRequest(http.MethodDelete, samplesEndpoint+tc.query, nil)
+			req.Header.Set("Authorization", "Bearer k")
+			w := serve(router, req)
+			if w.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			var resp map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("unable to decode response: %s", err)
+			}
+			if resp["deletedCount"] != tc.wantDeleted {
+				t.Errorf("deleted %v samples, want %g", resp["deletedCount"], tc.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestHealthAndReadyHandlers(t *testing.T) {
+	s, router := newTestServer(t)
+	for _, endpoint := range []string{healthEndpoint, readyEndpoint} {
+		if w := serve(router, httptest.NewRequest(http.MethodGet, endpoint, nil)); w.Code != http.StatusOK {
+			t.Errorf("%s status = %d, want %d", endpoint, w.Code, http.StatusOK)
+		}
+	}
+	s.DB.Close()
+	if w := serve(router, httptest.NewRequest(http.MethodGet, readyEndpoint, nil)); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("%s status with a closed DB = %d, want %d", readyEndpoint, w.Code, http.StatusServiceUnavailable)
+	}
+	if w := serve(router, httptest.NewRequest(http.MethodGet, healthEndpoint, nil)); w.Code != http.StatusOK {
+		t.Errorf("%s status with a closed DB = %d, want %d", healthEndpoint, w.Code, http.StatusOK)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := &rateLimiter{rate: 1000, burst: 10, buckets: map[string]*bucket{}}
+	if !l.allow(map[string]int{"a": 10, "b": 5}) {
+		t.Fatal("allow() rejected samples within the burst")
+	}
+	// Nothing is taken if one of the collectors exceeds its rate.
+	if l.allow(map[string]int{"a": 5, "b": 5}) {
+		t.Fatal("allow() accepted samples above the burst")
+	}
+	if !l.allow(map[string]int{"b": 5}) {
+		t.Fatal("allow() rejected samples although the bucket wasn't taken from")
+	}
+	time.Sleep(10 * time.Millisecond) // refills 10 tokens
+	if !l.allow(map[string]int{"a": 10}) {
+		t.Error("allow() rejected samples after the bucket was refilled")
+	}
+}
+
+func TestCollectorTracker(t *testing.T) {
+	tracker := &collectorTracker{lastSeen: map[string]time.Time{}}
+	tracker.seen("a")
+	tracker.seen("b")
+	tracker.seen("a")
+	if got := tracker.active(time.Hour); got != 2 {
+		t.Errorf("active() = %d, want 2", got)
+	}
+	tracker.lastSeen["a"] = time.Now().Add(-2 * time.Hour)
+	if got := tracker.active(time.Hour); got != 1 {
+		t.Errorf("active() = %d after a collector went silent, want 1", got)
+	}
+	if _, ok := tracker.lastSeen["a"]; ok {
+		t.Error("inactive collector hasn't been forgotten")
+	}
+}
+
+func TestReadAPIKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr bool
+	}{
+		{name: "keys", content: "# collectors\nk1\n\n  k2  \n", want: []string{"k1", "k2"}},
+		{name: "only comments", content: "# no keys\n", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys")
+			if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+				t.Fatalf("unable to write keys: %s", err)
+			}
+			got, err := readAPIKeys(path)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("readAPIKeys() error = %v, want error: %t", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("readAPIKeys() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseBackends(t *testing.T) {
+	existing := filepath.Join(t.TempDir(), "site1.db")
+	if err := os.WriteFile(existing, nil, 0o600); err != nil {
+		t.Fatalf("unable to create DB file: %s", err)
+	}
+	tests := []struct {
+		name      string
+		spec      string
+		wantNames []string
+		wantErr   bool
+	}{
+		{name: "empty", spec: ""},
+		{name: "sqlite and mysql", spec: "site1=sqlite:" + existing + ", site2=mysql:user@tcp(127.0.0.1:3306)/spectre", wantNames: []string{"site1", "site2"}},
+		{name: "missing name", spec: "=sqlite:" + existing, wantErr: true},
+		{name: "missing DSN", spec: "site1=sqlite", wantErr: true},
+		{name: "duplicate name", spec: "site1=sqlite:" + existing + ",site1=sqlite:" + existing, wantErr: true},
+		{name: "missing sqlite file", spec: "site1=sqlite:" + existing + ".missing", wantErr: true},
+		{name: "unknown driver", spec: "site1=postgres:localhost", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseBackends(tc.spec)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("parseBackends(%q) error = %v, want error: %t", tc.spec, err, tc.wantErr)
+			}
+			if len(got) != len(tc.wantNames) {
+				t.Errorf("parseBackends(%q) opened %d DBs, want %d", tc.spec, len(got), len(tc.wantNames))
+			}
+			for _, name := range tc.wantNames {
+				db, ok := got[name]
+				if !ok {
+					t.Errorf("backend %q is missing", name)
+					continue
+				}
+				db.Close()
+			}
+		})
+	}
+}
+
+func TestDecodeSamples(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantCount int
+		wantErr   bool
+	}{
+		{name: "object", body: ` {"Identifier":"a"}`, wantCount: 1},
+		{name: "array", body: `[{"Identifier":"a"},{"Identifier":"b"}]`, wantCount: 2},
+		{name: "empty array", body: `[]`},
+		{name: "invalid object", body: `{"Identifier":1}`, wantErr: true},
+		{name: "empty body", body: "", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := decodeSamples([]byte(tc.body))
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("decodeSamples(%q) error = %v, want error: %t", tc.body, err, tc.wantErr)
+			}
+			if len(got) != tc.wantCount {
+				t.Errorf("decodeSamples(%q) returned %d samples, want %d", tc.body, len(got), tc.wantCount)
+			}
+		})
+	}
+}
+
+func TestParseLast(t *testing.T) {
+	before := time.Now()
+	start, end, err := parseLast("1h")
+	if err != nil {
+		t.Fatalf("parseLast() failed: %s", err)
+	}
+	if end.Before(before) || end.Sub(start) != time.Hour {
+		t.Errorf("parseLast(\"1h\") = %s - %s, want the hour up to now", start, end)
+	}
+	for _, raw := range []string{"", "1", "0s", "-1h"} {
+		if _, _, err := parseLast(raw); err == nil {
+			t.Errorf("parseLast(%q) succeeded, want error", raw)
+		}
+	}
+}
+
+func TestParseGridColors(t *testing.T) {
+	colors, err := parseGridColors("", "#ff0000", "00000000")
+	if err != nil {
+		t.Fatalf("parseGridColors() failed: %s", err)
+	}
+	want := extraction.DefaultGridColors
+	want.Text.R, want.Text.G, want.Text.B, want.Text.A = 255, 0, 0, 255
+	want.Background.R, want.Background.G, want.Background.B, want.Background.A = 0, 0, 0, 0
+	if colors != want {
+		t.Errorf("parseGridColors() = %+v, want %+v", colors, want)
+	}
+	if _, err := parseGridColors("red", "", ""); err == nil {
+		t.Error("parseGridColors() with an invalid color succeeded, want error")
+	}
+}
+
+func TestSourceLabel(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{source: "rtlsdr", want: "rtlsdr"},
+		{source: "hackrf", want: "hackrf"},
+		{source: "replay", want: "replay"},
+		{source: "", want: otherSource},
+		{source: "RTLSDR", want: otherSource},
+		{source: "made-up-source-1234", want: otherSource},
+	}
+	for _, tc := range tests {
+		if got := sourceLabel(tc.source); got != tc.want {
+			t.Errorf("sourceLabel(%q) = %q, want %q", tc.source, got, tc.want)
+		}
+	}
+}
+
+// newTestCert returns a certificate for the key signed by parent (self-signed when nil) and its PEM encoding.
+func newTestCert(t *testing.T, template *x509.Certificate, parent *tls.Certificate) (tls.Certificate, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err)
+	}
+	parentCert, parentKey := template, any(key)
+	if parent != nil {
+		parentCert, parentKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("unable to create certificate: %s", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("unable to parse certificate: %s", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestClientAuthConfig(t *testing.T) {
+	newCA := func(name string) (tls.Certificate, []byte) {
+		return newTestCert(t, &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}, nil)
+	}
+	newClientCert := func(ca tls.Certificate) tls.Certificate {
+		cert, _ := newTestCert(t, &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "collector"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, &ca)
+		return cert
+	}
+	ca, caPEM := newCA("spectre test CA")
+	otherCA, _ := newCA("other CA")
+	validCert := newClientCert(ca)
+	otherCert := newClientCert(otherCA)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatalf("unable to write CA file: %s", err)
+	}
+	tlsConfig, err := clientAuthConfig(caFile)
+	if err != nil {
+		t.Fatalf("clientAuthConfig() error = %v", err)
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.TLS = tlsConfig
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+	ts.StartTLS()
+	defer ts.Close()
+
+	tests := []struct {
+		name    string
+		cert    *tls.Certificate
+		wantErr bool
+	}{
+		{name: "valid client certificate", cert: &validCert},
+		{name: "no client certificate", wantErr: true},
+		{name: "client certificate of another CA", cert: &otherCert, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := ts.Client()
+			transport := client.Transport.(*http.Transport).Clone()
+			// Always present the certificate, even if it isn't signed by one of the accepted CAs.
+			transport.TLSClientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				if tc.cert == nil {
+					return &tls.Certificate{}, nil
+				}
+				return tc.cert, nil
+			}
+			client.Transport = transport
+			defer transport.CloseIdleConnections()
+
+			resp, err := client.Get(ts.URL)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %t", err, tc.wantErr)
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+		})
+	}
+}
+
+func TestClientAuthConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.pem")
+	if err := os.WriteFile(invalid, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("unable to write file: %s", err)
+	}
+	for _, path := range []string{invalid, filepath.Join(dir, "missing.pem")} {
+		if _, err := clientAuthConfig(path); err == nil {
+			t.Errorf("clientAuthConfig(%q) error = nil, want error", path)
+		}
+	}
+}
+
+// strconvMilli formats the time as Unix milliseconds for query parameters.
+func strconvMilli(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}